@@ -1,21 +1,14 @@
 package main
 
 import (
-	"flag"
-	"fmt"
-	"github.com/vulder/remark_code_injector/internal/html_processor"
-	"log"
+	"context"
+	"os"
+
+	"github.com/vulder/remark_code_injector/internal/cli"
 )
 
+// Compatibility shim for scripts that still use the old tool, new scripts
+// should use "remark-inject-code deps" instead.
 func main() {
-	filepathPtr := flag.String("file", "", "Path to HTML document")
-	codeRoot := flag.String("code-root", "", "Root folder where code files are stored.")
-
-	flag.Parse()
-
-	if *filepathPtr == "" {
-		log.Fatal("User did not provide a filepath to check.")
-	}
-
-	fmt.Print(html_processor.FindDependencies(*filepathPtr, *codeRoot))
+	os.Exit(cli.RunLegacyDeps(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
 }
@@ -1,30 +1,25 @@
 package main
 
 import (
-	"flag"
-	"github.com/vulder/remark_code_injector/internal/html_processor"
-	"strings"
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/vulder/remark_code_injector/internal/cli"
 )
 
 func main() {
-	inputFilepathPtr := flag.String("in", "index_raw.html", "Input file")
-	outputFilepathPtr := flag.String("out", "nil", "Output file")
-	codeRoot := flag.String("code-root", "", "Root folder where code files are stored.")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 
-	flag.Parse()
-	outputFilepath := *outputFilepathPtr
-	if outputFilepath == "nil" {
-		// If the user did not provided an output filename, try to infer the name
-		// from the input file.
-		outputFilepath = getDefaultOutputFile(*inputFilepathPtr)
+	args := os.Args[1:]
+	var exitCode int
+	if cli.IsLegacyInvocation(args) {
+		// Support the old "-in index_raw.html -out index.html" command line.
+		exitCode = cli.RunLegacyBuild(ctx, args, os.Stdout, os.Stderr)
+	} else {
+		exitCode = cli.Run(ctx, args, os.Stdout, os.Stderr)
 	}
 
-	html_processor.ProcessHTMLDocument(*inputFilepathPtr, outputFilepath, *codeRoot)
-}
-
-func getDefaultOutputFile(inputFile string) string {
-	if strings.Contains(inputFile, "_raw") {
-		return strings.ReplaceAll(inputFile, "_raw", "")
-	}
-	return "index.html"
+	stop()
+	os.Exit(exitCode)
 }
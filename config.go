@@ -1,5 +1,565 @@
 package remark_code_injector
 
-func Config() string {
-	return "remark_code_injector config"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Config
+//
+// A project can put a config file next to its documents, so the settings do
+// not have to be passed on every run. The config file is found by walking up
+// from the document, e.g.,
+//
+//	{
+//	  "code_roots": ["code/"],
+//	  "aliases": {"sdk": "../sdk/go"},
+//	  "allow": ["../shared"],
+//	  "symlinks": "within-roots",
+//	  "options": {"indent": 2, "comments": false},
+//	  "languages": {"tpp": "cpp"},
+//	  "comment_syntax": {"jinja": {"block": ["{#", "#}"]}},
+//	  "output": "{dir}/{name}{ext}",
+//	  "error_policy": "continue"
+//	}
+//
+// Relative paths are relative to the folder of the config file.
+//===----------------------------------------------------------------------===//
+
+// Name of the config file that is searched for next to a document and in all
+// its parent folders.
+const ConfigFileName = "remark-inject.json"
+
+// Config holds the project settings from a config file.
+type Config struct {
+	// Path of the config file, empty if the config was not read from a file
+	Path string
+	// Folders that contain the code files, in the order they are searched
+	CodeRoots []string
+	// Maps alias names to folders, so DSL commands can use "@alias/file"
+	Aliases map[string]string
+	// Folders outside of the code roots that DSL commands may read from
+	AllowedDirs   []string
+	SymlinkPolicy SymlinkPolicy
+	// Options used for all DSL commands unless a command overrides them
+	CodeGenOptions CodeGenOptions
+	// Maps file extensions without the dot to code block languages
+	Languages map[string]string
+	// Maps code block languages to their comment syntax
+	Comments map[string]CommentSyntax
+	// Pattern for the output file of a document, see OutputFile
+	Output      string
+	ErrorPolicy ErrorPolicy
+}
+
+var errorPolicyNames = map[string]ErrorPolicy{
+	"continue": ContinueOnError,
+	"stop":     StopOnError,
+	"warn":     WarnOnError,
+}
+
+// Parses the name of an error policy, i.e., continue, stop or warn.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	policy, found := errorPolicyNames[strings.ToLower(name)]
+	if !found {
+		return ContinueOnError, fmt.Errorf("unknown error policy %q, expected continue, stop or warn", name)
+	}
+	return policy, nil
+}
+
+var symlinkPolicyNames = map[string]SymlinkPolicy{
+	"within-roots": SymlinksWithinRoots,
+	"follow":       SymlinksFollow,
+	"deny":         SymlinksDeny,
+}
+
+// Parses the name of a symlink policy, i.e., within-roots, follow or deny.
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	policy, found := symlinkPolicyNames[strings.ToLower(name)]
+	if !found {
+		return SymlinksWithinRoots, fmt.Errorf("unknown symlink policy %q, expected within-roots, follow or deny", name)
+	}
+	return policy, nil
+}
+
+// Searches the config file for a document, starting in the folder of the
+// document and walking up to the root. Returns an empty path if there is no
+// config file.
+func FindConfig(document string) (string, error) {
+	dir, err := filepath.Abs(filepath.Dir(document))
+	if err != nil {
+		return "", err
+	}
+	for {
+		candidate := filepath.Join(dir, ConfigFileName)
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Reads and validates a config file. Problems in the file are returned as
+// Diagnostics that point to the offending line.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+	return ParseConfig(path, data)
+}
+
+// Parses and validates the content of a config file. The path is used to
+// resolve relative paths and for diagnostics.
+func ParseConfig(path string, data []byte) (*Config, error) {
+	cp := &configParser{path: path, data: data}
+	config := &Config{Path: path}
+
+	members, err := cp.readObject(data, 0)
+	if err != nil {
+		return nil, Diagnostics{cp.jsonError(err)}
+	}
+	seen := map[string]bool{}
+	for _, member := range members {
+		if seen[member.key] {
+			cp.errorAt(member.keyOffset, "duplicate key %q", member.key)
+			continue
+		}
+		seen[member.key] = true
+
+		switch member.key {
+		case "code_roots":
+			if folders, ok := cp.parseFolders(config, member); ok {
+				config.CodeRoots = folders
+			}
+		case "aliases":
+			cp.parseAliases(config, member)
+		case "allow":
+			if folders, ok := cp.parseFolders(config, member); ok {
+				config.AllowedDirs = folders
+			}
+		case "symlinks":
+			var name string
+			if cp.decode(member, &name, "a string") {
+				if config.SymlinkPolicy, err = ParseSymlinkPolicy(name); err != nil {
+					cp.errorAt(member.valueOffset, "%s", err)
+				}
+			}
+		case "options":
+			cp.parseOptions(config, member)
+		case "languages":
+			cp.parseLanguages(config, member)
+		case "comment_syntax":
+			cp.parseCommentSyntax(config, member)
+		case "output":
+			cp.parseOutput(config, member)
+		case "error_policy":
+			var name string
+			if cp.decode(member, &name, "a string") {
+				if config.ErrorPolicy, err = ParseErrorPolicy(name); err != nil {
+					cp.errorAt(member.valueOffset, "%s", err)
+				}
+			}
+		default:
+			cp.errorAt(member.keyOffset, "unknown key %q, expected one of code_roots, aliases, allow, symlinks, options, languages, comment_syntax, output or error_policy", member.key)
+		}
+	}
+
+	if len(cp.diags) > 0 {
+		return nil, cp.diags
+	}
+	return config, nil
+}
+
+// Returns the processor options for the config.
+func (c *Config) Options() Options {
+	options := Options{
+		CodeRoots:      c.CodeRoots,
+		Aliases:        c.Aliases,
+		AllowedDirs:    c.AllowedDirs,
+		SymlinkPolicy:  c.SymlinkPolicy,
+		CodeGenOptions: c.CodeGenOptions,
+		ErrorPolicy:    c.ErrorPolicy,
+		Languages:      c.Languages,
+		Comments:       c.Comments,
+	}
+	if len(c.CodeRoots) == 0 {
+		options.CodeRoot = c.dir()
+	}
+	return options
+}
+
+// Returns the folder of the config file, relative paths in the config are
+// relative to it.
+func (c *Config) dir() string {
+	if c.Path == "" {
+		return ""
+	}
+	return filepath.Dir(c.Path)
+}
+
+// Resolves a path from the config relative to the folder of the config file.
+func (c *Config) resolvePath(path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) || c.dir() == "" {
+		return path
+	}
+	return filepath.Join(c.dir(), path)
+}
+
+// Returns the output file for a document. The output pattern can use the
+// placeholders {dir} for the folder of the document, {name} for its name
+// without extension and "_raw" suffix, and {ext} for its extension. Without
+// a pattern, "_raw" is removed from the document name, or index.html is used
+// if the name does not contain "_raw".
+func (c *Config) OutputFile(document string) string {
+	if c.Output == "" {
+		if strings.Contains(document, "_raw") {
+			return strings.ReplaceAll(document, "_raw", "")
+		}
+		return "index.html"
+	}
+
+	ext := filepath.Ext(document)
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(document), ext), "_raw")
+	output := strings.NewReplacer("{dir}", filepath.ToSlash(filepath.Dir(document)), "{name}", name, "{ext}", ext).Replace(c.Output)
+	if strings.HasPrefix(c.Output, "{dir}") {
+		return filepath.FromSlash(output)
+	}
+	return c.resolvePath(output)
+}
+
+//===----------------------------------------------------------------------===//
+// Config parsing
+//
+// encoding/json does not tell where a value came from, so the config is
+// walked member by member with a json.Decoder, which knows the byte offset of
+// every key and value. The offsets are turned into lines and columns for the
+// diagnostics.
+//===----------------------------------------------------------------------===//
+
+type configParser struct {
+	path  string
+	data  []byte
+	diags Diagnostics
+}
+
+type jsonMember struct {
+	key   string
+	value json.RawMessage
+	// Byte offsets of the key and the value in the config file
+	keyOffset   int
+	valueOffset int
+}
+
+// Reads the members of a JSON object, base is the offset of data in the
+// config file.
+func (cp *configParser) readObject(data []byte, base int) ([]jsonMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, &configSyntaxError{base, "expected a JSON object"}
+	}
+
+	members := []jsonMember{}
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, offsetError(err, base)
+		}
+		key := keyTok.(string)
+		keyOffset := base + int(dec.InputOffset()) - len(key) - 2
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, offsetError(err, base)
+		}
+		valueOffset := base + int(dec.InputOffset()) - len(value)
+		members = append(members, jsonMember{key, value, keyOffset, valueOffset})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, offsetError(err, base)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &configSyntaxError{base + int(dec.InputOffset()), "unexpected data after the JSON object"}
+	}
+	return members, nil
+}
+
+// Describes a JSON document that is valid but not shaped like a config.
+type configSyntaxError struct {
+	offset int
+	msg    string
+}
+
+func (e *configSyntaxError) Error() string {
+	return e.msg
+}
+
+// Moves the offset of a JSON error by base, so it points into the file.
+func offsetError(err error, base int) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		syntaxErr.Offset += int64(base)
+	}
+	return err
+}
+
+// Turns an error of encoding/json into a diagnostic.
+func (cp *configParser) jsonError(err error) *Diagnostic {
+	var syntaxErr *json.SyntaxError
+	var configErr *configSyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return cp.diagnosticAt(int(syntaxErr.Offset), "invalid JSON: %s", syntaxErr)
+	case errors.As(err, &configErr):
+		return cp.diagnosticAt(configErr.offset, "invalid config: %s", configErr)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return cp.diagnosticAt(len(cp.data), "invalid JSON: unexpected end of the config")
+	default:
+		return cp.diagnosticAt(0, "invalid JSON: %s", err)
+	}
+}
+
+func (cp *configParser) diagnosticAt(offset int, format string, args ...interface{}) *Diagnostic {
+	if offset > len(cp.data) {
+		offset = len(cp.data)
+	}
+	before := cp.data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(before, '\n')
+	return &Diagnostic{
+		Severity: SeverityError,
+		Document: cp.path,
+		Line:     line,
+		Column:   column,
+		Msg:      fmt.Sprintf(format, args...),
+	}
+}
+
+func (cp *configParser) errorAt(offset int, format string, args ...interface{}) {
+	cp.diags = append(cp.diags, cp.diagnosticAt(offset, format, args...))
+}
+
+// Decodes the value of a member and reports a mismatching type, the
+// description names the expected type.
+func (cp *configParser) decode(member jsonMember, value interface{}, description string) bool {
+	if err := json.Unmarshal(member.value, value); err != nil {
+		cp.errorAt(member.valueOffset, "%s expects %s", member.key, description)
+		return false
+	}
+	return true
+}
+
+// Reads a JSON object whose values are strings, e.g., the aliases.
+func (cp *configParser) readStringMap(member jsonMember) ([]jsonMember, map[string]string, bool) {
+	members, err := cp.readObject(member.value, member.valueOffset)
+	if err != nil {
+		cp.errorAt(member.valueOffset, "%s expects an object", member.key)
+		return nil, nil, false
+	}
+
+	values := map[string]string{}
+	ok := true
+	for _, entry := range members {
+		var value string
+		if err := json.Unmarshal(entry.value, &value); err != nil || value == "" {
+			cp.errorAt(entry.valueOffset, "%s.%s expects a non-empty string", member.key, entry.key)
+			ok = false
+			continue
+		}
+		values[entry.key] = value
+	}
+	return members, values, ok
+}
+
+// Reads a list of folders and resolves them relative to the config.
+func (cp *configParser) parseFolders(config *Config, member jsonMember) ([]string, bool) {
+	var folders []string
+	if !cp.decode(member, &folders, "a list of folders") {
+		return nil, false
+	}
+	resolved := []string{}
+	for _, folder := range folders {
+		if folder == "" {
+			cp.errorAt(member.valueOffset, "%s must not contain empty folders", member.key)
+			return nil, false
+		}
+		resolved = append(resolved, config.resolvePath(folder))
+	}
+	return resolved, true
+}
+
+var aliasNameRgx = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (cp *configParser) parseAliases(config *Config, member jsonMember) {
+	members, aliases, _ := cp.readStringMap(member)
+	config.Aliases = map[string]string{}
+	for _, entry := range members {
+		if !aliasNameRgx.MatchString(entry.key) {
+			cp.errorAt(entry.keyOffset, "invalid alias name %q, aliases may only contain letters, digits, '_', '.' and '-'", entry.key)
+			continue
+		}
+		if folder, found := aliases[entry.key]; found {
+			config.Aliases[entry.key] = config.resolvePath(folder)
+		}
+	}
+}
+
+func (cp *configParser) parseOptions(config *Config, member jsonMember) {
+	members, err := cp.readObject(member.value, member.valueOffset)
+	if err != nil {
+		cp.errorAt(member.valueOffset, "options expects an object")
+		return
+	}
+	for _, entry := range members {
+		switch entry.key {
+		case "indent":
+			cp.decode(entry, &config.CodeGenOptions.IndentLevel, "a number")
+		case "comments":
+			var comments bool
+			if cp.decode(entry, &comments, "true or false") {
+				config.CodeGenOptions.RemoveComments = !comments
+			}
+		case "doc":
+			var doc bool
+			if cp.decode(entry, &doc, "true or false") {
+				config.CodeGenOptions.RemoveDoc = !doc
+			}
+		case "bodies":
+			cp.decodeChoice(entry, &config.CodeGenOptions.ElideBodies, "elide", "keep")
+		case "signature":
+			cp.decodeChoice(entry, &config.CodeGenOptions.SignatureOnly, "only", "full")
+		case "fields":
+			cp.decodeChoice(entry, &config.CodeGenOptions.ExportedFieldsOnly, "exported", "all")
+		case "errcheck":
+			var name string
+			if cp.decode(entry, &name, "a string") {
+				var err error
+				if config.CodeGenOptions.ErrChecks, err = ParseErrCheckMode(name); err != nil {
+					cp.errorAt(entry.valueOffset, "%s", err)
+				}
+			}
+		case "errcheck_placeholder":
+			cp.decode(entry, &config.CodeGenOptions.ErrCheckPlaceholder, "a string")
+		case "format":
+			cp.decodeChoice(entry, &config.CodeGenOptions.FormatGo, "gofmt", "none")
+		default:
+			cp.errorAt(entry.keyOffset, "unknown option %q, expected indent, comments, doc, bodies, signature, fields, errcheck, errcheck_placeholder or format", entry.key)
+		}
+	}
+}
+
+// Decodes an option that is switched on or off by one of two words.
+func (cp *configParser) decodeChoice(member jsonMember, value *bool, on string, off string) {
+	var word string
+	if err := json.Unmarshal(member.value, &word); err == nil {
+		switch word {
+		case on:
+			*value = true
+			return
+		case off:
+			*value = false
+			return
+		}
+	}
+	cp.errorAt(member.valueOffset, "%s expects %s or %s", member.key, on, off)
+}
+
+func (cp *configParser) parseLanguages(config *Config, member jsonMember) {
+	members, languages, _ := cp.readStringMap(member)
+	config.Languages = map[string]string{}
+	for _, entry := range members {
+		extension := strings.TrimPrefix(entry.key, ".")
+		if extension == "" {
+			cp.errorAt(entry.keyOffset, "languages expects file extensions as keys")
+			continue
+		}
+		if language, found := languages[entry.key]; found {
+			config.Languages[extension] = language
+		}
+	}
+}
+
+// Reads the comment syntax of languages, e.g.,
+// {"sql": {"line": "--", "block": ["/*", "*/"]}}.
+func (cp *configParser) parseCommentSyntax(config *Config, member jsonMember) {
+	members, err := cp.readObject(member.value, member.valueOffset)
+	if err != nil {
+		cp.errorAt(member.valueOffset, "comment_syntax expects an object")
+		return
+	}
+	config.Comments = map[string]CommentSyntax{}
+	for _, entry := range members {
+		var syntax struct {
+			Line  string   `json:"line"`
+			Block []string `json:"block"`
+		}
+		if err := json.Unmarshal(entry.value, &syntax); err != nil {
+			cp.errorAt(entry.valueOffset, "comment_syntax.%s expects an object with line and block", entry.key)
+			continue
+		}
+		if len(syntax.Block) != 0 && (len(syntax.Block) != 2 || syntax.Block[0] == "" || syntax.Block[1] == "") {
+			cp.errorAt(entry.valueOffset, "comment_syntax.%s.block expects the start and the end of a block comment", entry.key)
+			continue
+		}
+		if syntax.Line == "" && len(syntax.Block) == 0 {
+			cp.errorAt(entry.valueOffset, "comment_syntax.%s needs a line or a block comment", entry.key)
+			continue
+		}
+
+		cs := CommentSyntax{Line: syntax.Line}
+		if len(syntax.Block) == 2 {
+			cs.BlockStart, cs.BlockEnd = syntax.Block[0], syntax.Block[1]
+		}
+		config.Comments[entry.key] = cs
+	}
+}
+
+var placeholderRgx = regexp.MustCompile(`\{[^}]*\}`)
+
+func (cp *configParser) parseOutput(config *Config, member jsonMember) {
+	var pattern string
+	if !cp.decode(member, &pattern, "a string") {
+		return
+	}
+	unknown := []string{}
+	for _, placeholder := range placeholderRgx.FindAllString(pattern, -1) {
+		switch placeholder {
+		case "{dir}", "{name}", "{ext}":
+		default:
+			unknown = append(unknown, placeholder)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		cp.errorAt(member.valueOffset, "unknown placeholder %s in output, expected {dir}, {name} or {ext}", strings.Join(unknown, ", "))
+		return
+	}
+	if pattern == "" {
+		cp.errorAt(member.valueOffset, "output must not be empty")
+		return
+	}
+	config.Output = pattern
 }
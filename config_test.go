@@ -0,0 +1,142 @@
+package remark_code_injector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := `{
+  "code_roots": ["code/"],
+  "options": {"indent": 2, "comments": false, "doc": false, "bodies": "elide", "fields": "all", "errcheck": "fold", "format": "gofmt"},
+  "languages": {".tpp": "cpp", "h": "c"},
+  "comment_syntax": {"jinja": {"block": ["{#", "#}"]}, "nim": {"line": "#", "block": ["#[", "]#"]}},
+  "output": "{dir}/{name}.out{ext}",
+  "error_policy": "warn"
+}`
+	config, err := ParseConfig(filepath.Join("project", ConfigFileName), []byte(data))
+	if err != nil {
+		t.Fatal("Could not parse config:", err)
+	}
+
+	if len(config.CodeRoots) != 1 || config.CodeRoots[0] != filepath.Join("project", "code") {
+		t.Log("code roots: ", config.CodeRoots)
+		t.Error("Code roots were not resolved relative to the config.")
+	}
+	if config.CodeGenOptions.IndentLevel != 2 || !config.CodeGenOptions.RemoveComments || !config.CodeGenOptions.RemoveDoc ||
+		!config.CodeGenOptions.ElideBodies || config.CodeGenOptions.ExportedFieldsOnly ||
+		config.CodeGenOptions.ErrChecks != FoldErrChecks || !config.CodeGenOptions.FormatGo {
+		t.Log("options: ", config.CodeGenOptions)
+		t.Error("Options were wrongly parsed.")
+	}
+	if config.Languages["tpp"] != "cpp" || config.Languages["h"] != "c" {
+		t.Log("languages: ", config.Languages)
+		t.Error("Languages were wrongly parsed.")
+	}
+	if config.Comments["jinja"] != (CommentSyntax{BlockStart: "{#", BlockEnd: "#}"}) ||
+		config.Comments["nim"] != (CommentSyntax{Line: "#", BlockStart: "#[", BlockEnd: "]#"}) {
+		t.Log("comments: ", config.Comments)
+		t.Error("Comment syntax was wrongly parsed.")
+	}
+	if config.ErrorPolicy != WarnOnError {
+		t.Error("Error policy was wrongly parsed.")
+	}
+	if output := config.OutputFile(filepath.Join("slides", "index_raw.html")); output != filepath.Join("slides", "index.out.html") {
+		t.Log("output: ", output)
+		t.Error("Output pattern was wrongly applied.")
+	}
+
+	options := config.Options()
+	if len(options.CodeRoots) != 1 || options.CodeRoots[0] != config.CodeRoots[0] || options.ErrorPolicy != WarnOnError {
+		t.Log("options: ", options)
+		t.Error("Processor options were wrongly derived.")
+	}
+}
+
+func TestParseConfigReportsLines(t *testing.T) {
+	data := `{
+  "code_roots": "code/",
+  "options": {
+    "indent": 2,
+    "tabs": true
+  },
+  "output": "{dir}/{title}.html",
+  "colour": "red"
+}`
+	_, err := ParseConfig(ConfigFileName, []byte(data))
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 4 {
+		t.Fatal("Config problems were not reported: ", err)
+	}
+
+	expectedLines := []int{2, 5, 7, 8}
+	for idx, d := range diags {
+		if d.Line != expectedLines[idx] || d.Document != ConfigFileName {
+			t.Log("diagnostic: ", d)
+			t.Errorf("Problem %d was reported in the wrong line.", idx)
+		}
+	}
+	if diags[1].Column != 5 || !strings.Contains(diags[1].Msg, `"tabs"`) {
+		t.Log("diagnostic: ", diags[1])
+		t.Error("Unknown option was wrongly reported.")
+	}
+}
+
+func TestParseConfigSyntaxError(t *testing.T) {
+	_, err := ParseConfig(ConfigFileName, []byte("{\n  \"output\": \"a\",\n  oops\n}"))
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 1 || diags[0].Line != 3 {
+		t.Error("Syntax error was not reported with its line: ", err)
+	}
+
+	_, err = ParseConfig(ConfigFileName, []byte("[]"))
+	if diags, ok := err.(Diagnostics); !ok || len(diags) != 1 {
+		t.Error("Config that is not an object was accepted: ", err)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "slides", "part1")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(configPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := FindConfig(filepath.Join(nested, "index_raw.html"))
+	if err != nil || found != configPath {
+		t.Log("found: ", found, " err: ", err)
+		t.Error("Config was not found in a parent folder.")
+	}
+}
+
+func TestDefaultOutputFile(t *testing.T) {
+	config := &Config{}
+	if output := config.OutputFile("slides/index_raw.html"); output != "slides/index.html" {
+		t.Error("_raw was not removed from the output file: ", output)
+	}
+	if output := config.OutputFile("slides.html"); output != "index.html" {
+		t.Error("Default output file was wrong: ", output)
+	}
+}
+
+func TestParseConfigSandbox(t *testing.T) {
+	data := `{"allow": ["../shared"], "symlinks": "deny"}`
+	config, err := ParseConfig(filepath.Join("project", ConfigFileName), []byte(data))
+	if err != nil {
+		t.Fatal("Could not parse config:", err)
+	}
+	if len(config.AllowedDirs) != 1 || config.AllowedDirs[0] != "shared" || config.SymlinkPolicy != SymlinksDeny {
+		t.Log("allowed: ", config.AllowedDirs, " symlinks: ", config.SymlinkPolicy)
+		t.Error("Sandbox settings were wrongly parsed.")
+	}
+
+	if _, err := ParseConfig(ConfigFileName, []byte(`{"symlinks": "sometimes"}`)); err == nil {
+		t.Error("Unknown symlink policy was accepted.")
+	}
+}
@@ -1,10 +1,3 @@
 module github.com/vulder/remark_code_injector
 
 go 1.19
-
-require github.com/Flaque/filet v0.0.0-20190209224823-fc4d33cfcf93
-
-require (
-	github.com/spf13/afero v1.3.2 // indirect
-	golang.org/x/text v0.3.8 // indirect
-)
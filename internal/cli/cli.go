@@ -0,0 +1,314 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+)
+
+//===----------------------------------------------------------------------===//
+// CLI
+//
+// Implements the remark-inject-code command line tool. The tool is split into
+// subcommands that share the global flags, e.g.,
+//
+//	remark-inject-code build -code-root code/ index_raw.html
+//
+// Exit codes are the same for all subcommands: ExitOK if everything worked,
+// ExitFailure if a document had errors, and ExitUsage for invalid arguments.
+//===----------------------------------------------------------------------===//
+
+const programName = "remark-inject-code"
+
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+type command struct {
+	name string
+	// Synopsis of the positional arguments
+	args    string
+	summary string
+	run     func(app *app, cmd *command, args []string) int
+}
+
+// Is filled in init to break the initialization cycle between the commands
+// and the help command that lists them.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"build", "document...", "Replaces the DSL commands of documents with code and writes the result", runBuild},
+		{"check", "document...", "Reports problems in the DSL commands and stale output files without writing anything", runCheck},
+		{"deps", "document...", "Prints the code files the documents depend on", runDeps},
+		{"fmt", "document...", "Rewrites the DSL commands of documents into their canonical form", runFmt},
+		{"list", "document...", "Lists the DSL commands of documents", runList},
+		{"lint", "[document...]", "Reports problems with the code_block markers of the code roots and the blocks documents reference", runLint},
+		{"migrate", "document...", "Turns insert_code line ranges into rev_insert_code commands with code_block markers", runMigrate},
+		{"lock", "document...", "Records the code snippets of documents in their lock files", runLock},
+		{"verify", "document...", "Reports snippets whose code changed since the lock files were written", runVerify},
+		{"watch", "document...", "Builds documents and rebuilds them whenever they or their code files change", runWatch},
+		{"help", "[command]", "Shows the help of a command", runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// app holds the state shared by all subcommands of a single run.
+type app struct {
+	ctx    context.Context
+	stdout io.Writer
+	stderr io.Writer
+
+	globals globalFlags
+	// Projects by the path of their config file, so all documents of a
+	// project share the same processor and code cache.
+	projects map[string]*project
+}
+
+// Flags that are accepted by all subcommands. Flags that were set on the
+// command line override the values of the config file.
+type globalFlags struct {
+	config      string
+	codeRoots   stringList
+	aliases     stringList
+	allowed     stringList
+	symlinks    string
+	errorPolicy string
+	workers     int
+	indexCache  string
+
+	// Names of the flags that were set on the command line
+	set map[string]bool
+}
+
+func (g *globalFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.config, "config", g.config, "Path of the config file. (default: "+remark_code_injector.ConfigFileName+" next to the document or in a parent folder)")
+	fs.Var(&g.codeRoots, "code-root", "Root folder where code files are stored. Can be repeated to search several folders in order.")
+	fs.Var(&g.aliases, "alias", "Path alias in the form name=folder, so DSL commands can use \"@name/file\". Can be repeated.")
+	fs.Var(&g.allowed, "allow", "Folder outside of the code roots that DSL commands may read from. Can be repeated.")
+	fs.StringVar(&g.symlinks, "symlinks", g.symlinks, "Which symlinks are followed: within-roots, follow or deny.")
+	fs.StringVar(&g.errorPolicy, "error-policy", g.errorPolicy, "What to do with erroneous DSL commands: continue, stop or warn.")
+	fs.IntVar(&g.workers, "workers", g.workers, "Number of DSL commands that are resolved concurrently, 0 uses the number of CPUs.")
+	fs.StringVar(&g.indexCache, "index-cache", g.indexCache, "Folder the index of code_block markers is cached in, \"off\" disables the cache. (default: "+programName+" in the user cache folder)")
+}
+
+// A flag that can be given several times.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// Checks the values of the global flags that do not depend on the config.
+func (g *globalFlags) validate() error {
+	if g.set["error-policy"] {
+		if _, err := remark_code_injector.ParseErrorPolicy(g.errorPolicy); err != nil {
+			return err
+		}
+	}
+	if g.set["symlinks"] {
+		if _, err := remark_code_injector.ParseSymlinkPolicy(g.symlinks); err != nil {
+			return err
+		}
+	}
+	for _, alias := range g.aliases {
+		if name, dir, found := strings.Cut(alias, "="); !found || name == "" || dir == "" {
+			return fmt.Errorf("invalid alias %q, expected name=folder", alias)
+		}
+	}
+	if g.workers < 0 {
+		return fmt.Errorf("-workers must not be negative")
+	}
+	return nil
+}
+
+// Applies the flags that were set on the command line to the options from
+// the config.
+func (g *globalFlags) override(options *remark_code_injector.Options) {
+	if g.set["code-root"] {
+		options.CodeRoot = ""
+		options.CodeRoots = g.codeRoots
+	}
+	if g.set["alias"] {
+		aliases := map[string]string{}
+		for name, dir := range options.Aliases {
+			aliases[name] = dir
+		}
+		for _, alias := range g.aliases {
+			name, dir, _ := strings.Cut(alias, "=")
+			aliases[strings.TrimPrefix(name, "@")] = dir
+		}
+		options.Aliases = aliases
+	}
+	if g.set["allow"] {
+		options.AllowedDirs = append(append([]string{}, options.AllowedDirs...), g.allowed...)
+	}
+	if g.set["symlinks"] {
+		options.SymlinkPolicy, _ = remark_code_injector.ParseSymlinkPolicy(g.symlinks)
+	}
+	if g.set["error-policy"] {
+		options.ErrorPolicy, _ = remark_code_injector.ParseErrorPolicy(g.errorPolicy)
+	}
+	if g.set["workers"] {
+		options.Workers = g.workers
+	}
+	switch {
+	case g.indexCache == "off":
+		options.IndexCacheDir = ""
+	case g.indexCache != "":
+		options.IndexCacheDir = g.indexCache
+	default:
+		options.IndexCacheDir = defaultIndexCache()
+	}
+}
+
+// Returns the folder the block index is cached in by default, or an empty
+// string if the user has no cache folder.
+func defaultIndexCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, programName)
+}
+
+// Runs the tool with the given arguments, without the program name, and
+// returns the exit code.
+func Run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	a := &app{ctx: ctx, stdout: stdout, stderr: stderr, projects: map[string]*project{}}
+	if len(args) == 0 {
+		a.printUsage(stderr)
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help":
+		a.printUsage(stdout)
+		return ExitOK
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "%s: unknown command %q\n", programName, args[0])
+		fmt.Fprintf(stderr, "Run '%s help' for usage.\n", programName)
+		return ExitUsage
+	}
+	return cmd.run(a, cmd, args[1:])
+}
+
+func (a *app) printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [arguments]\n\nCommands:\n", programName)
+	width := 0
+	for _, cmd := range commands {
+		if len(cmd.name) > width {
+			width = len(cmd.name)
+		}
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-*s %s\n", width, cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command.\n", programName)
+}
+
+// Creates the flag set of a subcommand, which already contains the global
+// flags.
+func (a *app) newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(programName+" "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		printCommandUsage(fs.Output(), cmd, fs)
+	}
+	a.globals.register(fs)
+	return fs
+}
+
+func printCommandUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n", programName, cmd.name, cmd.args, cmd.summary)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// Parses the flags of a subcommand. If the command should not run, e.g.,
+// because only the help was requested, ok is false and code is the exit code.
+func (a *app) parseFlags(fs *flag.FlagSet, args []string, needArgs bool) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK, false
+		}
+		return ExitUsage, false
+	}
+	a.globals.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		a.globals.set[f.Name] = true
+	})
+	if err := a.globals.validate(); err != nil {
+		fmt.Fprintf(a.stderr, "%s: %s\n", fs.Name(), err)
+		return ExitUsage, false
+	}
+	if needArgs && fs.NArg() == 0 {
+		fmt.Fprintf(a.stderr, "%s: no documents given\n", fs.Name())
+		fs.Usage()
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// Prints all diagnostics and returns whether there was an error among them.
+func (a *app) report(diags remark_code_injector.Diagnostics) bool {
+	for _, d := range diags {
+		fmt.Fprintln(a.stderr, d)
+	}
+	return diags.HasErrors()
+}
+
+// Prints an error of a document. Diagnostics are printed one per line, as
+// they already know where they belong to.
+func (a *app) reportError(document string, err error) {
+	if diags, ok := err.(remark_code_injector.Diagnostics); ok {
+		a.report(diags)
+		return
+	}
+	fmt.Fprintf(a.stderr, "%s: %s\n", document, err)
+}
+
+func runHelp(a *app, _ *command, args []string) int {
+	if len(args) == 0 {
+		a.printUsage(a.stdout)
+		return ExitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(a.stderr, "%s help: unknown command %q\n", programName, args[0])
+		return ExitUsage
+	}
+	if cmd.name == "help" {
+		a.printUsage(a.stdout)
+		return ExitOK
+	}
+	// Running a command with -h prints its usage including all its flags.
+	helpApp := &app{ctx: a.ctx, stdout: io.Discard, stderr: a.stdout, projects: a.projects}
+	cmd.run(helpApp, cmd, []string{"-h"})
+	return ExitOK
+}
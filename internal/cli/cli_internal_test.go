@@ -0,0 +1,544 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCode = "int main() {\n  return 0;\n}\n"
+
+// Creates a project with a code folder and a raw document in a temporary
+// folder and returns the path of the folder.
+func makeTestProject(t *testing.T, document string) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "code"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "code", "foo.cpp"), testCode)
+	writeTestFile(t, filepath.Join(dir, "index_raw.html"), document)
+	return dir
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func runTest(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestBuild(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1-3){2}")
+
+	code, _, stderr := runTest("build", "-code-root", filepath.Join(dir, "code"), filepath.Join(dir, "index_raw.html"))
+	if code != ExitOK {
+		t.Log("stderr: ", stderr)
+		t.Fatal("Build failed.")
+	}
+
+	expected := "# Slide\n```cpp\nint main() {\n* return 0;\n}\n```"
+	if output := readTestFile(t, filepath.Join(dir, "index.html")); output != expected {
+		t.Logf("output:\n%s\nbut expected\n%s", output, expected)
+		t.Error("Document was wrongly built.")
+	}
+}
+
+func TestBuildToStdout(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)")
+
+	code, stdout, _ := runTest("build", "-code-root", filepath.Join(dir, "code"), "-o", "-", filepath.Join(dir, "index_raw.html"))
+	if code != ExitOK || stdout != "```cpp\nint main() {\n```" {
+		t.Log("stdout: ", stdout)
+		t.Error("Document was not written to stdout.")
+	}
+}
+
+func TestBuildWithErrors(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(missing.cpp:1)")
+
+	code, _, stderr := runTest("build", "-code-root", filepath.Join(dir, "code"), filepath.Join(dir, "index_raw.html"))
+	if code != ExitFailure || !strings.Contains(stderr, "index_raw.html:1:") {
+		t.Log("stderr: ", stderr)
+		t.Error("Erroneous DSL command was not reported.")
+	}
+}
+
+func TestBuildRefusesToOverwriteDocument(t *testing.T) {
+	dir := makeTestProject(t, "")
+	document := filepath.Join(dir, "index_raw.html")
+
+	if code, _, _ := runTest("build", "-o", document, document); code != ExitUsage {
+		t.Error("Build did not refuse to overwrite the document.")
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	usageErrors := [][]string{
+		{},
+		{"unknown"},
+		{"build"},
+		{"build", "-unknown-flag", "doc.html"},
+		{"check", "-error-policy", "sometimes", "doc.html"},
+		{"build", "-o", "out.html", "a.html", "b.html"},
+	}
+	for _, args := range usageErrors {
+		if code, _, _ := runTest(args...); code != ExitUsage {
+			t.Log("args: ", args)
+			t.Error("Invalid arguments were not reported as usage error.")
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	code, stdout, _ := runTest("help", "build")
+	if code != ExitOK || !strings.Contains(stdout, "usage: remark-inject-code build") || !strings.Contains(stdout, "-code-root") {
+		t.Log("stdout: ", stdout)
+		t.Error("Help of build was wrong.")
+	}
+
+	if code, _, stderr := runTest("deps", "--help"); code != ExitOK || !strings.Contains(stderr, "-separator") {
+		t.Log("stderr: ", stderr)
+		t.Error("--help of deps was wrong.")
+	}
+
+	code, stdout, _ = runTest("--help")
+	if code != ExitOK || !strings.Contains(stdout, "  build   Replaces") || !strings.Contains(stdout, "  migrate Turns") {
+		t.Log("stdout: ", stdout)
+		t.Error("Summaries of the commands were not aligned.")
+	}
+}
+
+func TestBuildInPlace(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1-3)\n")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+
+	if code, _, stderr := runTest("build", "-in-place", "-code-root", codeRoot, document); code != ExitOK {
+		t.Fatal("Build failed: ", stderr)
+	}
+	built := readTestFile(t, document)
+	if !strings.Contains(built, "<!-- insert_code(foo.cpp:1-3) -->\n<!-- begin generated code -->\n```cpp\nint main() {") {
+		t.Logf("document:\n%s", built)
+		t.Error("Document was not converted in place.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		t.Error("In-place build wrote a separate output file.")
+	}
+	if code, stdout, _ := runTest("check", "-in-place", "-code-root", codeRoot, document); code != ExitOK {
+		t.Log("stdout: ", stdout)
+		t.Error("Fresh in-place document was reported as stale.")
+	}
+
+	writeTestFile(t, filepath.Join(codeRoot, "foo.cpp"), "int main() {\n  return 1;\n}\n")
+	if code, stdout, _ := runTest("check", "-in-place", "-code-root", codeRoot, document); code != ExitFailure || !strings.Contains(stdout, "+  return 1;") {
+		t.Log("stdout: ", stdout)
+		t.Error("Stale in-place document was not reported.")
+	}
+	runTest("build", "-in-place", "-code-root", codeRoot, document)
+	if rebuilt := readTestFile(t, document); rebuilt != strings.Replace(built, "return 0", "return 1", 1) {
+		t.Logf("document:\n%s", rebuilt)
+		t.Error("Generated code was not replaced.")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1-3)\ninsert_code(foo.cpp:1-3)<2:0-40>")
+
+	code, _, stderr := runTest("check", "-code-root", filepath.Join(dir, "code"), filepath.Join(dir, "index_raw.html"))
+	if code != ExitFailure || !strings.Contains(stderr, "index_raw.html:2:") {
+		t.Log("stderr: ", stderr)
+		t.Error("Check did not report the error.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		t.Error("Check wrote an output file.")
+	}
+}
+
+func TestCheckReportsStaleOutput(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1-3)\n# End")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+	if code, _, stderr := runTest("build", "-code-root", codeRoot, document); code != ExitOK {
+		t.Fatal("Build failed: ", stderr)
+	}
+
+	code, stdout, stderr := runTest("check", "-code-root", codeRoot, document)
+	if code != ExitOK || stdout != "" {
+		t.Log("stdout: ", stdout, "stderr: ", stderr)
+		t.Error("Fresh output was reported as stale.")
+	}
+
+	writeTestFile(t, filepath.Join(dir, "code", "foo.cpp"), "int main() {\n  return 1;\n}\n")
+	code, stdout, _ = runTest("check", "-code-root", codeRoot, document)
+	if code != ExitFailure {
+		t.Error("Stale output was not reported.")
+	}
+	expected := document + ":2: stale output of insert_code(foo.cpp:1-3)\n"
+	if !strings.Contains(stdout, expected) || !strings.Contains(stdout, "-  return 0;\n+  return 1;\n") {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Diff did not name the DSL line or the changed code.")
+	}
+
+	if code, _, _ := runTest("check", "-stale=false", "-code-root", codeRoot, document); code != ExitOK {
+		t.Error("Output was compared with -stale=false.")
+	}
+	os.Remove(filepath.Join(dir, "index.html"))
+	if code, _, stderr := runTest("check", "-code-root", codeRoot, document); code != ExitFailure || !strings.Contains(stderr, "does not exist") {
+		t.Log("stderr: ", stderr)
+		t.Error("Missing output was not reported.")
+	}
+}
+
+func TestDeps(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)\ninsert_code(bar.cpp:1)\ninsert_code(foo.cpp:2)")
+
+	code, stdout, _ := runTest("deps", filepath.Join(dir, "index_raw.html"))
+	if code != ExitOK || stdout != "foo.cpp\nbar.cpp\n" {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Dependencies were wrongly listed.")
+	}
+}
+
+func TestLockAndVerify(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1-3)\n# Other\ninsert_code(foo.cpp:1)")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+
+	if code, _, stderr := runTest("verify", "-code-root", codeRoot, document); code != ExitFailure || !strings.Contains(stderr, "does not exist") {
+		t.Log("stderr: ", stderr)
+		t.Error("Missing lock file was not reported.")
+	}
+	if code, _, stderr := runTest("lock", "-code-root", codeRoot, document); code != ExitOK {
+		t.Fatal("Lock failed: ", stderr)
+	}
+	if lock := readTestFile(t, document+".snippets.lock"); !strings.Contains(lock, `"file": "code/foo.cpp"`) {
+		t.Log("lock: ", lock)
+		t.Error("Lock file does not record the code file.")
+	}
+	if code, stdout, _ := runTest("verify", "-code-root", codeRoot, document); code != ExitOK || stdout != "" {
+		t.Log("stdout: ", stdout)
+		t.Error("Unchanged snippets were reported.")
+	}
+
+	// Regenerating the output does not hide the change.
+	writeTestFile(t, filepath.Join(codeRoot, "foo.cpp"), "int main() {\n  return 1;\n}\n")
+	runTest("build", "-code-root", codeRoot, document)
+	code, stdout, _ := runTest("verify", "-code-root", codeRoot, document)
+	expected := document + ":2: code of insert_code(foo.cpp:1-3) changed since the lock was written (code/foo.cpp:1-3)\n"
+	if code != ExitFailure || stdout != expected {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Changed snippet was wrongly reported.")
+	}
+}
+
+func TestVerifyFixesShiftedRanges(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:2){2}\n")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+	if code, _, stderr := runTest("lock", "-code-root", codeRoot, document); code != ExitOK {
+		t.Fatal("Lock failed: ", stderr)
+	}
+
+	writeTestFile(t, filepath.Join(codeRoot, "foo.cpp"), "#include <cstdio>\n"+testCode)
+	code, _, stderr := runTest("build", "-o", "-", "-code-root", codeRoot, document)
+	if code != ExitOK || !strings.Contains(stderr, "moved from lines 2 to 3") {
+		t.Log("stderr: ", stderr)
+		t.Error("Build did not relocate the range with the lock file.")
+	}
+	if code, stdout, _ := runTest("verify", "-code-root", codeRoot, document); code != ExitFailure || !strings.Contains(stdout, "moved to code/foo.cpp:3-3") {
+		t.Log("stdout: ", stdout)
+		t.Error("Moved snippet was not reported.")
+	}
+
+	code, stdout, _ := runTest("verify", "-fix", "-code-root", codeRoot, document)
+	if code != ExitOK || stdout != document+":2: fixed insert_code(foo.cpp:2){2} to insert_code(foo.cpp:3){3}\n" {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Moved snippet was not fixed.")
+	}
+	if fixed := readTestFile(t, document); fixed != "# Slide\ninsert_code(foo.cpp:3){3}\n" {
+		t.Log("document: ", fixed)
+		t.Error("Document was wrongly fixed.")
+	}
+	if code, stdout, _ := runTest("verify", "-code-root", codeRoot, document); code != ExitOK {
+		t.Log("stdout: ", stdout)
+		t.Error("Lock file was not updated by the fix.")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:2-3){2}<d3>\n")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+	runTest("build", "-code-root", codeRoot, document)
+	before := readTestFile(t, filepath.Join(dir, "index.html"))
+
+	code, stdout, stderr := runTest("migrate", "-dry-run", "-code-root", codeRoot, document)
+	if code != ExitOK || !strings.Contains(stdout, "+  // code_block(foo_2_3:1-2)\n") || !strings.Contains(stdout, "+rev_insert_code(foo.cpp:foo_2_3)r<d2>r{1}\n") {
+		t.Log("stdout: ", stdout, "stderr: ", stderr)
+		t.Error("Dry run did not show the changes.")
+	}
+	if readTestFile(t, filepath.Join(codeRoot, "foo.cpp")) != testCode {
+		t.Error("Dry run changed the code file.")
+	}
+
+	if code, _, stderr := runTest("migrate", "-code-root", codeRoot, document); code != ExitOK {
+		t.Fatal("Migration failed: ", stderr)
+	}
+	if migrated := readTestFile(t, document); migrated != "# Slide\nrev_insert_code(foo.cpp:foo_2_3)r<d2>r{1}\n" {
+		t.Log("document: ", migrated)
+		t.Error("Document was wrongly migrated.")
+	}
+	runTest("build", "-code-root", codeRoot, document)
+	if after := readTestFile(t, filepath.Join(dir, "index.html")); after != before {
+		t.Logf("output:\n%s\nbut expected\n%s", after, before)
+		t.Error("Migration changed the output.")
+	}
+}
+
+func TestMigrateOverlappingRanges(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(x.cpp:2-3)\ninsert_code(x.cpp:3-4)\ninsert_code(x.cpp:5-6)\n")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+	code := "int a;\nint b;\nint c;\nint d;\nint e;\nint f;\n"
+	writeTestFile(t, filepath.Join(codeRoot, "x.cpp"), code)
+
+	exitCode, _, stderr := runTest("migrate", "-code-root", codeRoot, document)
+	if exitCode != ExitFailure || !strings.Contains(stderr, "x.cpp would get code_block markers") {
+		t.Log("exit code: ", exitCode, " stderr: ", stderr)
+		t.Error("Markers that shift the lines of a command that was not migrated were not refused.")
+	}
+	if readTestFile(t, filepath.Join(codeRoot, "x.cpp")) != code {
+		t.Error("Refused migration changed the code file.")
+	}
+	if readTestFile(t, document) != "# Slide\ninsert_code(x.cpp:2-3)\ninsert_code(x.cpp:3-4)\ninsert_code(x.cpp:5-6)\n" {
+		t.Error("Refused migration changed the document.")
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\nrev_insert_code(foo.cpp:UsedID)\nrev_insert_code(MissingID)\n")
+	document := filepath.Join(dir, "index_raw.html")
+	codeRoot := filepath.Join(dir, "code")
+	writeTestFile(t, filepath.Join(codeRoot, "foo.cpp"), "// code_block(UsedID:1-3)\n"+testCode+"// code_block(UnusedID:1-2)\n")
+	writeTestFile(t, filepath.Join(codeRoot, "bar.cpp"), "// code_block(UsedID:1-1)\nint bar() {\n")
+
+	code, stdout, stderr := runTest("lint", "-code-root", codeRoot, "-index-cache", "off", document)
+	for _, problem := range []string{
+		"index_raw.html:3:17: error: could not find code_block MissingID in any code root",
+		"bar.cpp:1: error: code_block UsedID is also defined in " + filepath.Join(codeRoot, "foo.cpp") + ":1",
+		"bar.cpp:1: warning: code_block UsedID ends in the middle of a construct, 1 '{' not closed",
+		"foo.cpp:5: error: range 6-7 of code_block UnusedID is outside of the 5 lines of the file",
+	} {
+		if !strings.Contains(stdout, problem) {
+			t.Error("Problem was not reported: ", problem)
+		}
+	}
+	if code != ExitFailure {
+		t.Log("stdout: ", stdout, "stderr: ", stderr)
+		t.Error("Lint errors did not fail.")
+	}
+
+	writeTestFile(t, filepath.Join(codeRoot, "bar.cpp"), "int bar;\n")
+	writeTestFile(t, filepath.Join(codeRoot, "foo.cpp"), "// code_block(UsedID:1-3)\n"+testCode+"// code_block(UnusedID:1-1)\nint unused;\n")
+	writeTestFile(t, document, "rev_insert_code(foo.cpp:UsedID)\n")
+	code, stdout, _ = runTest("lint", "-json", "-code-root", codeRoot, "-index-cache", "off", document)
+	if code != ExitOK || !strings.Contains(stdout, `"message": "code_block UnusedID is not used by any document"`) {
+		t.Log("stdout: ", stdout)
+		t.Error("Unused block was not reported as JSON warning.")
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1)\nrev_insert_code(foo.cpp:ID)")
+	document := filepath.Join(dir, "index_raw.html")
+
+	code, stdout, _ := runTest("list", document)
+	expected := document + ":2: insert_code(foo.cpp:1)\n" + document + ":3: rev_insert_code(foo.cpp:ID)\n"
+	if code != ExitOK || stdout != expected {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Commands were wrongly listed.")
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := makeTestProject(t, "# Slide\ninsert_code(foo.cpp:1-3) {2}  <d1>\n")
+	document := filepath.Join(dir, "index_raw.html")
+
+	code, stdout, _ := runTest("fmt", "-l", document)
+	if code != ExitOK || stdout != document+"\n" {
+		t.Logf("stdout: %q", stdout)
+		t.Error("Unformatted document was not listed.")
+	}
+
+	if code, _, _ := runTest("fmt", "-w", document); code != ExitOK {
+		t.Error("Document could not be formatted.")
+	}
+	if content := readTestFile(t, document); content != "# Slide\ninsert_code(foo.cpp:1-3)<d1>{2}\n" {
+		t.Logf("content: %q", content)
+		t.Error("Document was wrongly formatted.")
+	}
+}
+
+func TestWatchRebuildsOnChange(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)")
+	output := filepath.Join(dir, "index.html")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		var stdout, stderr bytes.Buffer
+		done <- Run(ctx, []string{"watch", "-interval", "10ms", "-code-root", filepath.Join(dir, "code"), filepath.Join(dir, "index_raw.html")}, &stdout, &stderr)
+	}()
+
+	waitForOutput := func(expected string) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if content, err := os.ReadFile(output); err == nil && string(content) == expected {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !waitForOutput("```cpp\nint main() {\n```") {
+		t.Error("Document was not built initially.")
+	}
+
+	codeFile := filepath.Join(dir, "code", "foo.cpp")
+	writeTestFile(t, codeFile, "int other() {\n")
+	// Make sure the change is visible on file systems with a coarse mtime.
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(codeFile, later, later)
+	if !waitForOutput("```cpp\nint other() {\n```") {
+		t.Error("Document was not rebuilt after the code changed.")
+	}
+
+	cancel()
+	if code := <-done; code != ExitOK {
+		t.Error("Watch did not stop cleanly.")
+	}
+}
+
+func TestWatchReloadsConfig(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)")
+	config := filepath.Join(dir, "remark-inject.json")
+	writeTestFile(t, config, `{"code_roots": ["code"], "options": {"indent": 2}}`)
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		var stdout, stderr bytes.Buffer
+		done <- Run(ctx, []string{"watch", "-interval", "10ms", filepath.Join(dir, "index_raw.html")}, &stdout, &stderr)
+	}()
+
+	waitForOutput := func(output string, expected string) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if content, err := os.ReadFile(output); err == nil && string(content) == expected {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !waitForOutput(filepath.Join(dir, "index.html"), "```cpp\n  int main() {\n```") {
+		t.Error("Document was not built initially.")
+	}
+
+	writeTestFile(t, config, `{"code_roots": ["code"], "options": {"indent": 4}, "output": "out/{name}{ext}"}`)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(config, later, later)
+	if !waitForOutput(filepath.Join(dir, "out", "index.html"), "```cpp\n    int main() {\n```") {
+		t.Error("Document was not rebuilt with the changed config.")
+	}
+
+	cancel()
+	if code := <-done; code != ExitOK {
+		t.Error("Watch did not stop cleanly.")
+	}
+}
+
+func TestLegacyCommandLines(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)\ninsert_code(foo.cpp:2)")
+	codeRoot := filepath.Join(dir, "code")
+	document := filepath.Join(dir, "index_raw.html")
+
+	if !IsLegacyInvocation([]string{"-in", document}) || IsLegacyInvocation([]string{"build", document}) || IsLegacyInvocation([]string{"--help"}) {
+		t.Error("Legacy command lines were wrongly detected.")
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := RunLegacyBuild(context.Background(), []string{"-in", document, "-code-root", codeRoot}, &stdout, &stderr); code != ExitOK {
+		t.Log("stderr: ", stderr.String())
+		t.Error("Legacy build failed.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		t.Error("Legacy build did not infer the output file.")
+	}
+
+	stdout.Reset()
+	if code := RunLegacyDeps(context.Background(), []string{"-file", document, "-code-root", codeRoot}, &stdout, &stderr); code != ExitOK || stdout.String() != filepath.Join(codeRoot, "foo.cpp")+"\n" {
+		t.Logf("stdout: %q", stdout.String())
+		t.Error("Legacy deps failed.")
+	}
+}
+
+func TestBuildWithConfig(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1-3)")
+	writeTestFile(t, filepath.Join(dir, "remark-inject.json"), `{
+  "code_roots": ["code"],
+  "options": {"indent": 2},
+  "output": "out/{name}{ext}"
+}`)
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, stderr := runTest("build", filepath.Join(dir, "index_raw.html"))
+	if code != ExitOK {
+		t.Log("stderr: ", stderr)
+		t.Fatal("Build with config failed.")
+	}
+	expected := "```cpp\n  int main() {\n    return 0;\n  }\n```"
+	if output := readTestFile(t, filepath.Join(dir, "out", "index.html")); output != expected {
+		t.Logf("output:\n%s\nbut expected\n%s", output, expected)
+		t.Error("Config was not applied.")
+	}
+
+	// Flags override the config.
+	code, _, stderr = runTest("build", "-code-root", filepath.Join(dir, "missing"), filepath.Join(dir, "index_raw.html"))
+	if code != ExitFailure || !strings.Contains(stderr, "could not read source file") {
+		t.Log("stderr: ", stderr)
+		t.Error("-code-root did not override the config.")
+	}
+}
+
+func TestInvalidConfigIsReported(t *testing.T) {
+	dir := makeTestProject(t, "insert_code(foo.cpp:1)")
+	configPath := filepath.Join(dir, "project.json")
+	writeTestFile(t, configPath, "{\n  \"code_root\": \"code\"\n}")
+
+	code, _, stderr := runTest("check", "-config", configPath, filepath.Join(dir, "index_raw.html"))
+	if code != ExitFailure || !strings.Contains(stderr, configPath+":2:3: error: unknown key \"code_root\"") {
+		t.Log("stderr: ", stderr)
+		t.Error("Invalid config was not reported.")
+	}
+}
@@ -0,0 +1,385 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+)
+
+//===----------------------------------------------------------------------===//
+// build
+//
+// Examples usage:
+//   remark-inject-code build -code-root code/ index_raw.html
+//   remark-inject-code build -o - slides.md
+//   remark-inject-code build -in-place index.html
+
+func runBuild(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	output := fs.String("o", "", "Output file, \"-\" writes to stdout. Only valid for a single document. (default: the output pattern of the config or the document name without \"_raw\")")
+	inPlace := fs.Bool("in-place", false, "Keep the DSL commands as marker comments and regenerate the code between their fences in the document itself.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if *output != "" && fs.NArg() > 1 {
+		fmt.Fprintf(a.stderr, "%s: -o can only be used with a single document\n", fs.Name())
+		return ExitUsage
+	}
+	targets, code, ok := a.buildTargets(fs, *output, *inPlace)
+	if !ok {
+		return code
+	}
+	return a.buildAll(targets)
+}
+
+type buildTarget struct {
+	document string
+	output   string
+	// The document is an in-place document, which is written back to itself
+	// unless an output is given.
+	inPlace bool
+}
+
+// Determines the output file of every document, either from -o or from the
+// output pattern of its project, and makes sure that no document overwrites
+// itself or the output of another document.
+func (a *app) buildTargets(fs *flag.FlagSet, output string, inPlace bool) ([]buildTarget, int, bool) {
+	targets := []buildTarget{}
+	outputs := map[string]string{}
+	for _, document := range fs.Args() {
+		target := buildTarget{document, output, inPlace}
+		if inPlace && target.output == "" {
+			targets = append(targets, target)
+			continue
+		}
+		if target.output == "" {
+			var err error
+			if target.output, err = a.outputFile(document); err != nil {
+				a.reportError(document, err)
+				return nil, ExitFailure, false
+			}
+		}
+		if target.output == "-" {
+			targets = append(targets, target)
+			continue
+		}
+
+		cleanOutput := filepath.Clean(target.output)
+		if cleanOutput == filepath.Clean(document) {
+			fmt.Fprintf(a.stderr, "%s: output of %s would overwrite the document, use -o to choose an output file\n", fs.Name(), document)
+			return nil, ExitUsage, false
+		}
+		if other, found := outputs[cleanOutput]; found {
+			fmt.Fprintf(a.stderr, "%s: %s and %s would both be written to %s\n", fs.Name(), other, document, target.output)
+			return nil, ExitUsage, false
+		}
+		outputs[cleanOutput] = document
+		targets = append(targets, target)
+	}
+	return targets, ExitOK, true
+}
+
+func (a *app) buildAll(targets []buildTarget) int {
+	exitCode := ExitOK
+	for _, target := range targets {
+		if !a.build(target) {
+			exitCode = ExitFailure
+		}
+	}
+	return exitCode
+}
+
+// Builds a single document and reports whether it was built without errors.
+// The output is written unless the document could not be read or processed,
+// erroneous DSL commands are handled by the error policy and reported.
+func (a *app) build(target buildTarget) bool {
+	if target.inPlace && target.output == "" {
+		return a.buildInPlace(target.document)
+	}
+
+	var out bytes.Buffer
+	var diags remark_code_injector.Diagnostics
+	var err error
+	if target.inPlace {
+		diags, err = a.regenerate(target.document, &out)
+	} else {
+		diags, err = a.render(target.document, &out)
+	}
+	hasErrors := a.report(diags)
+	if err != nil {
+		a.reportError(target.document, err)
+		return false
+	}
+
+	if target.output == "-" {
+		_, err = a.stdout.Write(out.Bytes())
+	} else {
+		err = writeFileAtomic(target.output, out.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(a.stderr, "%s: could not write output: %s\n", target.document, err)
+		return false
+	}
+
+	if hasErrors {
+		fmt.Fprintf(a.stderr, "%d error(s) while processing %s\n", len(diags.Errors()), target.document)
+	}
+	return !hasErrors
+}
+
+// Processes a document file with the processor of its project and writes the
+// result to w. Commands are relocated with the lock file of the document.
+func (a *app) render(document string, w io.Writer) (remark_code_injector.Diagnostics, error) {
+	processor, err := a.lockedProcessor(document)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	return processor.Process(a.ctx, document, file, w)
+}
+
+//===----------------------------------------------------------------------===//
+// check
+//
+// Examples usage:
+//   remark-inject-code check -code-root code/ index_raw.html
+//   remark-inject-code check -stale=false slides_raw.md
+//   remark-inject-code check -in-place index.html
+
+func runCheck(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	output := fs.String("o", "", "Output file to compare with. Only valid for a single document. (default: the output file build would write)")
+	stale := fs.Bool("stale", true, "Compare the existing output with the rendered document and report stale snippets.")
+	inPlace := fs.Bool("in-place", false, "Check that regenerating in-place documents does not change them.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if *output != "" && fs.NArg() > 1 {
+		fmt.Fprintf(a.stderr, "%s: -o can only be used with a single document\n", fs.Name())
+		return ExitUsage
+	}
+	if *output == "-" {
+		fmt.Fprintf(a.stderr, "%s: cannot compare with stdout\n", fs.Name())
+		return ExitUsage
+	}
+
+	targets := []buildTarget{}
+	if *stale || *inPlace {
+		var code int
+		var ok bool
+		if targets, code, ok = a.buildTargets(fs, *output, *inPlace); !ok {
+			return code
+		}
+	} else {
+		for _, document := range fs.Args() {
+			targets = append(targets, buildTarget{document: document})
+		}
+	}
+
+	exitCode := ExitOK
+	for _, target := range targets {
+		if !a.check(target) {
+			exitCode = ExitFailure
+		}
+	}
+	return exitCode
+}
+
+// Checks a single document for errors and, if it has an output file, whether
+// the output is up to date.
+func (a *app) check(target buildTarget) bool {
+	if target.inPlace {
+		return a.checkInPlace(target)
+	}
+	rendered, diags, err := a.renderLines(target.document)
+	if a.report(diags) {
+		fmt.Fprintf(a.stderr, "%d error(s) in %s\n", len(diags.Errors()), target.document)
+		return false
+	}
+	if err != nil {
+		a.reportError(target.document, err)
+		return false
+	}
+	if target.output == "" {
+		return true
+	}
+	return a.checkOutput(target, rendered)
+}
+
+// Processes a document file with the processor of its project and returns
+// the output of every line.
+func (a *app) renderLines(document string) ([]remark_code_injector.RenderedLine, remark_code_injector.Diagnostics, error) {
+	processor, err := a.lockedProcessor(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	return processor.Render(a.ctx, document, file)
+}
+
+//===----------------------------------------------------------------------===//
+// deps
+//
+// Examples usage:
+//   remark-inject-code deps -separator ";" index_raw.html
+
+func runDeps(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	separator := fs.String("separator", "\n", "Separator that is printed between the dependencies.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+	dependencies, ok := a.dependencies(fs.Args())
+	if len(dependencies) > 0 {
+		fmt.Fprintln(a.stdout, strings.Join(dependencies, *separator))
+	}
+	if !ok {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// Collects the dependencies of all documents, every file is only listed once.
+// ok is false if a document could not be read or had errors.
+func (a *app) dependencies(documents []string) ([]string, bool) {
+	ok := true
+	seen := map[string]bool{}
+	dependencies := []string{}
+	for _, document := range documents {
+		deps, err := a.documentDependencies(document)
+		if err != nil {
+			a.reportError(document, err)
+			ok = false
+		}
+		for _, dep := range deps {
+			if !seen[dep] {
+				seen[dep] = true
+				dependencies = append(dependencies, dep)
+			}
+		}
+	}
+	return dependencies, ok
+}
+
+func (a *app) documentDependencies(document string) ([]string, error) {
+	processor, err := a.processor(document)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	deps, diags, err := processor.Dependencies(document, file)
+	if a.report(diags) && err == nil {
+		err = fmt.Errorf("%d error(s) while reading the DSL commands", len(diags.Errors()))
+	}
+	return deps, err
+}
+
+//===----------------------------------------------------------------------===//
+// list
+//
+// Examples usage:
+//   remark-inject-code list index_raw.html
+
+func runList(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+
+	exitCode := ExitOK
+	for _, document := range fs.Args() {
+		commands, diags, err := listCommands(document)
+		for _, c := range commands {
+			fmt.Fprintf(a.stdout, "%s:%d: %s\n", document, c.Line, c.Command.Text)
+		}
+		if a.report(diags) {
+			exitCode = ExitFailure
+		}
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", document, err)
+			exitCode = ExitFailure
+		}
+	}
+	return exitCode
+}
+
+func listCommands(document string) ([]remark_code_injector.CommandLine, remark_code_injector.Diagnostics, error) {
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	return remark_code_injector.Commands(document, file)
+}
+
+//===----------------------------------------------------------------------===//
+// fmt
+//
+// Examples usage:
+//   remark-inject-code fmt -w index_raw.html
+
+func runFmt(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	list := fs.Bool("l", false, "Only list the documents whose formatting differs.")
+	write := fs.Bool("w", false, "Write the result back to the documents instead of printing it.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+
+	exitCode := ExitOK
+	for _, document := range fs.Args() {
+		content, err := os.ReadFile(document)
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: could not read document: %s\n", document, err)
+			exitCode = ExitFailure
+			continue
+		}
+
+		var out bytes.Buffer
+		diags, err := remark_code_injector.Format(document, bytes.NewReader(content), &out)
+		if a.report(diags) || err != nil {
+			exitCode = ExitFailure
+		}
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", document, err)
+			continue
+		}
+
+		changed := !bytes.Equal(content, out.Bytes())
+		if *list && changed {
+			fmt.Fprintln(a.stdout, document)
+		}
+		if *write && changed {
+			if err := writeFileAtomic(document, out.Bytes()); err != nil {
+				fmt.Fprintf(a.stderr, "%s: could not write document: %s\n", document, err)
+				exitCode = ExitFailure
+			}
+		}
+		if !*list && !*write {
+			a.stdout.Write(out.Bytes())
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+	"github.com/vulder/remark_code_injector/internal/diff"
+)
+
+//===----------------------------------------------------------------------===//
+// In-place documents
+//
+// With -in-place, a document keeps its DSL commands as marker comments and
+// the generated code is written back into the document itself, so no
+// separate "_raw" file is needed.
+//===----------------------------------------------------------------------===//
+
+// Regenerates an in-place document and writes it back if it changed. The
+// document is only replaced if it could be processed completely.
+func (a *app) buildInPlace(document string) bool {
+	content, err := os.ReadFile(document)
+	if err != nil {
+		a.reportError(document, fmt.Errorf("could not read document: %w", err))
+		return false
+	}
+
+	var out bytes.Buffer
+	diags, err := a.regenerate(document, &out)
+	hasErrors := a.report(diags)
+	if err != nil {
+		a.reportError(document, err)
+		return false
+	}
+
+	// Unchanged documents are not touched, so watchers and build tools do
+	// not see a change.
+	if !bytes.Equal(content, out.Bytes()) {
+		if err := writeFileAtomic(document, out.Bytes()); err != nil {
+			fmt.Fprintf(a.stderr, "%s: could not write document: %s\n", document, err)
+			return false
+		}
+	}
+
+	if hasErrors {
+		fmt.Fprintf(a.stderr, "%d error(s) while processing %s\n", len(diags.Errors()), document)
+	}
+	return !hasErrors
+}
+
+// Regenerates an in-place document file with the processor of its project
+// and writes the result to w.
+func (a *app) regenerate(document string, w io.Writer) (remark_code_injector.Diagnostics, error) {
+	processor, err := a.lockedProcessor(document)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	return processor.ProcessInPlace(a.ctx, document, file, w)
+}
+
+// Checks that regenerating an in-place document does not change it, or its
+// output file if one was given. Differences are printed as a unified diff.
+func (a *app) checkInPlace(target buildTarget) bool {
+	var out bytes.Buffer
+	diags, err := a.regenerate(target.document, &out)
+	if a.report(diags) {
+		fmt.Fprintf(a.stderr, "%d error(s) in %s\n", len(diags.Errors()), target.document)
+		return false
+	}
+	if err != nil {
+		a.reportError(target.document, err)
+		return false
+	}
+
+	current := target.output
+	if current == "" {
+		current = target.document
+	}
+	content, err := os.ReadFile(current)
+	if err != nil {
+		fmt.Fprintf(a.stderr, "%s: could not read %s: %s\n", target.document, current, err)
+		return false
+	}
+
+	unified := diff.Unified(current, current+" (regenerated)",
+		strings.Split(string(content), "\n"), strings.Split(out.String(), "\n"), diffContext)
+	if unified == "" {
+		return true
+	}
+	fmt.Fprint(a.stdout, unified)
+	fmt.Fprintf(a.stderr, "%s is out of date, run build -in-place to update it\n", current)
+	return false
+}
+
+// Replaces a file by writing the data to a temporary file in the same folder
+// and renaming it, so readers never see a partially written file. The mode of
+// an existing file is kept.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing fails after a successful rename, which is fine.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Legacy command lines
+//
+// Before the subcommands existed, remark-inject-code only built a single
+// document and code_injector_dependencies printed its dependencies. Both
+// command lines are translated into the matching subcommand, so existing
+// scripts keep working.
+//===----------------------------------------------------------------------===//
+
+// Checks if the arguments use the old flag-only command line, i.e., they do
+// not start with a subcommand.
+func IsLegacyInvocation(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		return false
+	}
+	return strings.HasPrefix(args[0], "-")
+}
+
+// Runs "remark-inject-code -in input -out output -code-root root".
+func RunLegacyBuild(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet(programName, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	input := fs.String("in", "index_raw.html", "Input file")
+	output := fs.String("out", "nil", "Output file")
+	codeRoot := fs.String("code-root", "", "Root folder where code files are stored.")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	buildArgs := []string{"build"}
+	if *codeRoot != "" {
+		buildArgs = append(buildArgs, "-code-root", *codeRoot)
+	}
+	// "nil" was used to let the tool infer the output file.
+	if *output != "nil" {
+		buildArgs = append(buildArgs, "-o", *output)
+	}
+	buildArgs = append(buildArgs, "--", *input)
+	return Run(ctx, buildArgs, stdout, stderr)
+}
+
+// Runs "code_injector_dependencies -file document -code-root root".
+func RunLegacyDeps(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("code_injector_dependencies", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	document := fs.String("file", "", "Path to HTML document")
+	codeRoot := fs.String("code-root", "", "Root folder where code files are stored.")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if *document == "" {
+		fmt.Fprintln(stderr, "User did not provide a filepath to check.")
+		return ExitUsage
+	}
+
+	depsArgs := []string{"deps", "-separator", ";"}
+	if *codeRoot != "" {
+		depsArgs = append(depsArgs, "-code-root", *codeRoot)
+	}
+	return Run(ctx, append(depsArgs, "--", *document), stdout, stderr)
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+)
+
+//===----------------------------------------------------------------------===//
+// lint
+//
+// Examples usage:
+//   remark-inject-code lint index_raw.html
+//   remark-inject-code lint -json -code-root code/
+//
+// Without documents, the code roots of the project in the current folder are
+// checked, but unused blocks cannot be reported.
+
+func runLint(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	asJSON := fs.Bool("json", false, "Print the problems as a JSON array.")
+	if code, ok := a.parseFlags(fs, args, false); !ok {
+		return code
+	}
+
+	documents := fs.Args()
+	lints := &lintSet{linters: map[*remark_code_injector.Processor]*remark_code_injector.Linter{}}
+	diags := remark_code_injector.Diagnostics{}
+	for _, document := range documents {
+		diags = append(diags, a.lintDocument(document, lints)...)
+	}
+	if len(documents) == 0 {
+		// The config file is searched from the current folder.
+		processor, err := a.processor(remark_code_injector.ConfigFileName)
+		if err != nil {
+			diags = append(diags, asDiagnostics(remark_code_injector.ConfigFileName, err)...)
+		} else {
+			lints.get(processor)
+		}
+	}
+	for _, processor := range lints.order {
+		diags = append(diags, lints.linters[processor].CheckMarkers(len(documents) > 0)...)
+	}
+
+	if *asJSON {
+		if err := a.printJSONDiagnostics(diags); err != nil {
+			fmt.Fprintf(a.stderr, "%s: %s\n", fs.Name(), err)
+			return ExitFailure
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Fprintln(a.stdout, d)
+		}
+	}
+	if diags.HasErrors() {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// lintSet holds a linter for every processor, in the order the processors
+// were first used, so the code roots of every project are checked once.
+type lintSet struct {
+	linters map[*remark_code_injector.Processor]*remark_code_injector.Linter
+	order   []*remark_code_injector.Processor
+}
+
+func (ls *lintSet) get(processor *remark_code_injector.Processor) *remark_code_injector.Linter {
+	linter, found := ls.linters[processor]
+	if !found {
+		linter = processor.NewLinter()
+		ls.linters[processor] = linter
+		ls.order = append(ls.order, processor)
+	}
+	return linter
+}
+
+// Checks the commands of a document with the linter of its project.
+func (a *app) lintDocument(document string, lints *lintSet) remark_code_injector.Diagnostics {
+	processor, err := a.processor(document)
+	if err != nil {
+		return asDiagnostics(document, err)
+	}
+	file, err := os.Open(document)
+	if err != nil {
+		return asDiagnostics(document, fmt.Errorf("could not open document: %w", err))
+	}
+	defer file.Close()
+
+	diags, err := processor.Lint(document, file, lints.get(processor))
+	if err != nil {
+		diags = append(diags, asDiagnostics(document, err)...)
+	}
+	return diags
+}
+
+// Turns an error of a document into diagnostics. Diagnostics, e.g., of an
+// invalid config file, are kept as they are.
+func asDiagnostics(document string, err error) remark_code_injector.Diagnostics {
+	if diags, ok := err.(remark_code_injector.Diagnostics); ok {
+		return diags
+	}
+	return remark_code_injector.Diagnostics{{
+		Severity: remark_code_injector.SeverityError,
+		Document: document,
+		Msg:      err.Error(),
+		Err:      err,
+	}}
+}
+
+// jsonDiagnostic is the JSON form of a diagnostic.
+type jsonDiagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+	Fragment string `json:"fragment,omitempty"`
+}
+
+// Prints the diagnostics as an indented JSON array to stdout.
+func (a *app) printJSONDiagnostics(diags remark_code_injector.Diagnostics) error {
+	problems := make([]jsonDiagnostic, 0, len(diags))
+	for _, d := range diags {
+		problems = append(problems, jsonDiagnostic{d.Severity.String(), d.Document, d.Line, d.Column, d.Msg, d.Fragment})
+	}
+	data, err := json.MarshalIndent(problems, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, string(data))
+	return err
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+)
+
+//===----------------------------------------------------------------------===//
+// lock
+//
+// Examples usage:
+//   remark-inject-code lock index_raw.html
+
+func runLock(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+
+	exitCode := ExitOK
+	for _, document := range fs.Args() {
+		lock, diags, err := a.lock(document, nil)
+		if a.report(diags) {
+			// A lock without some of the snippets would hide their changes.
+			fmt.Fprintf(a.stderr, "%d error(s) in %s, lock file not written\n", len(diags.Errors()), document)
+			exitCode = ExitFailure
+			continue
+		}
+		if err == nil {
+			err = lock.Write(remark_code_injector.LockFile(document))
+		}
+		if err != nil {
+			a.reportError(document, err)
+			exitCode = ExitFailure
+		}
+	}
+	return exitCode
+}
+
+// Determines the current snippets of a document with the processor of its
+// project. If a lock is given, commands are relocated with it.
+func (a *app) lock(document string, locked *remark_code_injector.Lock) (*remark_code_injector.Lock, remark_code_injector.Diagnostics, error) {
+	processor, err := a.processor(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	processor = processor.WithLock(locked)
+	file, err := os.Open(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open document: %w", err)
+	}
+	defer file.Close()
+
+	return processor.Lock(document, file)
+}
+
+//===----------------------------------------------------------------------===//
+// verify
+//
+// Examples usage:
+//   remark-inject-code verify index_raw.html
+
+func runVerify(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	fix := fs.Bool("fix", false, "Rewrite the line ranges of insert_code commands whose code moved and update the lock file.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+
+	exitCode := ExitOK
+	for _, document := range fs.Args() {
+		if !a.verify(document, *fix) {
+			exitCode = ExitFailure
+		}
+	}
+	return exitCode
+}
+
+// Compares the snippets of a document with its lock file and prints every
+// change. With fix, moved commands are rewritten first. Returns false if a
+// snippet changed or the document had errors.
+func (a *app) verify(document string, fix bool) bool {
+	lockFile := remark_code_injector.LockFile(document)
+	locked, err := remark_code_injector.ReadLock(lockFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(a.stderr, "%s: lock file %s does not exist, run lock to create it\n", document, lockFile)
+		return false
+	}
+	if err != nil {
+		a.reportError(document, err)
+		return false
+	}
+
+	if fix && !a.fixRanges(document, locked) {
+		return false
+	}
+
+	current, diags, err := a.lock(document, locked)
+	hasErrors := a.report(diags)
+	if err != nil {
+		a.reportError(document, err)
+		return false
+	}
+
+	changes := locked.Compare(current)
+	for _, change := range changes {
+		switch change.Kind {
+		case remark_code_injector.SnippetChanged:
+			fmt.Fprintf(a.stdout, "%s:%d: code of %s changed since the lock was written (%s)\n",
+				document, change.Current.Line, change.Current.Command, formatSnippet(change.Current))
+		case remark_code_injector.SnippetAdded:
+			fmt.Fprintf(a.stdout, "%s:%d: %s is not in the lock file\n", document, change.Current.Line, change.Current.Command)
+		case remark_code_injector.SnippetMoved:
+			fmt.Fprintf(a.stdout, "%s:%d: code of %s moved to %s, run verify -fix to update the command\n",
+				document, change.Current.Line, change.Current.Command, formatSnippet(change.Current))
+		case remark_code_injector.SnippetRemoved:
+			fmt.Fprintf(a.stdout, "%s: %s from line %d of the lock file is not in the document anymore\n",
+				document, change.Locked.Command, change.Locked.Line)
+		}
+	}
+	if len(changes) > 0 {
+		fmt.Fprintf(a.stderr, "%d snippet(s) of %s differ from %s, review the slides and run lock to accept them\n", len(changes), document, lockFile)
+	}
+	return len(changes) == 0 && !hasErrors
+}
+
+func formatSnippet(s *remark_code_injector.LockedSnippet) string {
+	return fmt.Sprintf("%s:%d-%d", s.File, s.Start, s.End)
+}
+
+// Rewrites the commands of a document whose code moved since the lock was
+// written and updates the lock to match them. Returns false if the document
+// could not be fixed.
+func (a *app) fixRanges(document string, locked *remark_code_injector.Lock) bool {
+	processor, err := a.processor(document)
+	if err != nil {
+		a.reportError(document, err)
+		return false
+	}
+	content, err := os.ReadFile(document)
+	if err != nil {
+		a.reportError(document, fmt.Errorf("could not read document: %w", err))
+		return false
+	}
+
+	var out bytes.Buffer
+	// The relocations are printed below, the warnings would only repeat them.
+	relocations, diags, err := processor.WithLock(locked).FixRanges(document, bytes.NewReader(content), &out)
+	if a.report(diags.Errors()) || err != nil {
+		if err != nil {
+			a.reportError(document, err)
+		}
+		return false
+	}
+	if len(relocations) == 0 {
+		return true
+	}
+
+	if err := writeFileAtomic(document, out.Bytes()); err != nil {
+		fmt.Fprintf(a.stderr, "%s: could not write document: %s\n", document, err)
+		return false
+	}
+	locked.Relocate(relocations)
+	if err := locked.Write(remark_code_injector.LockFile(document)); err != nil {
+		a.reportError(document, err)
+		return false
+	}
+	for _, relocation := range relocations {
+		fmt.Fprintf(a.stdout, "%s:%d: fixed %s to %s\n", document, relocation.Line, relocation.Old, relocation.New)
+	}
+	return true
+}
+
+// Returns the processor for a document that relocates commands with the lock
+// file of the document, if it has one.
+func (a *app) lockedProcessor(document string) (*remark_code_injector.Processor, error) {
+	processor, err := a.processor(document)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := remark_code_injector.ReadLock(remark_code_injector.LockFile(document))
+	if errors.Is(err, fs.ErrNotExist) {
+		return processor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return processor.WithLock(locked), nil
+}
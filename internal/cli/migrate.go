@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+	"github.com/vulder/remark_code_injector/internal/diff"
+)
+
+//===----------------------------------------------------------------------===//
+// migrate
+//
+// Rewrites the insert_code commands of documents into rev_insert_code
+// commands and inserts the code_block markers they refer to into the code
+// files. All documents of a run share the markers, so documents that quote
+// the same lines use the same marker.
+//
+// Examples usage:
+//   remark-inject-code migrate -dry-run index_raw.html
+//   remark-inject-code migrate slides/*_raw.html
+
+func runMigrate(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	dryRun := fs.Bool("dry-run", false, "Print the changes of the documents and code files as unified diffs instead of writing them.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+
+	exitCode := ExitOK
+	migrations := &migrationSet{byProcessor: map[*remark_code_injector.Processor]*remark_code_injector.Migration{}}
+	changes := []fileChange{}
+	for _, document := range fs.Args() {
+		change, ok := a.migrateDocument(document, migrations)
+		if !ok {
+			exitCode = ExitFailure
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+
+	// Markers shift the lines of the code file, which would silently break
+	// the commands that were not migrated, so nothing is written.
+	if errs := migrations.check(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(a.stderr, "migrate: %s\n", err)
+		}
+		return ExitFailure
+	}
+
+	codeChanges, ok := migrations.codeFileChanges()
+	if !ok {
+		fmt.Fprintln(a.stderr, "migrate: documents of different projects need markers in the same code file, migrate them separately")
+		return ExitFailure
+	}
+	// Code files are written first, so documents never refer to markers that
+	// do not exist.
+	changes = append(codeChanges, changes...)
+
+	for _, change := range changes {
+		if change.err != nil {
+			fmt.Fprintf(a.stderr, "%s: could not read file: %s\n", change.path, change.err)
+			return ExitFailure
+		}
+	}
+	for _, change := range changes {
+		if *dryRun {
+			fmt.Fprint(a.stdout, diff.Unified(change.path, change.path+" (migrated)",
+				strings.Split(change.old, "\n"), strings.Split(change.new, "\n"), diffContext))
+			continue
+		}
+		if change.old == change.new {
+			continue
+		}
+		if err := writeFileAtomic(change.path, []byte(change.new)); err != nil {
+			fmt.Fprintf(a.stderr, "%s: could not write file: %s\n", change.path, err)
+			return ExitFailure
+		}
+	}
+	return exitCode
+}
+
+// fileChange is the old and new content of a document or code file.
+type fileChange struct {
+	path string
+	old  string
+	new  string
+	// Set if the file could not be read
+	err error
+}
+
+// migrationSet holds one migration per project, in the order the projects
+// were first used.
+type migrationSet struct {
+	byProcessor map[*remark_code_injector.Processor]*remark_code_injector.Migration
+	order       []*remark_code_injector.Migration
+}
+
+func (ms *migrationSet) get(processor *remark_code_injector.Processor) *remark_code_injector.Migration {
+	migration, found := ms.byProcessor[processor]
+	if !found {
+		migration = processor.NewMigration()
+		ms.byProcessor[processor] = migration
+		ms.order = append(ms.order, migration)
+	}
+	return migration
+}
+
+// Migrates the commands of a document with the migration of its project.
+// Returns false if the document could not be read or had errors.
+func (a *app) migrateDocument(document string, migrations *migrationSet) (*fileChange, bool) {
+	processor, err := a.processor(document)
+	if err != nil {
+		a.reportError(document, err)
+		return nil, false
+	}
+	content, err := os.ReadFile(document)
+	if err != nil {
+		a.reportError(document, fmt.Errorf("could not read document: %w", err))
+		return nil, false
+	}
+
+	var out bytes.Buffer
+	diags, err := processor.Migrate(document, bytes.NewReader(content), &out, migrations.get(processor))
+	hasErrors := a.report(diags)
+	if err != nil {
+		a.reportError(document, err)
+		return nil, false
+	}
+	return &fileChange{path: document, old: string(content), new: out.String()}, !hasErrors
+}
+
+// Returns the problems of all migrations that prevent writing their markers.
+func (ms *migrationSet) check() []error {
+	errs := []error{}
+	for _, migration := range ms.order {
+		errs = append(errs, migration.Check()...)
+	}
+	return errs
+}
+
+// Reads the code files that get markers and inserts them. Returns false if
+// two migrations change the same file.
+func (ms *migrationSet) codeFileChanges() ([]fileChange, bool) {
+	changes := []fileChange{}
+	seen := map[string]bool{}
+	for _, migration := range ms.order {
+		for _, file := range migration.Files() {
+			if seen[file.Path] {
+				return nil, false
+			}
+			seen[file.Path] = true
+
+			content, err := os.ReadFile(file.Path)
+			changes = append(changes, fileChange{file.Path, string(content), file.Apply(string(content)), err})
+		}
+	}
+	return changes, true
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	remark_code_injector "github.com/vulder/remark_code_injector"
+)
+
+// project bundles the config of a document with the processor that is
+// created from it.
+type project struct {
+	config    *remark_code_injector.Config
+	processor *remark_code_injector.Processor
+	err       error
+}
+
+// Returns the path of the config file of a document, which is either the one
+// given with -config or the one found by walking up from the document. Empty
+// if the document has no config file.
+func (a *app) configPath(document string) (string, error) {
+	if a.globals.config != "" {
+		return a.globals.config, nil
+	}
+	return remark_code_injector.FindConfig(document)
+}
+
+// Returns the project of a document. Projects are created on first use and
+// shared by all documents with the same config file.
+func (a *app) project(document string) (*project, error) {
+	configPath, err := a.configPath(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if p, found := a.projects[configPath]; found {
+		return p, p.err
+	}
+	p := a.loadProject(configPath)
+	a.projects[configPath] = p
+	return p, p.err
+}
+
+func (a *app) loadProject(configPath string) *project {
+	p := &project{config: &remark_code_injector.Config{}}
+	if configPath != "" {
+		if p.config, p.err = remark_code_injector.LoadConfig(configPath); p.err != nil {
+			return p
+		}
+	}
+
+	options := p.config.Options()
+	a.globals.override(&options)
+	p.processor, p.err = remark_code_injector.NewProcessor(options)
+	return p
+}
+
+// Returns the processor for a document.
+func (a *app) processor(document string) (*remark_code_injector.Processor, error) {
+	p, err := a.project(document)
+	if err != nil {
+		return nil, err
+	}
+	return p.processor, nil
+}
+
+// Returns the output file of a document as configured by its project.
+func (a *app) outputFile(document string) (string, error) {
+	p, err := a.project(document)
+	if err != nil {
+		return "", err
+	}
+	return p.config.OutputFile(document), nil
+}
+
+// Drops all projects, so their config files are read again on next use.
+func (a *app) reloadProjects() {
+	a.projects = map[string]*project{}
+}
+
+// Drops the cached code files of all projects.
+func (a *app) clearCaches() {
+	for _, p := range a.projects {
+		if p.processor != nil {
+			p.processor.ClearCache()
+		}
+	}
+}
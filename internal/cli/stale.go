@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	remark_code_injector "github.com/vulder/remark_code_injector"
+	"github.com/vulder/remark_code_injector/internal/diff"
+)
+
+//===----------------------------------------------------------------------===//
+// Stale output
+//
+// The generated output is often committed next to the document and drifts
+// from the code it quotes. check renders the document in memory and compares
+// the result with the existing output. Every difference is attributed to the
+// line of the document that produced it, so the report names the DSL commands
+// whose snippets are out of date.
+//===----------------------------------------------------------------------===//
+
+// Number of unchanged lines shown around every change.
+const diffContext = 3
+
+// Compares the rendered document with its existing output file. Differences
+// are printed as a unified diff and reported as false.
+func (a *app) checkOutput(target buildTarget, rendered []remark_code_injector.RenderedLine) bool {
+	content, err := os.ReadFile(target.output)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(a.stderr, "%s: output %s does not exist, run build to create it\n", target.document, target.output)
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(a.stderr, "%s: could not read output: %s\n", target.document, err)
+		return false
+	}
+
+	expected, owners := outputLines(rendered)
+	hunks := diff.Hunks(diff.Lines(strings.Split(string(content), "\n"), expected), diffContext)
+	if len(hunks) == 0 {
+		return true
+	}
+
+	fmt.Fprintf(a.stdout, "--- %s\n", target.output)
+	fmt.Fprintf(a.stdout, "+++ %s (rendered from %s)\n", target.output, target.document)
+	for _, hunk := range hunks {
+		for _, owner := range hunkOwners(hunk, owners) {
+			line := rendered[owner]
+			if line.Command {
+				fmt.Fprintf(a.stdout, "%s:%d: stale output of %s\n", target.document, line.Line, strings.TrimSpace(line.Source))
+			} else {
+				fmt.Fprintf(a.stdout, "%s:%d: output differs from the document\n", target.document, line.Line)
+			}
+		}
+		fmt.Fprint(a.stdout, hunk.String())
+	}
+	fmt.Fprintf(a.stderr, "%s is out of date with %s, run build to update it\n", target.output, target.document)
+	return false
+}
+
+// Splits the rendered document into output lines. For every output line,
+// owners holds the index of the rendered line that produced it.
+func outputLines(rendered []remark_code_injector.RenderedLine) (lines []string, owners []int) {
+	for idx, line := range rendered {
+		for _, output := range strings.Split(line.Output, "\n") {
+			lines = append(lines, output)
+			owners = append(owners, idx)
+		}
+	}
+	return lines, owners
+}
+
+// Returns the rendered lines whose output is changed by the hunk, in the
+// order of the document. Removed lines are attributed to the output line
+// that follows them.
+func hunkOwners(hunk diff.Hunk, owners []int) []int {
+	if len(owners) == 0 {
+		return nil
+	}
+	seen := map[int]bool{}
+	result := []int{}
+	for _, edit := range hunk.Edits {
+		if edit.Kind == diff.Equal {
+			continue
+		}
+		idx := edit.NewLine
+		if idx >= len(owners) {
+			idx = len(owners) - 1
+		}
+		if owner := owners[idx]; !seen[owner] {
+			seen[owner] = true
+			result = append(result, owner)
+		}
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+//===----------------------------------------------------------------------===//
+// watch
+//
+// Builds the documents once and then polls the documents, the code files
+// they depend on and their config files. Whenever one of them changes, all
+// documents are built again. A changed config file is loaded again, so its
+// code roots, options and output pattern take effect. Polling keeps the tool free of platform specific file notification
+// APIs and works the same for every code root.
+//
+// Examples usage:
+//   remark-inject-code watch -code-root code/ index_raw.html
+
+func runWatch(a *app, cmd *command, args []string) int {
+	fs := a.newFlagSet(cmd)
+	output := fs.String("o", "", "Output file, only valid for a single document. (default: the output pattern of the config or the document name without \"_raw\")")
+	inPlace := fs.Bool("in-place", false, "Regenerate in-place documents, see build -in-place.")
+	interval := fs.Duration("interval", 500*time.Millisecond, "How often the files are checked for changes.")
+	if code, ok := a.parseFlags(fs, args, true); !ok {
+		return code
+	}
+	if *output != "" && fs.NArg() > 1 {
+		fmt.Fprintf(a.stderr, "%s: -o can only be used with a single document\n", fs.Name())
+		return ExitUsage
+	}
+	if *output == "-" {
+		fmt.Fprintf(a.stderr, "%s: watch cannot write to stdout\n", fs.Name())
+		return ExitUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(a.stderr, "%s: -interval must be positive\n", fs.Name())
+		return ExitUsage
+	}
+	makeTargets := func() ([]buildTarget, int, bool) {
+		return a.buildTargets(fs, *output, *inPlace)
+	}
+	targets, code, ok := makeTargets()
+	if !ok {
+		return code
+	}
+	return a.watch(targets, makeTargets, *interval)
+}
+
+// Rebuilds the targets until the context of the app is canceled. If a config
+// file changed, the targets are determined again with makeTargets.
+func (a *app) watch(targets []buildTarget, makeTargets func() ([]buildTarget, int, bool), interval time.Duration) int {
+	a.buildAll(targets)
+	last := a.snapshot(targets)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return ExitOK
+		case <-ticker.C:
+		}
+
+		current := a.snapshot(targets)
+		if current.equal(last) {
+			continue
+		}
+		if !equalTimes(current.configs, last.configs) {
+			a.reloadProjects()
+			// Invalid targets were reported, the old ones are built until
+			// the config is fixed.
+			if changed, _, ok := makeTargets(); ok {
+				targets = changed
+			}
+			current = a.snapshot(targets)
+		}
+		last = current
+
+		a.clearCaches()
+		if a.buildAll(targets) == ExitOK {
+			fmt.Fprintf(a.stderr, "rebuilt at %s\n", time.Now().Format("15:04:05"))
+		}
+	}
+}
+
+// Modification times of the documents, their code files and their config
+// files. Files that do not exist have the zero time, so creating them is
+// noticed as well. Config files are looked up again every time, so a config
+// file that is created next to a document is noticed, too.
+type fileSnapshot struct {
+	documents map[string]time.Time
+	sources   map[string]time.Time
+	configs   map[string]time.Time
+}
+
+func (a *app) snapshot(targets []buildTarget) fileSnapshot {
+	snap := fileSnapshot{documents: map[string]time.Time{}, sources: map[string]time.Time{}, configs: map[string]time.Time{}}
+	for _, target := range targets {
+		if info, err := os.Stat(target.document); err == nil {
+			snap.documents[target.document] = info.ModTime()
+		}
+		if configPath, err := a.configPath(target.document); err == nil && configPath != "" {
+			if info, err := os.Stat(configPath); err == nil {
+				snap.configs[configPath] = info.ModTime()
+			} else {
+				snap.configs[configPath] = time.Time{}
+			}
+		}
+
+		processor, err := a.processor(target.document)
+		if err != nil {
+			continue
+		}
+		file, err := os.Open(target.document)
+		if err != nil {
+			continue
+		}
+		// Problems in the document are reported by the build, not here.
+		deps, _, _ := processor.Dependencies(target.document, file)
+		file.Close()
+		for _, dep := range deps {
+			if info, err := os.Stat(dep); err == nil {
+				snap.sources[dep] = info.ModTime()
+			} else {
+				snap.sources[dep] = time.Time{}
+			}
+		}
+	}
+	return snap
+}
+
+func (s fileSnapshot) equal(other fileSnapshot) bool {
+	return equalTimes(s.documents, other.documents) && equalTimes(s.sources, other.sources) &&
+		equalTimes(s.configs, other.configs)
+}
+
+func equalTimes(lhs map[string]time.Time, rhs map[string]time.Time) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for name, modTime := range lhs {
+		if other, found := rhs[name]; !found || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package code_dsl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Anchors
+//
+// Numeric line ranges break as soon as someone adds a line above the quoted
+// code. An anchor holds fingerprints of the lines a command quoted when its
+// snippet was locked. If the lines at the range of the command do not match
+// the fingerprints anymore, the fingerprints are searched in the current file
+// and the command is moved to where the code is now, which makes insert_code
+// about as robust as a code_block marker.
+//===----------------------------------------------------------------------===//
+
+// Anchor holds the fingerprints of the lines an insert_code command quoted.
+type Anchor struct {
+	// 1-based first and last line the command quoted
+	Start int
+	End   int
+	// Fingerprints of the quoted lines, see FingerprintLines
+	Fingerprints []string
+}
+
+// Returns a short fingerprint for every line. Leading and trailing whitespace
+// is ignored, so re-indented code is still found.
+func FingerprintLines(lines []string) []string {
+	fingerprints := make([]string, len(lines))
+	for idx, line := range lines {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(line)))
+		fingerprints[idx] = hex.EncodeToString(sum[:6])
+	}
+	return fingerprints
+}
+
+// Counts the fingerprints that match the file when the first one is placed
+// at the 1-based line start.
+func anchorScore(fingerprints []string, file []string, start int) int {
+	score := 0
+	for idx, fingerprint := range fingerprints {
+		lineIdx := start - 1 + idx
+		if lineIdx >= 0 && lineIdx < len(file) && file[lineIdx] == fingerprint {
+			score++
+		}
+	}
+	return score
+}
+
+// Finds the line where the fingerprinted lines match the file best. The
+// match is fuzzy, lines of the snippet may have been edited, but at least
+// half of them must still match. Of equally good positions, the one closest
+// to start wins. Returns false if the lines are still best matched at start
+// or could not be found.
+func locateAnchor(fingerprints []string, file []string, start int) (int, bool) {
+	if len(fingerprints) == 0 {
+		return start, false
+	}
+
+	best, bestScore := start, anchorScore(fingerprints, file, start)
+	for candidate := 1; candidate+len(fingerprints)-1 <= len(file); candidate++ {
+		score := anchorScore(fingerprints, file, candidate)
+		if score > bestScore || (score == bestScore && abs(candidate-start) < abs(best-start)) {
+			best, bestScore = candidate, score
+		}
+	}
+	if best == start || 2*bestScore < len(fingerprints) {
+		return start, false
+	}
+	return best, true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Moves an insert_code command to the lines its anchor is found at now. The
+// line range and all absolute selections are shifted, relative selections
+// stay as they are. Returns a warning that names the new range if the
+// command was moved, and nil otherwise.
+func relocateInsertCode(cmd *Command, env *Env) *Diagnostic {
+	if env == nil || cmd.Range == nil {
+		return nil
+	}
+	anchor, found := env.Anchors[cmd.Text]
+	if !found || anchor.Start != cmd.Range.Start {
+		return nil
+	}
+	// Problems with the file are reported when the code is read.
+	sourceFile, err := env.Sources.Load(cmd.File.Path)
+	if err != nil {
+		return nil
+	}
+
+	start, moved := locateAnchor(anchor.Fingerprints, sourceFile.fingerprints(), cmd.Range.Start)
+	if !moved {
+		return nil
+	}
+	oldStart, oldEnd := cmd.Range.Start, cmd.Range.End
+	cmd.shiftLines(start - oldStart)
+	return newCommandWarning(cmd, cmd.Range.Pos, cmd.Range.EndPos,
+		"quoted code of %s moved from lines %s to %s, using the new lines",
+		cmd.File.Path, formatLineRange(oldStart, oldEnd), formatLineRange(cmd.Range.Start, cmd.Range.End))
+}
+
+// Shifts the line range and all absolute selections of a command.
+func (cmd *Command) shiftLines(delta int) {
+	if cmd.Range != nil {
+		cmd.Range.Start += delta
+		cmd.Range.End += delta
+	}
+	if cmd.Highlights != nil && !cmd.Highlights.Relative {
+		for _, sel := range cmd.Highlights.Items {
+			shiftSelector(sel, delta)
+		}
+	}
+	if cmd.Visuals != nil && !cmd.Visuals.Relative {
+		for _, item := range cmd.Visuals.Items {
+			shiftSelector(item.Selector, delta)
+		}
+	}
+}
+
+func shiftSelector(sel Selector, delta int) {
+	switch s := sel.(type) {
+	case *LineSelector:
+		s.Line += delta
+	case *LineRangeSelector:
+		s.Start += delta
+		s.End += delta
+	case *CharRangeSelector:
+		s.Line += delta
+	}
+}
+
+// Moves the insert_code command of a line to where its anchor is found now
+// and returns the rewritten command together with the number of lines it was
+// moved by. If the command did not move, the line is returned unchanged and
+// the delta is 0.
+func RelocateCommand(line string, env *Env) (string, int, Diagnostics) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return line, 0, AsDiagnostics(err)
+	}
+	if cmd.Range == nil {
+		return line, 0, nil
+	}
+	start := cmd.Range.Start
+	warning := relocateInsertCode(cmd, env)
+	if warning == nil {
+		return line, 0, nil
+	}
+	return cmd.String(), cmd.Range.Start - start, Diagnostics{warning}
+}
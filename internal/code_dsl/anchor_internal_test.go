@@ -0,0 +1,63 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+const anchorTestCode = `int main() {
+  int x = 1;
+  return x;
+}
+`
+
+// Creates an environment whose anchor for the command was taken from the
+// original code.
+func makeAnchoredEnv(command string, start int, end int, current string) *Env {
+	lines := strings.Split(anchorTestCode, "\n")[start-1 : end]
+	env := makeTestEnv("foo.cpp", current)
+	return env.WithAnchors(map[string]Anchor{command: {start, end, FingerprintLines(lines)}})
+}
+
+func TestShiftedRangeIsRelocated(t *testing.T) {
+	command := "insert_code(foo.cpp:1-3){2}<d3>"
+	env := makeAnchoredEnv(command, 1, 3, "#include <cstdio>\n\n"+anchorTestCode)
+
+	line, diags := TransformLine(command, env)
+	expected := "```cpp\nint main() {\n* int x = 1;\n  // ...\n```"
+	if line != expected {
+		t.Logf("line:\n%s\nbut expected\n%s", line, expected)
+		t.Error("Range and absolute selections were not relocated.")
+	}
+	if len(diags) != 1 || diags.HasErrors() || !strings.Contains(diags[0].Msg, "moved from lines 1-3 to 3-5") || diags[0].Fragment != "1-3" {
+		t.Log("diagnostics: ", diags)
+		t.Error("Relocation was not reported as warning at the range.")
+	}
+
+	fixed, delta, _ := RelocateCommand(command, env)
+	if fixed != "insert_code(foo.cpp:3-5)<d5>{4}" || delta != 2 {
+		t.Log("fixed: ", fixed, " delta: ", delta)
+		t.Error("Command was wrongly rewritten.")
+	}
+}
+
+func TestRelocationIsFuzzy(t *testing.T) {
+	command := "insert_code(foo.cpp:1-4)"
+	edited := "// header\nint main() {\n    int x = 2;\n    return x;\n}\n"
+	env := makeAnchoredEnv(command, 1, 4, edited)
+
+	if fixed, delta, _ := RelocateCommand(command, env); fixed != "insert_code(foo.cpp:2-5)" || delta != 1 {
+		t.Log("fixed: ", fixed)
+		t.Error("Edited and re-indented code was not found.")
+	}
+
+	env = makeAnchoredEnv(command, 1, 4, "void other() {}\n"+anchorTestCode)
+	if _, delta, diags := RelocateCommand("insert_code(foo.cpp:1-3)", env); delta != 0 || len(diags) != 0 {
+		t.Error("Command without anchor was relocated.")
+	}
+
+	env = makeAnchoredEnv(command, 1, 4, "a\nb\nc\nd\ne\n")
+	if _, delta, _ := RelocateCommand(command, env); delta != 0 {
+		t.Error("Command was relocated although its code is gone.")
+	}
+}
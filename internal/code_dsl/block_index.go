@@ -0,0 +1,334 @@
+package code_dsl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//===----------------------------------------------------------------------===//
+// Block index
+//
+// rev_insert_code commands can leave out the filename, e.g.,
+//   rev_insert_code(:FooID)   or   rev_insert_code(FooID)
+// in which case the BlockID is looked up in all code files of the code
+// roots. The index of all code_block markers is built on first use by
+// scanning the roots. A BlockID that is defined in several files cannot be
+// looked up, the command has to name the file.
+//
+// Scanning large projects takes a while, so the index of every root can be
+// cached in a folder between runs, see SourceResolver.SetIndexCache. Files
+// whose modification time and size did not change since the cache was
+// written are not read again. Markers are only recognized in the comments of
+// the language of a file, so the cache is only used with the languages and
+// comment syntaxes it was written with.
+//===----------------------------------------------------------------------===//
+
+// BlockLocation is a code_block marker found in a code root.
+type BlockLocation struct {
+	// Name of the file as it is used in DSL commands
+	File string
+	// Path of the file including the folder of its code root
+	Path string
+	// Line of the marker
+	Line int
+}
+
+// BlockIndex maps BlockIDs to the files that define them.
+type BlockIndex struct {
+	blocks map[string][]BlockLocation
+}
+
+// Returns all markers that define the BlockID.
+func (bi *BlockIndex) Lookup(blockID string) []BlockLocation {
+	return bi.blocks[blockID]
+}
+
+type blockIndexEntry struct {
+	once  sync.Once
+	index *BlockIndex
+	err   error
+}
+
+// Sets the folder the block index of every code root is cached in. The
+// cache is keyed by the folders of the roots, so it must only be used for
+// roots of the OS file system.
+func (sr *SourceResolver) SetIndexCache(dir string) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.indexCacheDir = dir
+	sr.index = nil
+}
+
+// Returns the index of all code_block markers in the code roots, which is
+// built on first use with the languages and comment syntaxes of env. Files
+// that are shadowed by a file with the same name in an earlier root are left
+// out, as DSL commands cannot reference them.
+func (sr *SourceResolver) BlockIndex(env *Env) (*BlockIndex, error) {
+	if sr == nil || len(sr.roots) == 0 {
+		return nil, fmt.Errorf("no code root configured to search for code_block markers")
+	}
+
+	sr.mutex.Lock()
+	if sr.index == nil {
+		sr.index = &blockIndexEntry{}
+	}
+	entry, cacheDir := sr.index, sr.indexCacheDir
+	sr.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.index, entry.err = buildBlockIndex(sr.roots, cacheDir, env)
+	})
+	return entry.index, entry.err
+}
+
+func buildBlockIndex(roots []SourceRoot, cacheDir string, env *Env) (*BlockIndex, error) {
+	index := &BlockIndex{blocks: map[string][]BlockLocation{}}
+	seen := map[string]bool{}
+	for _, root := range roots {
+		files, err := scanRootBlocks(root, cacheDir, env)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			for _, block := range files[name].Blocks {
+				index.blocks[block.ID] = append(index.blocks[block.ID], BlockLocation{name, joinRootPath(root, name), block.Line})
+			}
+		}
+	}
+	return index, nil
+}
+
+// indexedFile holds the markers of a file together with the modification
+// time and size the file had when it was scanned.
+type indexedFile struct {
+	ModTime int64          `json:"mtime"`
+	Size    int64          `json:"size"`
+	Blocks  []indexedBlock `json:"blocks,omitempty"`
+}
+
+type indexedBlock struct {
+	ID   string `json:"id"`
+	Line int    `json:"line"`
+}
+
+// Files larger than this are not scanned for markers
+const maxIndexedFileSize = 8 * 1024 * 1024
+
+// Finds the markers of all files in a code root. Hidden folders, e.g., ".git",
+// are skipped. Files that did not change since the cached index of the root
+// was written are taken from the cache.
+func scanRootBlocks(root SourceRoot, cacheDir string, env *Env) (map[string]indexedFile, error) {
+	cacheFile := ""
+	syntax := markerSyntaxKey(env)
+	cached := map[string]indexedFile{}
+	if cacheDir != "" && root.Dir != "" {
+		cacheFile = blockIndexCacheFile(cacheDir, root.Dir)
+		cached = readBlockIndexCache(cacheFile, root.Dir, syntax)
+	}
+
+	files := map[string]indexedFile{}
+	changed := false
+	err := fs.WalkDir(root.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == "." {
+				return err
+			}
+			// Folders we cannot read cannot be referenced either.
+			return nil
+		}
+		if d.IsDir() {
+			if name != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// Stat follows symlinks and applies the symlink policy of the root.
+		info, err := fs.Stat(root.FS, name)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		file, found := cached[name]
+		if !found || file.ModTime != info.ModTime().UnixNano() || file.Size != info.Size() {
+			if file, found = indexFile(root.FS, name, info, env.markerSyntaxes(name)); !found {
+				return nil
+			}
+			changed = true
+		}
+		files[name] = file
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not scan code root %s: %w", joinRootPath(root, "."), err)
+	}
+
+	if cacheFile != "" && (changed || len(files) != len(cached)) {
+		// Without a cache the next run only takes longer.
+		_ = writeBlockIndexCache(cacheFile, root.Dir, syntax, files)
+	}
+	return files, nil
+}
+
+// Scans a single file for markers in comments of the given syntaxes. Returns
+// false if the file could not be read. Large and binary files are indexed
+// without markers.
+func indexFile(fsys fs.FS, name string, info fs.FileInfo, syntaxes []CommentSyntax) (indexedFile, bool) {
+	file := indexedFile{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	if info.Size() > maxIndexedFileSize {
+		return file, true
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return file, false
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !bytes.Contains(data, []byte("code_block")) {
+		return file, true
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, maxLineLength)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	for _, marker := range scanCodeBlockMarkers(lines, syntaxes) {
+		file.Blocks = append(file.Blocks, indexedBlock{marker.id, marker.line})
+	}
+	sort.Slice(file.Blocks, func(i, j int) bool { return file.Blocks[i].Line < file.Blocks[j].Line })
+	return file, true
+}
+
+//===----------------------------------------------------------------------===//
+// Index cache
+
+const blockIndexCacheVersion = 2
+
+type blockIndexCache struct {
+	Version int    `json:"version"`
+	Root    string `json:"root"`
+	// Languages and comment syntaxes the files were scanned with, see
+	// markerSyntaxKey
+	Syntax string                 `json:"syntax,omitempty"`
+	Files  map[string]indexedFile `json:"files"`
+}
+
+// Returns a key for the languages and comment syntaxes of env that decide
+// which comments can hold markers. Empty if only the built-in ones are used.
+func markerSyntaxKey(env *Env) string {
+	if env == nil || (len(env.Languages) == 0 && len(env.Comments) == 0) {
+		return ""
+	}
+	// Maps are encoded with sorted keys, so the key is stable.
+	data, err := json.Marshal(struct {
+		Languages map[string]string
+		Comments  CommentRegistry
+	}{env.Languages, env.Comments})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Returns the cache file of a code root. Roots are told apart by a hash of
+// their real path.
+func blockIndexCacheFile(cacheDir string, rootDir string) string {
+	sum := sha256.Sum256([]byte(realPath(rootDir)))
+	return filepath.Join(cacheDir, "blocks-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// Reads the cached index of a code root. A missing or invalid cache is
+// treated as empty.
+func readBlockIndexCache(cacheFile string, rootDir string, syntax string) map[string]indexedFile {
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return map[string]indexedFile{}
+	}
+	cache := blockIndexCache{}
+	if err := json.Unmarshal(data, &cache); err != nil ||
+		cache.Version != blockIndexCacheVersion || cache.Root != realPath(rootDir) || cache.Syntax != syntax || cache.Files == nil {
+		return map[string]indexedFile{}
+	}
+	return cache.Files
+}
+
+// Writes the index of a code root to its cache file. The file is replaced
+// atomically, so concurrent runs never read a partially written cache.
+func writeBlockIndexCache(cacheFile string, rootDir string, syntax string, files map[string]indexedFile) error {
+	data, err := json.Marshal(blockIndexCache{blockIndexCacheVersion, realPath(rootDir), syntax, files})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cacheFile)
+}
+
+//===----------------------------------------------------------------------===//
+// Lookup
+
+// Looks up the file of a rev_insert_code command without a filename in the
+// block index and uses it as the filename of the command. Commands with a
+// filename are left as they are.
+func resolveGlobalBlock(cmd *Command, env *Env) *Diagnostic {
+	if cmd.Block == nil || cmd.File.Path != "" {
+		return nil
+	}
+	index, err := env.Sources.BlockIndex(env)
+	if err != nil {
+		d := newCommandError(cmd, cmd.Block.Pos, cmd.Block.End, "could not search for code_block %s: %s", cmd.Block.ID, err)
+		d.Err = err
+		return d
+	}
+
+	locations := index.Lookup(cmd.Block.ID)
+	switch len(locations) {
+	case 0:
+		d := newCommandError(cmd, cmd.Block.Pos, cmd.Block.End, "could not find code_block %s in any code root", cmd.Block.ID)
+		d.Err = errNoBlockID
+		return d
+	case 1:
+		cmd.File.Path = locations[0].File
+		return nil
+	default:
+		places := make([]string, len(locations))
+		for idx, location := range locations {
+			places[idx] = fmt.Sprintf("%s:%d", location.Path, location.Line)
+		}
+		return newCommandError(cmd, cmd.Block.Pos, cmd.Block.End,
+			"code_block %s is defined in several files, name the file in the command: %s", cmd.Block.ID, strings.Join(places, ", "))
+	}
+}
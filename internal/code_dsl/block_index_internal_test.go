@@ -0,0 +1,104 @@
+package code_dsl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRevInsertCodeLooksUpBlockID(t *testing.T) {
+	service := fstest.MapFS{
+		"foo.cpp":      &fstest.MapFile{Data: []byte("// code_block(FooID:1-1)\nint foo;\n")},
+		"bar.cpp":      &fstest.MapFile{Data: []byte("// code_block(DupID:1-1)\nint bar;\n")},
+		".git/old.cpp": &fstest.MapFile{Data: []byte("// code_block(OldID:1-1)\nint old;\n")},
+	}
+	sdk := fstest.MapFS{
+		"foo.cpp":     &fstest.MapFile{Data: []byte("// code_block(ShadowedID:1-1)\nint sdk;\n")},
+		"lib/dup.cpp": &fstest.MapFile{Data: []byte("int dup;\n// code_block(DupID:1-1)\n")},
+	}
+	env := &Env{Sources: NewMultiRootSourceResolver(
+		[]SourceRoot{{Dir: "service", FS: service}, {Dir: "sdk", FS: sdk}}, nil, nil)}
+
+	transformed, diags := TransformLine("rev_insert_code(FooID)", env)
+	if diags.HasErrors() || transformed != "```cpp\nint foo;\n```" {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("BlockID was not looked up in the code roots.")
+	}
+
+	if _, diags := TransformLine("rev_insert_code(:DupID)", env); len(diags) != 1 ||
+		!strings.Contains(diags[0].Msg, filepath.Join("service", "bar.cpp")+":1") ||
+		!strings.Contains(diags[0].Msg, filepath.Join("sdk", "lib", "dup.cpp")+":2") {
+		t.Log("diags: ", diags)
+		t.Error("BlockID defined in several files did not list all of them.")
+	}
+	for _, line := range []string{"rev_insert_code(ShadowedID)", "rev_insert_code(OldID)"} {
+		if _, diags := TransformLine(line, env); !diags.HasErrors() {
+			t.Error("Marker of a shadowed or hidden file was found: ", line)
+		}
+	}
+}
+
+func TestBlockIndexCache(t *testing.T) {
+	root := t.TempDir()
+	cacheDir := t.TempDir()
+	code := filepath.Join(root, "foo.cpp")
+	if err := os.WriteFile(code, []byte("// code_block(FooID:1-1)\nint foo;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newResolver := func() *SourceResolver {
+		sources := NewMultiRootSourceResolver([]SourceRoot{{Dir: root, FS: os.DirFS(root)}}, nil, nil)
+		sources.SetIndexCache(cacheDir)
+		return sources
+	}
+
+	index, err := newResolver().BlockIndex(nil)
+	if err != nil || len(index.Lookup("FooID")) != 1 {
+		t.Fatal("Block was not indexed: ", err)
+	}
+	cacheFile := blockIndexCacheFile(cacheDir, root)
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Fatal("Index was not cached: ", err)
+	}
+
+	// A cached file with an unchanged modification time is not read again.
+	cached, _ := os.ReadFile(cacheFile)
+	os.WriteFile(cacheFile, []byte(strings.ReplaceAll(string(cached), "FooID", "BarID")), 0644)
+	if index, _ := newResolver().BlockIndex(nil); len(index.Lookup("BarID")) != 1 {
+		t.Error("Cached index was not used.")
+	}
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(code, later, later)
+	if index, _ := newResolver().BlockIndex(nil); len(index.Lookup("FooID")) != 1 || len(index.Lookup("BarID")) != 0 {
+		t.Error("Changed file was not indexed again.")
+	}
+}
+
+func TestMarkersUseConfiguredCommentSyntax(t *testing.T) {
+	fsys := fstest.MapFS{
+		"boot.s2":    &fstest.MapFile{Data: []byte("@ code_block(BootID:1-1)\nmov r0, #1\n")},
+		"config.inc": &fstest.MapFile{Data: []byte("x = 1 // code_block(SlashID:1-1)\n# code_block(HashID:1-1)\ny = 2\n")},
+	}
+	env := &Env{
+		Sources:   NewSourceResolver(fsys),
+		Languages: map[string]string{"inc": "python"},
+		Comments:  CommentRegistry{"s2": {Line: "@"}},
+	}
+
+	for _, line := range []string{"rev_insert_code(boot.s2:BootID)", "rev_insert_code(BootID)"} {
+		if transformed, diags := TransformLine(line, env); len(diags) != 0 || transformed != "```s2\nmov r0, #1\n```" {
+			t.Log("transformed: ", transformed, " diags: ", diags)
+			t.Error("Marker in a comment of the comment registry was not found: ", line)
+		}
+	}
+	if transformed, diags := TransformLine("rev_insert_code(HashID)", env); len(diags) != 0 || transformed != "```python\ny = 2\n```" {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Marker in a file of a configured language was not found.")
+	}
+	if _, diags := TransformLine("rev_insert_code(config.inc:SlashID)", env); !diags.HasErrors() {
+		t.Error("Marker in a comment of another language was found.")
+	}
+}
@@ -0,0 +1,176 @@
+package code_dsl
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// code_block markers
+//
+// Code files can mark blocks of code that are referenced by rev_insert_code
+// with a marker comment, e.g.,
+//   // code_block(FooID:1-4)
+// The range is relative to the marker line, so the example marks the four
+// lines following the marker.
+// Markers are only recognized inside of comments of the language of the file,
+// e.g., "# code_block(FooID:1-4)" in Python or "-- code_block(FooID:1-4)" in
+// SQL, so string literals that contain a marker are ignored. See
+// comment_syntax.go for the known languages.
+//
+// Blocks that grow or shrink are easier to mark with a pair of markers that
+// enclose them,
+//   // code_block_begin(FooID)
+//   ...
+//   // code_block_end(FooID)
+// Pairs are matched by their BlockID, so blocks can be nested and can
+// overlap. The begin and end marker lines are not part of the rendered code.
+//===----------------------------------------------------------------------===//
+
+var codeBlockRgx = regexp.MustCompile(".*code_block\\((?P<BlockID>.*):(?P<filerange>.*)\\).*")
+
+// codeBlockMarker is a code_block marker found in a code file.
+type codeBlockMarker struct {
+	id string
+	// Line of the marker itself
+	line int
+	// Lines of the file the marker refers to
+	lineRange LineRange
+	// Set if the range of the marker could not be parsed
+	err error
+}
+
+var codeBlockPairRgx = regexp.MustCompile(`code_block_(begin|end)\(\s*([A-Za-z_][A-Za-z0-9_.]*)\s*\)`)
+
+// Checks if a line of a file with the given comment syntaxes is the begin or
+// end marker of a code_block pair.
+func isCodeBlockPairMarker(line string, syntaxes []CommentSyntax) bool {
+	for _, comment := range newCommentScanner(syntaxes).comments(line) {
+		if codeBlockPairRgx.MatchString(comment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds all code_block markers in the lines of a file with the given comment
+// syntaxes. If the same BlockID is used more than once, the first marker
+// wins.
+func scanCodeBlockMarkers(lines []string, syntaxes []CommentSyntax) map[string]codeBlockMarker {
+	markers := make(map[string]codeBlockMarker)
+	for _, marker := range listCodeBlockMarkers(lines, syntaxes) {
+		if _, found := markers[marker.id]; !found {
+			markers[marker.id] = marker
+		}
+	}
+	return markers
+}
+
+// Lists all code_block markers in the lines of a file, including markers
+// that reuse a BlockID. Only markers inside of comments of the given
+// syntaxes count. Pairs are listed at their end marker and begin markers without an
+// end are listed last.
+func listCodeBlockMarkers(lines []string, syntaxes []CommentSyntax) []codeBlockMarker {
+	markers := []codeBlockMarker{}
+	// Lines of the begin markers whose end was not found yet
+	open := make(map[string]int)
+	scanner := newCommentScanner(syntaxes)
+	for idx, line := range lines {
+		lineNumber := idx + 1
+		// Every line is scanned to follow block comments across lines.
+		comments := scanner.comments(line)
+		if !strings.Contains(line, "code_block") {
+			continue
+		}
+		for _, comment := range comments {
+			if marker, found := scanCodeBlockMarker(comment, open, lineNumber); found {
+				markers = append(markers, marker)
+			}
+		}
+	}
+
+	unclosed := []codeBlockMarker{}
+	for blockID, beginLine := range open {
+		unclosed = append(unclosed, codeBlockMarker{blockID, beginLine, LineRange{0, 0},
+			fmt.Errorf("code_block_begin(%s) in line %d has no matching code_block_end", blockID, beginLine)})
+	}
+	sort.Slice(unclosed, func(i, j int) bool { return unclosed[i].line < unclosed[j].line })
+	return append(markers, unclosed...)
+}
+
+// Parses the marker in a comment. Begin markers only open their block and
+// are not returned.
+func scanCodeBlockMarker(comment string, open map[string]int, lineNumber int) (codeBlockMarker, bool) {
+	if pair := codeBlockPairRgx.FindStringSubmatch(comment); pair != nil {
+		return scanCodeBlockPairMarker(open, pair[1], pair[2], lineNumber)
+	}
+
+	match := codeBlockRgx.FindStringSubmatch(comment)
+	if match == nil {
+		return codeBlockMarker{}, false
+	}
+	matchResults := make(map[string]string)
+	for i, name := range codeBlockRgx.SubexpNames() {
+		if i != 0 && name != "" {
+			matchResults[name] = match[i]
+		}
+	}
+
+	blockID := matchResults["BlockID"]
+	lineRange, err := parseMarkerRange(matchResults["filerange"], lineNumber)
+	return codeBlockMarker{blockID, lineNumber, lineRange, err}, true
+}
+
+// Handles a begin or end marker of a pair. A block is complete once its end
+// marker is found, it spans the lines between the two markers. End markers
+// without a begin are returned as complete markers with an error.
+func scanCodeBlockPairMarker(open map[string]int, kind string, blockID string, lineNumber int) (codeBlockMarker, bool) {
+	beginLine, isOpen := open[blockID]
+	switch {
+	case kind == "begin" && !isOpen:
+		open[blockID] = lineNumber
+	case kind == "end" && isOpen:
+		delete(open, blockID)
+		return codeBlockMarker{blockID, beginLine, LineRange{beginLine + 1, lineNumber - 1}, nil}, true
+	case kind == "end":
+		return codeBlockMarker{blockID, lineNumber, LineRange{0, 0},
+			fmt.Errorf("code_block_end(%s) in line %d has no matching code_block_begin", blockID, lineNumber)}, true
+	}
+	return codeBlockMarker{}, false
+}
+
+func parseMarkerRange(filerange string, lineNumber int) (LineRange, error) {
+	bounds := strings.Split(filerange, "-")
+	if len(bounds) != 2 {
+		return LineRange{0, 0}, fmt.Errorf("code_block marker in line %d has no valid range", lineNumber)
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 32)
+	if err != nil {
+		return LineRange{0, 0}, fmt.Errorf("could not parse start of the code_block range in line %d: %w", lineNumber, err)
+	}
+	end, err := strconv.ParseInt(bounds[1], 10, 32)
+	if err != nil {
+		return LineRange{0, 0}, fmt.Errorf("could not parse end of the code_block range in line %d: %w", lineNumber, err)
+	}
+	return LineRange{lineNumber + int(start), lineNumber + int(end)}, nil
+}
+
+var errNoBlockID = errors.New("no valid BlockID found in file")
+
+// Looks up the lines a code_block marker refers to.
+func parseCodeBlockLineRangeFromFile(env *Env, filename string, blockID string) (LineRange, error) {
+	sourceFile, err := env.Sources.Load(filename)
+	if err != nil {
+		return LineRange{0, 0}, err
+	}
+
+	marker, found := sourceFile.markers(env)[blockID]
+	if !found {
+		return LineRange{0, 0}, errNoBlockID
+	}
+	return marker.lineRange, marker.err
+}
@@ -1,19 +1,43 @@
 package code_dsl
 
-// Returns the relative filepath of dependent file, i.e., the path to a file
-// that is needed in a DSL command.
-func GetFileDependency(line string, codeRoot string) string {
-	if isInsertCode(line) {
-		ci, err := parserInsertCodeInfo(line)
-		if err == nil {
-			return ci.filename
-		}
+import (
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// Returns the path of the file a DSL command depends on, including the
+// folder of the code root the file was found in. Lines without a DSL command
+// have no dependency. Files that do not exist are still returned, so build
+// tools notice when they are created, but are reported as warnings.
+func GetFileDependency(line string, env *Env) (string, Diagnostics) {
+	if !ContainsDSLCommand(line) {
+		return "", nil
+	}
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return "", AsDiagnostics(err)
+	}
+	if d := resolveGlobalBlock(cmd, env); d != nil {
+		return "", Diagnostics{d}
+	}
+
+	resolved, err := env.Sources.Resolve(cmd.File.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return resolved.Path(), Diagnostics{newCommandWarning(cmd, cmd.File.Pos, cmd.File.End, "%s", err)}
+	}
+	if err != nil {
+		return "", Diagnostics{newCommandError(cmd, cmd.File.Pos, cmd.File.End, "%s", err)}
 	}
-	if isRevInsertCode(line) {
-		ci, err := parseRevInsertCodeInfo(line, codeRoot)
-		if err == nil {
-			return ci.filename
-		}
+	if len(resolved.Shadowed) > 0 {
+		return resolved.Path(), Diagnostics{newShadowedWarning(cmd, resolved)}
 	}
-	return ""
+	return resolved.Path(), nil
+}
+
+// Creates the warning for a filename that matches files in several code
+// roots.
+func newShadowedWarning(cmd *Command, resolved ResolvedSource) *Diagnostic {
+	return newCommandWarning(cmd, cmd.File.Pos, cmd.File.End, "%s is ambiguous, using %s and ignoring %s",
+		cmd.File.Path, resolved.Path(), strings.Join(resolved.Shadowed, ", "))
 }
@@ -7,7 +7,12 @@ import (
 func TestParseCodeGenOptionsEmptyString(t *testing.T) {
 	optionString := ""
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != 0 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 0)
@@ -23,7 +28,12 @@ func TestParseCodeGenOptionsEmptyString(t *testing.T) {
 func TestParseCodeGenOptionsComments(t *testing.T) {
 	optionString := "comments=true"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != 0 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 0)
@@ -39,7 +49,12 @@ func TestParseCodeGenOptionsComments(t *testing.T) {
 func TestParseCodeGenOptionsCommentsUpperCase(t *testing.T) {
 	optionString := "comments=True"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != 0 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 0)
@@ -55,7 +70,12 @@ func TestParseCodeGenOptionsCommentsUpperCase(t *testing.T) {
 func TestParseCodeGenOptionsPosIndent(t *testing.T) {
 	optionString := "indent=2"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != 2 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 2)
@@ -71,7 +91,12 @@ func TestParseCodeGenOptionsPosIndent(t *testing.T) {
 func TestParseCodeGenOptionsNegIndent(t *testing.T) {
 	optionString := "indent=-2"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != -2 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", -2)
@@ -87,7 +112,12 @@ func TestParseCodeGenOptionsNegIndent(t *testing.T) {
 func TestParseCodeGenOptionsIgnoreFalseOptions(t *testing.T) {
 	optionString := "key=value,key2=bar"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 2 || diags.HasErrors() {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Unknown options were not reported as warnings.")
+	}
 
 	if options.getIndent() != 0 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 0)
@@ -103,7 +133,12 @@ func TestParseCodeGenOptionsIgnoreFalseOptions(t *testing.T) {
 func TestParseCodeGenOptionsMultiple(t *testing.T) {
 	optionString := "indent=2,comments=false"
 
-	options := ParseCodeGenOptions(optionString)
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if len(diags) != 0 {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Valid options produced diagnostics.")
+	}
 
 	if options.getIndent() != 2 {
 		t.Log("Indent was set to", options.getIndent(), " but expected", 2)
@@ -115,3 +150,21 @@ func TestParseCodeGenOptionsMultiple(t *testing.T) {
 		t.Error("Hide comments was wrong.")
 	}
 }
+
+func TestParseCodeGenOptionsInvalidValue(t *testing.T) {
+	optionString := "indent=2,comments=maybe"
+
+	options, diags := ParseCodeGenOptions(optionString)
+
+	if !diags.HasErrors() {
+		t.Error("Invalid option value was not reported as error.")
+	} else if diags[0].Column != 10 || diags[0].Fragment != "comments=maybe" {
+		t.Log("Diagnostic: ", diags[0])
+		t.Error("Diagnostic points to the wrong option.")
+	}
+
+	if options.getIndent() != 2 {
+		t.Log("Indent was set to", options.getIndent(), " but expected", 2)
+		t.Error("Indent was wrong.")
+	}
+}
@@ -6,58 +6,200 @@ import (
 	"strings"
 )
 
+// CodeGenOptionsImpl holds the options that control how code is generated.
+// The zero value keeps the code as it is.
 type CodeGenOptionsImpl struct {
-	indentLevel    int
-	removeComments bool
+	// Number of spaces that are added (or removed if negative) to each line
+	IndentLevel int
+	// Drop comment lines from the generated code
+	RemoveComments bool
+	// Leave out the doc comment of symbols quoted with insert_symbol
+	RemoveDoc bool
+	// Replace the bodies of Go functions with a placeholder
+	ElideBodies bool
+	// Only keep the signatures of Go functions, without their bodies
+	SignatureOnly bool
+	// Drop the unexported fields of Go structs
+	ExportedFieldsOnly bool
+	// What happens to "if err != nil { return err }" statements in Go code
+	ErrChecks ErrCheckMode
+	// Text of the comment that replaces folded error checks. Empty for
+	// the default "if err != nil { ... }".
+	ErrCheckPlaceholder string
+	// Format Go code with gofmt
+	FormatGo bool
+}
+
+// ErrCheckMode decides how error checks in Go code are rendered.
+type ErrCheckMode int
+
+const (
+	// Render error checks as they are.
+	KeepErrChecks ErrCheckMode = iota
+	// Replace every error check with a single comment line.
+	FoldErrChecks
+	// Leave out error checks.
+	RemoveErrChecks
+)
+
+var errCheckModeNames = map[string]ErrCheckMode{
+	"keep":   KeepErrChecks,
+	"fold":   FoldErrChecks,
+	"remove": RemoveErrChecks,
+}
+
+// Parses the name of an error check mode, i.e., keep, fold or remove.
+func ParseErrCheckMode(name string) (ErrCheckMode, error) {
+	mode, found := errCheckModeNames[strings.ToLower(name)]
+	if !found {
+		return KeepErrChecks, fmt.Errorf("unknown errcheck mode %q, expected keep, fold or remove", name)
+	}
+	return mode, nil
 }
 
 type CodeGenOptions interface {
 	hideComments() bool
+	hideDoc() bool
 	getIndent() int
+	elideBodies() bool
+	signatureOnly() bool
+	exportedFieldsOnly() bool
+	errCheckMode() ErrCheckMode
+	errCheckPlaceholder() string
+	formatGo() bool
 }
 
 func (cgo *CodeGenOptionsImpl) hideComments() bool {
-	return cgo.removeComments
+	return cgo.RemoveComments
+}
+
+func (cgo *CodeGenOptionsImpl) hideDoc() bool {
+	return cgo.RemoveDoc
 }
 
 func (cgo *CodeGenOptionsImpl) getIndent() int {
-	return cgo.indentLevel
+	return cgo.IndentLevel
 }
 
-func ParseCodeGenOptions(optionString string) CodeGenOptions {
-	cgo := CodeGenOptionsImpl{}
-	cgo.indentLevel = 0
-	cgo.removeComments = false
+func (cgo *CodeGenOptionsImpl) elideBodies() bool {
+	return cgo.ElideBodies
+}
 
-	for _, optionStr := range strings.Split(optionString, ",") {
-		optionStr = strings.TrimSpace(optionStr)
-		if optionStr == "" {
-			continue
+func (cgo *CodeGenOptionsImpl) signatureOnly() bool {
+	return cgo.SignatureOnly
+}
+
+func (cgo *CodeGenOptionsImpl) exportedFieldsOnly() bool {
+	return cgo.ExportedFieldsOnly
+}
+
+func (cgo *CodeGenOptionsImpl) errCheckMode() ErrCheckMode {
+	return cgo.ErrChecks
+}
+
+func (cgo *CodeGenOptionsImpl) errCheckPlaceholder() string {
+	return cgo.ErrCheckPlaceholder
+}
+
+func (cgo *CodeGenOptionsImpl) formatGo() bool {
+	return cgo.FormatGo
+}
+
+// Parses an option list, e.g., "indent=2,comments=false". Unknown options
+// are ignored and reported as warnings.
+func ParseCodeGenOptions(optionString string) (CodeGenOptions, Diagnostics) {
+	optionList, err := ParseOptionList(optionString)
+	if err != nil {
+		return MakeDefaultCodeGenOptions(), AsDiagnostics(err)
+	}
+	return makeCodeGenOptions(CodeGenOptionsImpl{}, optionList, optionString)
+}
+
+// Creates the options from a parsed option list, starting from the given
+// defaults. The text is the DSL text the option list was parsed from and is
+// used for diagnostics.
+func makeCodeGenOptions(defaults CodeGenOptionsImpl, optionList *OptionList, text string) (CodeGenOptions, Diagnostics) {
+	cgo := defaults
+	if optionList == nil {
+		return &cgo, nil
+	}
+
+	var diags Diagnostics
+	// Parses an option that is switched on or off by one of two words.
+	choice := func(option Option, on string, off string) (bool, bool) {
+		switch strings.ToLower(option.Value) {
+		case on:
+			return true, true
+		case off:
+			return false, true
 		}
+		diags = append(diags, newDiagnosticAt(SeverityError, text, option.Pos, option.End,
+			"option %s expects %s or %s but got %q", strings.ToLower(option.Key), on, off, option.Value))
+		return false, false
+	}
 
-		kvPair := strings.Split(optionStr, "=")
-		optionKey := strings.ToLower(kvPair[0])
-		optionValue := kvPair[1]
+	for _, option := range optionList.Items {
+		optionKey := strings.ToLower(option.Key)
+		optionValue := option.Value
 
 		switch optionKey {
 		case "comments":
 			enableComment, err := strconv.ParseBool(optionValue)
 			if err != nil {
-				fmt.Println("Could not parse option:", err.Error())
+				diags = append(diags, newDiagnosticAt(SeverityError, text, option.Pos, option.End,
+					"option comments expects true or false but got %q", optionValue))
+				continue
+			}
+			cgo.RemoveComments = !enableComment
+		case "doc":
+			enableDoc, err := strconv.ParseBool(optionValue)
+			if err != nil {
+				diags = append(diags, newDiagnosticAt(SeverityError, text, option.Pos, option.End,
+					"option doc expects true or false but got %q", optionValue))
+				continue
+			}
+			cgo.RemoveDoc = !enableDoc
+		case "bodies":
+			if elide, ok := choice(option, "elide", "keep"); ok {
+				cgo.ElideBodies = elide
+			}
+		case "signature":
+			if only, ok := choice(option, "only", "full"); ok {
+				cgo.SignatureOnly = only
+			}
+		case "fields":
+			if exported, ok := choice(option, "exported", "all"); ok {
+				cgo.ExportedFieldsOnly = exported
+			}
+		case "errcheck":
+			mode, err := ParseErrCheckMode(optionValue)
+			if err != nil {
+				diags = append(diags, newDiagnosticAt(SeverityError, text, option.Pos, option.End,
+					"option errcheck expects keep, fold or remove but got %q", optionValue))
+				continue
+			}
+			cgo.ErrChecks = mode
+		case "errcheck_placeholder":
+			cgo.ErrCheckPlaceholder = optionValue
+		case "format":
+			if gofmt, ok := choice(option, "gofmt", "none"); ok {
+				cgo.FormatGo = gofmt
 			}
-			cgo.removeComments = !enableComment
 		case "indent":
 			indentationLevel, err := strconv.ParseInt(optionValue, 10, 32)
 			if err != nil {
-				fmt.Println("Could not parse option:", err.Error())
+				diags = append(diags, newDiagnosticAt(SeverityError, text, option.Pos, option.End,
+					"option indent expects a number but got %q", optionValue))
+				continue
 			}
-			cgo.indentLevel = int(indentationLevel)
+			cgo.IndentLevel = int(indentationLevel)
 		default:
-			fmt.Println("Did not understand option key:", optionKey)
+			diags = append(diags, newDiagnosticAt(SeverityWarning, text, option.Pos, option.End,
+				"did not understand option key %q", optionKey))
 		}
 	}
 
-	return &cgo
+	return &cgo, diags
 }
 
 func MakeDefaultCodeGenOptions() CodeGenOptions {
@@ -1,16 +1,11 @@
 package code_dsl
 
 import (
-	"bufio"
 	"container/list"
 	"errors"
 	"fmt"
-	"log"
-	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -64,16 +59,35 @@ func adaptIndent(line string, indent int) string {
 type CodeBlock struct {
 	fileRange LineRange
 	lines     list.List
+	// Lines that were removed by a rewrite, see go_rewrite.go
+	removed map[int]bool
+}
+
+// Returns the text of a line in the block, using the line number of the file.
+func (cb *CodeBlock) lineAt(lineNum int) string {
+	current := cb.fileRange.start
+	for e := cb.lines.Front(); e != nil; e = e.Next() {
+		if current == lineNum {
+			return e.Value.(string)
+		}
+		current++
+	}
+	return ""
 }
 
 // Render a CodeBlock as a string
-func (cb CodeBlock) render(highlights *Highlights, visuals *VisualModifications, language string, options CodeGenOptions) string {
+func (cb CodeBlock) render(highlights *Highlights, visuals *VisualModifications, markerSyntaxes []CommentSyntax, options CodeGenOptions) string {
 	strRepr := ""
 
 	lineNum := cb.fileRange.start
-	for e := cb.lines.Front(); e != nil; e = e.Next() {
+	for e := cb.lines.Front(); e != nil; e, lineNum = e.Next(), lineNum+1 {
 		line := e.Value.(string)
 
+		// Markers of nested or overlapping blocks are not part of the code.
+		if cb.removed[lineNum] || isCodeBlockPairMarker(line, markerSyntaxes) {
+			continue
+		}
+
 		if highlights != nil && highlights.Contains(lineNum) {
 			if highlights.HasSubrange(lineNum) {
 				line = highlights.RenderSubrange(line, lineNum)
@@ -84,9 +98,8 @@ func (cb CodeBlock) render(highlights *Highlights, visuals *VisualModifications,
 		}
 
 		if visuals != nil {
-			vLine, useLine := visuals.ModifyLine(line, lineNum, language)
+			vLine, useLine := visuals.ModifyLine(line, lineNum)
 			if !useLine { // Skip line
-				lineNum++
 				continue
 			}
 			line = vLine
@@ -104,8 +117,6 @@ func (cb CodeBlock) render(highlights *Highlights, visuals *VisualModifications,
 		}
 
 		strRepr += line + "\n"
-
-		lineNum++
 	}
 
 	return strRepr
@@ -180,6 +191,8 @@ type VisualModification struct {
 
 type VisualModifications struct {
 	modifications list.List
+	// Comment syntax of the code, used for the placeholders of hidden code
+	comments CommentSyntax
 }
 
 func (vm *VisualModifications) Init() {
@@ -190,7 +203,8 @@ func (vm *VisualModifications) PushBack(v VisualModification) *list.Element {
 	return vm.modifications.PushBack(v)
 }
 
-func (vm *VisualModifications) ModifyLine(line string, lineNum int, language string) (string, bool) {
+func (vm *VisualModifications) ModifyLine(line string, lineNum int) (string, bool) {
+	comments := vm.comments
 	for e := vm.modifications.Front(); e != nil; e = e.Next() {
 		vm := e.Value.(VisualModification)
 		switch lrs := vm.lineRangeSpecifier.(type) {
@@ -203,15 +217,16 @@ func (vm *VisualModifications) ModifyLine(line string, lineNum int, language str
 					placeHolder = ""
 				}
 				lineRunes := []rune(line)
-				if lrs.end > len(line) {
-					panic("Specified visual range ends after line.")
-				}
-				return string(lineRunes[:lrs.start]) + makeMultilineComment(placeHolder, language) + string(lineRunes[lrs.end:]), true
+				// Char ranges are validated against the code before rendering,
+				// clamping only protects us from misuse.
+				start, end := clampToLine(lrs.start, len(lineRunes)), clampToLine(lrs.end, len(lineRunes))
+				placeHolder = makeMultilineComment(placeHolder, comments, end == len(lineRunes))
+				return string(lineRunes[:start]) + placeHolder + string(lineRunes[end:]), true
 			}
 		case LineNumber:
 			if lrs.Contains(lineNum) {
 				if vm.modeType == ReplaceWithDots {
-					return strings.Repeat(" ", getIndent(line)) + makeComment(" ...", language), true
+					return strings.Repeat(" ", getIndent(line)) + makeComment(" ...", comments), true
 				} else if vm.modeType == Hide {
 					return "", true
 				} else if vm.modeType == Remove {
@@ -226,7 +241,7 @@ func (vm *VisualModifications) ModifyLine(line string, lineNum int, language str
 					if lrs.end != lineNum {
 						return "", false
 					}
-					return strings.Repeat(" ", getIndent(line)) + makeComment(" ...", language), true
+					return strings.Repeat(" ", getIndent(line)) + makeComment(" ...", comments), true
 				} else if vm.modeType == Hide {
 					return "", true
 				} else if vm.modeType == Remove {
@@ -243,6 +258,16 @@ func (vm *VisualModifications) ModifyLine(line string, lineNum int, language str
 	return line, true
 }
 
+func clampToLine(pos int, lineLength int) int {
+	if pos < 0 {
+		return 0
+	}
+	if pos > lineLength {
+		return lineLength
+	}
+	return pos
+}
+
 // TODO: refactor to own util file
 func insertAt(baseStr string, pos int, text string) string {
 	updatedString := ""
@@ -298,391 +323,391 @@ func (hl *Highlights) Show() {
 }
 
 type CodeInsertion struct {
-	codeBlock  CodeBlock
-	progLang   string
-	visuals    VisualModifications
-	highlights Highlights
-	options    CodeGenOptions
+	codeBlock CodeBlock
+	progLang  string
+	// Comment syntaxes of the code_block markers in the code
+	markerSyntaxes []CommentSyntax
+	visuals        VisualModifications
+	highlights     Highlights
+	options        CodeGenOptions
+	// Problems that did not prevent rendering the code
+	warnings Diagnostics
+	// The code formatted with gofmt, see go_format.go
+	formatted   string
+	isFormatted bool
 }
 
 func (ci CodeInsertion) renderCodeBlock() string {
-	return ci.codeBlock.render(&ci.highlights, &ci.visuals, ci.progLang, ci.options)
+	if ci.isFormatted {
+		return ci.formatted
+	}
+	return ci.codeBlock.render(&ci.highlights, &ci.visuals, ci.markerSyntaxes, ci.options)
 }
 
 type insertCodeInfo struct {
 	filename  string
 	filerange LineRange
+	// Line that relative selections count from
+	relativeStart int
 }
 
-var insertCodeRgx = regexp.MustCompile("insert_code\\((?P<filename>.*):(?P<filerange>.*).*\\).*")
-
-func parserInsertCodeInfo(line string) (insertCodeInfo, error) {
-	match := insertCodeRgx.FindStringSubmatch(line)
-	if match == nil {
-		panic("Line did not contain correct insert_code pattern.")
-	}
-	matchResults := make(map[string]string)
-	for i, name := range insertCodeRgx.SubexpNames() {
-		if i != 0 && name != "" {
-			matchResults[name] = match[i]
-		}
+func parserInsertCodeInfo(cmd *Command) (insertCodeInfo, error) {
+	if cmd.Kind != InsertCodeCommand {
+		return insertCodeInfo{}, newCommandError(cmd, cmd.Pos, cmd.File.Pos, "command is not an insert_code command")
 	}
+	return insertCodeInfo{cmd.File.Path, LineRange{cmd.Range.Start, cmd.Range.End}, cmd.Range.Start}, nil
+}
 
-	filename := matchResults["filename"]
-	filerange := strings.Split(matchResults["filerange"], "-")
-	start, err := strconv.ParseInt(filerange[0], 10, 32)
-	if err != nil {
-		log.Fatal("Could not parse start of the file Range", err)
+func parseRevInsertCodeInfo(cmd *Command, env *Env) (insertCodeInfo, error) {
+	if cmd.Kind != RevInsertCodeCommand {
+		return insertCodeInfo{}, newCommandError(cmd, cmd.Pos, cmd.File.Pos, "command is not a rev_insert_code command")
 	}
-	end, err := strconv.ParseInt(filerange[1], 10, 32)
-	if err != nil {
-		log.Fatal("Could not parse end of the file Range", err)
+	if d := resolveGlobalBlock(cmd, env); d != nil {
+		return insertCodeInfo{}, d
 	}
-
-	return insertCodeInfo{filename, LineRange{int(start), int(end)}}, nil
-}
-
-var codeBlockRgx = regexp.MustCompile(".*code_block\\((?P<BlockID>.*):(?P<filerange>.*)\\).*")
-
-func parseCodeBlockLineRangeFromFile(filepath string, blockID string) (LineRange, error) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		log.Fatal("Could not open Source File: ", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	lineNumber := 1
-	for scanner.Scan() {
-		line := scanner.Text()
-		match := codeBlockRgx.FindStringSubmatch(line)
-		if match != nil {
-			matchResults := make(map[string]string)
-			for i, name := range codeBlockRgx.SubexpNames() {
-				if i != 0 && name != "" {
-					matchResults[name] = match[i]
-				}
-			}
-			if matchResults["BlockID"] == blockID {
-				filerange := strings.Split(matchResults["filerange"], "-")
-				start, err := strconv.ParseInt(filerange[0], 10, 32)
-				if err != nil {
-					log.Fatal("Could not parse start of the file Range", err)
-				}
-				end, err := strconv.ParseInt(filerange[1], 10, 32)
-				if err != nil {
-					log.Fatal("Could not parse end of the file Range", err)
-				}
-				return LineRange{lineNumber + int(start), lineNumber + int(end)}, nil
-			}
-		}
-
-		lineNumber++
+	lineRange, err := parseCodeBlockLineRangeFromFile(env, cmd.File.Path, cmd.Block.ID)
+	if errors.Is(err, errNoBlockID) {
+		d := newCommandError(cmd, cmd.Block.Pos, cmd.Block.End, "could not find code_block %s in %s", cmd.Block.ID, cmd.File.Path)
+		d.Err = err
+		return insertCodeInfo{}, d
+	} else if err != nil {
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not read source file: %s", err)
+		d.Err = err
+		return insertCodeInfo{}, d
 	}
-
-	return LineRange{0, 0}, errors.New("No valid BlockID found in file.")
+	return insertCodeInfo{cmd.File.Path, lineRange, lineRange.start}, nil
 }
 
-var revInsertCodeRgx = regexp.MustCompile("rev_insert_code\\((?P<filename>.*):(?P<BlockID>.*)\\).*")
-
-func parseRevInsertCodeInfo(line string, codeRoot string) (insertCodeInfo, error) {
-	match := revInsertCodeRgx.FindStringSubmatch(line)
-	if match == nil {
-		panic("Line did not contain correct rev_insert_code pattern.")
+func parseInsertSymbolInfo(cmd *Command, env *Env) (insertCodeInfo, error) {
+	if cmd.Kind != InsertSymbolCommand {
+		return insertCodeInfo{}, newCommandError(cmd, cmd.Pos, cmd.File.Pos, "command is not an insert_symbol command")
 	}
-	matchResults := make(map[string]string)
-	for i, name := range revInsertCodeRgx.SubexpNames() {
-		if i != 0 && name != "" {
-			matchResults[name] = match[i]
-		}
+	language := env.language(cmd.File.Path)
+	if language != "go" && symbolStyles[language] == 0 {
+		return insertCodeInfo{}, newCommandError(cmd, cmd.File.Pos, cmd.File.End,
+			"insert_symbol does not support %s, only Go, C, C++, Java and Python files can be searched for symbols", cmd.File.Path)
 	}
-
-	filename := matchResults["filename"]
-	lineRange, err := parseCodeBlockLineRangeFromFile(codeRoot+filename, matchResults["BlockID"])
-	return insertCodeInfo{filename, lineRange}, err
-}
-
-type appendCharRange func(CharRange)
-
-func parseCharRanges(block string, addCharRange appendCharRange, baseCodeRange *LineRange, handleLinesRelative bool) {
-	lineCharRange := strings.Split(block, ":")
-	lineNum, err := strconv.ParseInt(lineCharRange[0], 10, 32)
-	charRanges := lineCharRange[1]
+	sourceFile, err := env.Sources.Load(cmd.File.Path)
 	if err != nil {
-		log.Fatal("Could not parse line number", err)
-	}
-
-	if handleLinesRelative {
-		// -1 is relevant because line numbers start a 1 not 0
-		lineNum = int64(baseCodeRange.start) + lineNum - 1
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not read source file: %s", err)
+		d.Err = err
+		return insertCodeInfo{}, d
+	}
+
+	// The doc option changes the lines of the symbol, its problems are
+	// reported together with the other options.
+	options, _ := makeCodeGenOptions(env.Defaults, cmd.Options, cmd.Text)
+	var lineRange LineRange
+	var declStart int
+	var syntaxErr error
+	if language == "go" {
+		goAST := sourceFile.goAST()
+		lineRange, declStart, err = findGoSymbol(goAST, cmd.Symbol.Name, !options.hideDoc())
+		syntaxErr = goAST.err
+	} else {
+		lineRange, declStart, err = findHeuristicSymbol(sourceFile.Lines, language, env.commentSyntax(language), cmd.Symbol.Name, !options.hideDoc())
 	}
-
-	charRanges = strings.TrimLeft(charRanges, "{")
-	charRanges = strings.TrimRight(charRanges, "}")
-
-	splitCharRanges := strings.Split(charRanges, "|")
-	for _, charRange := range splitCharRanges {
-		splitCharRange := strings.Split(charRange, "-")
-
-		charStart, err := strconv.ParseInt(splitCharRange[0], 10, 32)
-		if err != nil {
-			log.Fatal("Could not parse char range start", err)
+	if errors.Is(err, errNoSymbol) {
+		d := newCommandError(cmd, cmd.Symbol.Pos, cmd.Symbol.End, "could not find symbol %s in %s", cmd.Symbol.Name, cmd.File.Path)
+		if syntaxErr != nil {
+			// The symbol may be part of the code that could not be parsed.
+			d.Msg += fmt.Sprintf(", the file has syntax errors: %s", syntaxErr)
 		}
-
-		charEnd, err := strconv.ParseInt(splitCharRange[1], 10, 32)
-		if err != nil {
-			log.Fatal("Could not parse char range end", err)
+		d.Err = err
+		return insertCodeInfo{}, d
+	} else if err != nil {
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not parse %s: %s", cmd.File.Path, err)
+		d.Err = err
+		return insertCodeInfo{}, d
+	}
+	// Relative selections count from the declaration, not from its doc
+	// comment.
+	return insertCodeInfo{cmd.File.Path, lineRange, declStart}, nil
+}
+
+// relativeLines maps the line numbers of relative selections, e.g., "r{2}",
+// onto the lines of the file. Line 1 is the first line of the inserted code
+// and only the lines that are rendered are counted, so the markers of nested
+// code_block pairs, which are left out of the output, have no number.
+type relativeLines struct {
+	// Line of the file that is relative line 1
+	base int
+	// Lines of the file that are not counted
+	skipped map[int]bool
+}
+
+// Counts the lines of a code block from base on, skipping the markers of
+// code_block pairs.
+func makeRelativeLines(cb *CodeBlock, base int, markerSyntaxes []CommentSyntax) relativeLines {
+	rl := relativeLines{base: base, skipped: map[int]bool{}}
+	lineNum := cb.fileRange.start
+	for e := cb.lines.Front(); e != nil; e, lineNum = e.Next(), lineNum+1 {
+		if isCodeBlockPairMarker(e.Value.(string), markerSyntaxes) {
+			rl.skipped[lineNum] = true
 		}
-
-		addCharRange(CharRange{LineNumber{int(lineNum)}, int(charStart), int(charEnd)})
 	}
+	return rl
 }
 
-func parseCharRangesHighlights(hlBlock string, highlights *Highlights, baseCodeRange *LineRange, handleLinesRelative bool) {
-	addHighlight := func(cr CharRange) {
-		highlights.PushBack(cr)
+// Returns the line of the file for a relative line number. Numbers below 1
+// refer to the lines before the base.
+func (rl relativeLines) fileLine(lineNum int) int {
+	if lineNum < 1 {
+		return rl.base + lineNum - 1
 	}
-	parseCharRanges(hlBlock, addHighlight, baseCodeRange, handleLinesRelative)
-}
-
-func parseCharRangesVisuals(vlBlock string, visuals *VisualModifications, baseCodeRange *LineRange, handleLinesRelative bool, vmt VisualModificationType) {
-	addVisual := func(cr CharRange) {
-		visuals.PushBack(VisualModification{cr, vmt})
+	line := rl.base - 1
+	for counted := 0; counted < lineNum; {
+		line++
+		if !rl.skipped[line] {
+			counted++
+		}
 	}
-	parseCharRanges(vlBlock, addVisual, baseCodeRange, handleLinesRelative)
+	return line
 }
 
-type appendLineRange func(LineRange)
-
-func parseLineRange(block string, addLineRange appendLineRange, baseCodeRange *LineRange, handleLinesRelative bool) {
-	block_split := strings.Split(block, "-")
-	start, err := strconv.ParseInt(block_split[0], 10, 32)
-	if err != nil {
-		log.Fatal("Could not parse start of the Range ", err)
+// Converts a selector from the AST into the line specifiers used for
+// rendering, i.e., LineNumber, LineRange or CharRange. Relative line numbers
+// are mapped onto the file, see relativeLines.
+func makeLineSpecifiers(sel Selector, rl relativeLines, handleLinesRelative bool) []interface{} {
+	toFileLine := func(lineNum int) int {
+		return toFileLineNumber(lineNum, rl, handleLinesRelative)
 	}
-	end, err := strconv.ParseInt(block_split[1], 10, 32)
-	if err != nil {
-		log.Fatal("Could not parse end of the Range ", err)
-	}
-	if handleLinesRelative {
-		// -1 is relevant because line numbers start a 1 not 0
-		start = int64(baseCodeRange.start) + start - 1
-		end = int64(baseCodeRange.start) + end - 1
-	}
-	addLineRange(LineRange{int(start), int(end)})
-}
 
-func parseLineRangeHightlights(block string, highlights *Highlights, baseCodeRange *LineRange, handleLinesRelative bool) {
-	addHighlight := func(cr LineRange) {
-		highlights.PushBack(cr)
+	switch s := sel.(type) {
+	case *LineSelector:
+		return []interface{}{LineNumber{toFileLine(s.Line)}}
+	case *LineRangeSelector:
+		return []interface{}{LineRange{toFileLine(s.Start), toFileLine(s.End)}}
+	case *CharRangeSelector:
+		specifiers := []interface{}{}
+		for _, span := range s.Ranges {
+			specifiers = append(specifiers, CharRange{LineNumber{toFileLine(s.Line)}, span.Start, span.End})
+		}
+		return specifiers
+	default:
+		panic("Selector type not supported.")
 	}
-	parseLineRange(block, addHighlight, baseCodeRange, handleLinesRelative)
 }
 
-func parseLineRangeVisuals(block string, visuals *VisualModifications, baseCodeRange *LineRange, handleLinesRelative bool, vmt VisualModificationType) {
-	addVisual := func(cr LineRange) {
-		visuals.PushBack(VisualModification{cr, vmt})
+func toFileLineNumber(lineNum int, rl relativeLines, handleLinesRelative bool) int {
+	if handleLinesRelative {
+		return rl.fileLine(lineNum)
 	}
-	parseLineRange(block, addVisual, baseCodeRange, handleLinesRelative)
+	return lineNum
 }
 
-type appendLineNumber func(LineNumber)
-
-func parseLineNumber(block string, addLineNumber appendLineNumber, baseCodeRange *LineRange, handleLinesRelative bool) {
-	lineNum, err := strconv.ParseInt(block, 10, 32)
-	if err != nil {
-		log.Fatal("Could not parse line number", err)
-	}
-	if handleLinesRelative {
-		// -1 is relevant because line numbers start a 1 not 0
-		lineNum = int64(baseCodeRange.start) + lineNum - 1
+func parseHighlights(hs *HighlightSelection, highlights *Highlights, rl relativeLines) {
+	if hs == nil { // No highlights specified
+		return
 	}
-	addLineNumber(LineNumber{int(lineNum)})
-}
 
-func parseLineNumberHightlights(block string, highlights *Highlights, baseCodeRange *LineRange, handleLinesRelative bool) {
-	addHighlight := func(cr LineNumber) {
-		highlights.PushBack(cr)
+	for _, sel := range hs.Items {
+		for _, specifier := range makeLineSpecifiers(sel, rl, hs.Relative) {
+			highlights.PushBack(specifier)
+		}
 	}
-	parseLineNumber(block, addHighlight, baseCodeRange, handleLinesRelative)
 }
 
-func parseLineNumberVisuals(block string, visuals *VisualModifications, baseCodeRange *LineRange, handleLinesRelative bool, vmt VisualModificationType) {
-	addVisual := func(cr LineNumber) {
-		visuals.PushBack(VisualModification{cr, vmt})
+func getVisualModType(mode VisualMode) VisualModificationType {
+	switch mode {
+	case 'd':
+		return ReplaceWithDots
+	case 'r':
+		return Remove
+	default:
+		return Hide
 	}
-	parseLineNumber(block, addVisual, baseCodeRange, handleLinesRelative)
 }
 
-// Works for rev_insert_code and insert_code
-var highlightCodeRgx = regexp.MustCompile("insert_code\\(.*\\).*?(?P<rel>[r\\{]+)(?P<highlights>.*)\\}")
-
-func parseHighlights(line string, highlights *Highlights, baseCodeRange *LineRange) {
-	if strings.Contains(line, "<") { // Hide visual selection
-		startVis := 0
-		if strings.Contains(line, "r<") {
-			startVis = strings.Index(line, "r<")
-		} else {
-			startVis = strings.Index(line, "<")
-		}
-		endVis := strings.Index(line, ">")
-		line = line[:startVis] + line[endVis+1:]
+func parseVisuals(cmd *Command, visuals *VisualModifications, rl relativeLines) Diagnostics {
+	vs := cmd.Visuals
+	if vs == nil { // No visuals specified
+		return nil
 	}
 
-	match := highlightCodeRgx.FindStringSubmatch(line)
-	if match == nil { // Return when we did not find any highlights
-		return
-	}
-	matchResults := make(map[string]string)
-	for i, name := range highlightCodeRgx.SubexpNames() {
-		if i != 0 && name != "" {
-			matchResults[name] = match[i]
+	diags := Diagnostics{}
+	for _, item := range vs.Items {
+		if item.Mode == 0 {
+			diags = append(diags, newCommandWarning(cmd, item.Pos, item.End, "no visual modification type set, defaulting to hiding the lines"))
 		}
-	}
-
-	handleLinesRelative := matchResults["rel"] == "r{"
-
-	blocks := strings.Split(matchResults["highlights"], ",")
-	for _, block := range blocks {
-		if strings.Contains(block, ":") { // Got and inline hl block
-			parseCharRangesHighlights(block, highlights, baseCodeRange, handleLinesRelative)
-		} else if strings.Contains(block, "-") {
-			parseLineRangeHightlights(block, highlights, baseCodeRange, handleLinesRelative)
-		} else { // Handle single line number
-			parseLineNumberHightlights(block, highlights, baseCodeRange, handleLinesRelative)
+		vmt := getVisualModType(item.Mode)
+		for _, specifier := range makeLineSpecifiers(item.Selector, rl, vs.Relative) {
+			visuals.PushBack(VisualModification{specifier, vmt})
 		}
 	}
-}
-
-// Works for rev_insert_code and insert_code
-var visualCodeRgx = regexp.MustCompile("insert_code\\(.*\\).*?(?P<mod>[r]*\\<)(?P<visuals>.*)\\>")
-
-func parseVisuals(line string, visuals *VisualModifications, baseCodeRange *LineRange) {
-	match := visualCodeRgx.FindStringSubmatch(line)
-	if match == nil { // Return when we did not find any visuals
-		return
-	}
-	matchResults := make(map[string]string)
-	for i, name := range visualCodeRgx.SubexpNames() {
-		if i != 0 && name != "" {
-			matchResults[name] = match[i]
+	return diags
+}
+
+// Checks that all selected lines and chars exist in the inserted code.
+// Selections outside of the inserted lines are ignored while rendering, so
+// they are only reported as warnings, like selections of the markers of
+// code_block pairs, which are not rendered. Char ranges that run past the end
+// of a line are errors.
+func validateSelections(cmd *Command, cb *CodeBlock, rl relativeLines) Diagnostics {
+	diags := Diagnostics{}
+	checkSelector := func(sel Selector, relative bool, firstCharNum int) {
+		lines := []int{}
+		switch s := sel.(type) {
+		case *LineSelector:
+			lines = append(lines, s.Line)
+		case *LineRangeSelector:
+			lines = append(lines, s.Start, s.End)
+		case *CharRangeSelector:
+			lines = append(lines, s.Line)
+		}
+		for _, lineNum := range lines {
+			fileLineNum := toFileLineNumber(lineNum, rl, relative)
+			if !cb.fileRange.Contains(fileLineNum) {
+				diags = append(diags, newCommandWarning(cmd, sel.Position(), sel.EndPosition(),
+					"line %d is outside of the inserted lines %d-%d", fileLineNum, cb.fileRange.start, cb.fileRange.end))
+				return
+			}
+			if rl.skipped[fileLineNum] {
+				diags = append(diags, newCommandWarning(cmd, sel.Position(), sel.EndPosition(),
+					"line %d is a code_block marker, which is not part of the inserted code", fileLineNum))
+				return
+			}
 		}
-	}
-
-	handleLinesRelative := strings.Contains(matchResults["mod"], "r")
-
-	blocks := strings.Split(matchResults["visuals"], ",")
-	for _, block := range blocks {
-		replaceWithDots := strings.HasPrefix(block, "d")
-		hideLines := strings.HasPrefix(block, "h")
-		removeLines := strings.HasPrefix(block, "r")
 
-		if !replaceWithDots && !hideLines && !removeLines {
-			log.Println("No visual modification type set, defaulting to hidding the lines.")
-			hideLines = true
+		cr, ok := sel.(*CharRangeSelector)
+		if !ok {
+			return
 		}
-		getVisualModType := func() VisualModificationType {
-			if replaceWithDots {
-				return ReplaceWithDots
-			} else if removeLines {
-				return Remove
-			} else {
-				return Hide
+		line := []rune(cb.lineAt(toFileLineNumber(cr.Line, rl, relative)))
+		for _, span := range cr.Ranges {
+			if span.Start < firstCharNum || span.End > len(line) {
+				diags = append(diags, newCommandError(cmd, span.Pos, span.EndPos,
+					"char range %d-%d does not fit into line %d with %d chars", span.Start, span.End, cr.Line, len(line)))
 			}
 		}
+	}
 
-		block = strings.TrimLeft(block, "hdr")
-
-		if strings.Contains(block, ":") { // Got and inline hl block
-			parseCharRangesVisuals(block, visuals, baseCodeRange, handleLinesRelative, getVisualModType())
-		} else if strings.Contains(block, "-") {
-			parseLineRangeVisuals(block, visuals, baseCodeRange, handleLinesRelative, getVisualModType())
-		} else { // Handle single line number
-			parseLineNumberVisuals(block, visuals, baseCodeRange, handleLinesRelative, getVisualModType())
+	if cmd.Highlights != nil {
+		for _, sel := range cmd.Highlights.Items {
+			// Highlight char ranges start counting at 1
+			checkSelector(sel, cmd.Highlights.Relative, 1)
+		}
+	}
+	if cmd.Visuals != nil {
+		for _, item := range cmd.Visuals.Items {
+			checkSelector(item.Selector, cmd.Visuals.Relative, 0)
 		}
 	}
+	return diags
 }
 
-var optionsRegex = regexp.MustCompile("insert_code\\(.*\\).*?\\[(?P<Options>.*)\\]")
-
-func consumeOptionsString(line string) (string, string) {
-	match := optionsRegex.FindStringSubmatch(line)
-	if match == nil { // Return when we did not find any visuals
-		return "", line
+func parseInsertCode(line string, env *Env) (CodeInsertion, error) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return CodeInsertion{}, err
 	}
-	matchResults := make(map[string]string)
-	for i, name := range optionsRegex.SubexpNames() {
-		if i != 0 && name != "" {
-			matchResults[name] = match[i]
-		}
+	relocated := relocateInsertCode(cmd, env)
+	icInfo, err := parserInsertCodeInfo(cmd)
+	if err != nil {
+		return CodeInsertion{}, err
 	}
 
-	return matchResults["Options"], line
+	ci, err := makeCodeInsertion(cmd, icInfo, env)
+	if err == nil && relocated != nil {
+		ci.warnings = append(Diagnostics{relocated}, ci.warnings...)
+	}
+	return ci, err
 }
 
-func parseInsertCode(line string, codeRoot string) (CodeInsertion, error) {
-	icInfo, err := parserInsertCodeInfo(line)
+func parseRevInsertCode(line string, env *Env) (CodeInsertion, error) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return CodeInsertion{}, err
+	}
+	icInfo, err := parseRevInsertCodeInfo(cmd, env)
 	if err != nil {
 		return CodeInsertion{}, err
 	}
 
-	ci := CodeInsertion{}
-	ci.codeBlock = parseCodeBlock(codeRoot+icInfo.filename, icInfo.filerange.start, icInfo.filerange.end)
-	ci.progLang = getProgrammingLanguage(icInfo.filename)
-	ci.visuals.Init()
-	ci.highlights.Init()
-
-	optionsStr := ""
-	optionsStr, line = consumeOptionsString(line)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(line, &ci.highlights, &icInfo.filerange)
-	parseVisuals(line, &ci.visuals, &icInfo.filerange)
-	return ci, nil
+	return makeCodeInsertion(cmd, icInfo, env)
 }
 
-func parseRevInsertCode(line string, codeRoot string) (CodeInsertion, error) {
-	icInfo, err := parseRevInsertCodeInfo(line, codeRoot)
+func parseInsertSymbol(line string, env *Env) (CodeInsertion, error) {
+	cmd, err := ParseCommand(line)
 	if err != nil {
 		return CodeInsertion{}, err
 	}
+	icInfo, err := parseInsertSymbolInfo(cmd, env)
+	if err != nil {
+		return CodeInsertion{}, err
+	}
+
+	return makeCodeInsertion(cmd, icInfo, env)
+}
 
+// Creates the CodeInsertion for a command. Problems that prevent us from
+// rendering the code are returned as error, all other problems are attached
+// to the CodeInsertion as warnings.
+func makeCodeInsertion(cmd *Command, icInfo insertCodeInfo, env *Env) (CodeInsertion, error) {
 	ci := CodeInsertion{}
-	ci.codeBlock = parseCodeBlock(codeRoot+icInfo.filename, icInfo.filerange.start, icInfo.filerange.end)
-	ci.progLang = getProgrammingLanguage(icInfo.filename)
+	codeBlock, err := parseCodeBlock(env.Sources, icInfo.filename, icInfo.filerange.start, icInfo.filerange.end)
+	if err != nil {
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not read source file: %s", err)
+		d.Err = err
+		return ci, d
+	}
+	ci.codeBlock = codeBlock
+	ci.progLang = env.language(icInfo.filename)
+	ci.markerSyntaxes = env.markerSyntaxes(icInfo.filename)
 	ci.visuals.Init()
+	ci.visuals.comments = env.commentSyntax(ci.progLang)
 	ci.highlights.Init()
 
-	optionsStr := ""
-	optionsStr, line = consumeOptionsString(line)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(line, &ci.highlights, &icInfo.filerange)
-	parseVisuals(line, &ci.visuals, &icInfo.filerange)
-	return ci, err
+	diags := Diagnostics{}
+	if sourceFile, err := env.Sources.Load(icInfo.filename); err == nil && len(sourceFile.Source.Shadowed) > 0 {
+		diags = append(diags, newShadowedWarning(cmd, sourceFile.Source))
+	}
+	if lastLine := codeBlock.fileRange.start + codeBlock.lines.Len() - 1; lastLine < codeBlock.fileRange.end {
+		from, to := cmd.File.Pos, cmd.File.End
+		if cmd.Range != nil {
+			from, to = cmd.Range.Pos, cmd.Range.EndPos
+		} else if cmd.Block != nil {
+			from, to = cmd.Block.Pos, cmd.Block.End
+		} else if cmd.Symbol != nil {
+			from, to = cmd.Symbol.Pos, cmd.Symbol.End
+		}
+		diags = append(diags, newCommandWarning(cmd, from, to, "range %d-%d ends after the last line %d of %s",
+			codeBlock.fileRange.start, codeBlock.fileRange.end, lastLine, icInfo.filename))
+	}
+	options, optionDiags := makeCodeGenOptions(env.Defaults, cmd.Options, cmd.Text)
+	ci.options = options
+	diags = append(diags, optionDiags...)
+
+	relative := makeRelativeLines(&ci.codeBlock, icInfo.relativeStart, ci.markerSyntaxes)
+	diags = append(diags, validateSelections(cmd, &ci.codeBlock, relative)...)
+	diags = append(diags, rewriteGoCode(cmd, &ci, icInfo.filename, env)...)
+	parseHighlights(cmd.Highlights, &ci.highlights, relative)
+	diags = append(diags, parseVisuals(cmd, &ci.visuals, relative)...)
+	if diags.HasErrors() {
+		return ci, diags
+	}
+	diags = append(diags, formatGoCode(cmd, &ci)...)
+	ci.warnings = diags
+	return ci, nil
 }
 
-func parseCodeBlock(filepath string, start int, end int) CodeBlock {
+func parseCodeBlock(sources *SourceResolver, filename string, start int, end int) (CodeBlock, error) {
 	cb := CodeBlock{}
 	cb.fileRange = LineRange{start, end}
 	cb.lines.Init()
 
-	file, err := os.Open(filepath)
+	sourceFile, err := sources.Load(filename)
 	if err != nil {
-		log.Fatal("Could not open Source File: ", err)
+		return cb, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	lineNumber := 1
-	for scanner.Scan() {
-		if lineNumber >= start && lineNumber <= end {
-			cb.lines.PushBack(scanner.Text())
+	for lineNumber := start; lineNumber <= end && lineNumber <= len(sourceFile.Lines); lineNumber++ {
+		if lineNumber >= 1 {
+			cb.lines.PushBack(sourceFile.Lines[lineNumber-1])
 		}
-		lineNumber++
 	}
 
-	return cb
+	return cb, nil
 }
 
 // Returns the number of spaces used to indent a line
@@ -690,12 +715,28 @@ func getIndent(line string) int {
 	return len(line) - len(strings.TrimLeft(line, " "))
 }
 
-func makeComment(line string, language string) string {
-	return "//" + line
+// Turns the text into a comment that fills the rest of a line, e.g., "// ...".
+// Languages without line comments use a block comment.
+func makeComment(text string, syntax CommentSyntax) string {
+	if syntax.Line != "" {
+		return syntax.Line + text
+	}
+	return syntax.BlockStart + text + " " + syntax.BlockEnd
 }
 
-func makeMultilineComment(line string, language string) string {
-	return "/*" + line + "*/"
+// Turns the text into a comment inside of a line, e.g., "/* ... */".
+// Languages without block comments use a line comment if the comment ends
+// the line and the plain text otherwise, which keeps the rest of the line
+// visible.
+func makeMultilineComment(text string, syntax CommentSyntax, endsLine bool) string {
+	switch {
+	case syntax.BlockStart != "":
+		return syntax.BlockStart + text + syntax.BlockEnd
+	case endsLine && text != "":
+		return syntax.Line + strings.TrimRight(text, " ")
+	default:
+		return text
+	}
 }
 
 func getProgrammingLanguage(filename string) string {
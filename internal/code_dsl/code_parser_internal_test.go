@@ -1,10 +1,20 @@
 package code_dsl
 
 import (
-	"github.com/Flaque/filet"
+	"io/fs"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
+// Creates an environment with an in-memory file system that contains a
+// single code file.
+func makeTestEnv(filename string, content string) *Env {
+	fsys := fstest.MapFS{filename: &fstest.MapFile{Data: []byte(content)}}
+	return &Env{Sources: NewSourceResolver(fsys)}
+}
+
 func TestInsertAtInbetween(t *testing.T) {
 	baseString := "123456789"
 	expectedString := "1234@56789"
@@ -143,9 +153,8 @@ func TestRenderMultipleMissorderedSubranges(t *testing.T) {
 }
 
 func TestParseCodeBlock(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -155,7 +164,10 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 
-	cb := parseCodeBlock(codeFilePath, 2, 4)
+	cb, err := parseCodeBlock(env.Sources, codeFilePath, 2, 4)
+	if err != nil {
+		t.Fatal("Could not parse code block:", err)
+	}
 
 	if cb.fileRange.start != 2 {
 		t.Error("CodeBlock starts at the wrong line.")
@@ -165,7 +177,7 @@ int main(/* int argc, char *argv[] */) {
 		t.Error("CodeBlock ends at the wrong line.")
 	}
 
-	renderedCode := cb.render(nil, nil, "cpp", MakeDefaultCodeGenOptions())
+	renderedCode := cb.render(nil, nil, CommentRegistry(nil).markerSyntaxes("cpp"), MakeDefaultCodeGenOptions())
 
 	if renderedCode != `T shaveTheYak(T t) {
   return t;
@@ -180,9 +192,8 @@ int main(/* int argc, char *argv[] */) {
 // insert_code
 
 func TestRenderCodeBlock(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -192,7 +203,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 
-	ci, err := parseInsertCode("insert_code("+codeFilePath+":1-4)", "")
+	ci, err := parseInsertCode("insert_code("+codeFilePath+":1-4)", env)
 
 	renderedCode := ci.renderCodeBlock()
 	t.Log(ci.renderCodeBlock())
@@ -208,9 +219,8 @@ T shaveTheYak(T t) {
 }
 
 func TestRenderHighlights(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -221,10 +231,7 @@ int main(/* int argc, char *argv[] */) {
 `)
 	dsl_string := "insert_code(" + codeFilePath + ":1-4){4,1-2}"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -239,9 +246,8 @@ int main(/* int argc, char *argv[] */) {
 }
 
 func TestRenderHighlightsRelative(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -251,11 +257,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 	dsl_string := "insert_code(" + codeFilePath + ":1-4)r{2-3}"
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	baseCodeRange := LineRange{1, 4}
-	parseHighlights(dsl_string, &ci.highlights, &baseCodeRange)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -270,9 +272,8 @@ int main(/* int argc, char *argv[] */) {
 }
 
 func TestRenderHighlightsRelativeCharRange(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -282,11 +283,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 	dsl_string := "insert_code(" + codeFilePath + ":1-4)r{1,2:{3-13|17-17},3}"
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	baseCodeRange := LineRange{1, 4}
-	parseHighlights(dsl_string, &ci.highlights, &baseCodeRange)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -302,9 +299,8 @@ int main(/* int argc, char *argv[] */) {
 }
 
 func TestRenderDotReplacementsLines(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -315,10 +311,7 @@ int main(int argc, char *argv[]) {
 `)
 	dsl_string := "insert_code(" + codeFilePath + ":1-8)<d2-3,d7,d6:{9-31}>"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -336,9 +329,8 @@ int main(/* ... */) {
 }
 
 func TestRenderHideLines(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -349,10 +341,7 @@ int main(int argc, char *argv[]) {
 `)
 	dsl_string := "insert_code(" + codeFilePath + ":1-8)<h2-3,h7,h6:{9-31}>"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -371,9 +360,8 @@ int main(/**/) {
 }
 
 func TestRenderWithPosIndent(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -381,14 +369,7 @@ T shaveTheYak(T t) {
 
 	dsl_string := "insert_code(" + codeFilePath + ":1-4)[indent=2]"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	optionsStr := ""
-	optionsStr, dsl_string = consumeOptionsString(dsl_string)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -405,9 +386,8 @@ T shaveTheYak(T t) {
 }
 
 func TestRenderWithNegIndent(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   return t;
 }
@@ -415,14 +395,7 @@ T shaveTheYak(T t) {
 
 	dsl_string := "insert_code(" + codeFilePath + ":1-4)[indent=-2]"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	optionsStr := ""
-	optionsStr, dsl_string = consumeOptionsString(dsl_string)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -439,9 +412,8 @@ return t;
 }
 
 func TestRenderWithCommentThatShouldBeRemoved(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `template <typename T>
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `template <typename T>
 T shaveTheYak(T t) {
   // this is a comment
   return t;
@@ -450,14 +422,7 @@ T shaveTheYak(T t) {
 
 	dsl_string := "insert_code(" + codeFilePath + ":1-5)[comments=false]"
 
-	ci, err := parseInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	optionsStr := ""
-	optionsStr, dsl_string = consumeOptionsString(dsl_string)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -477,9 +442,8 @@ T shaveTheYak(T t) {
 // rev_insert_code
 
 func TestParseRevInsertCode(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `// code_block(FooID:1-4)
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(FooID:1-4)
 template <typename T>
 T shaveTheYak(T t) {
   return t;
@@ -490,7 +454,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 
-	ci, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", "")
+	ci, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -505,9 +469,8 @@ T shaveTheYak(T t) {
 }
 
 func TestParseRevInsertCodeTwoIDs(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `// code_block(BarID:1-2)
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(BarID:1-2)
 void barFunc {
 }
 
@@ -522,7 +485,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 
-	ci, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", "")
+	ci, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -536,10 +499,87 @@ T shaveTheYak(T t) {
 	}
 }
 
+const pairMarkerTestCode = `// code_block_begin(Outer)
+int main() {
+  // code_block_begin(Inner)
+  int x = 1;
+  // code_block_begin(Overlap)
+  x++;
+  // code_block_end(Inner)
+  return x;
+  // code_block_end(Overlap)
+}
+// code_block_end(Outer)
+// code_block_begin(Unclosed)
+`
+
+func TestParseRevInsertCodeWithPairMarkers(t *testing.T) {
+	env := makeTestEnv("foo.cpp", pairMarkerTestCode)
+
+	expected := map[string]string{
+		"Outer":   "int main() {\n  int x = 1;\n  x++;\n  return x;\n}\n",
+		"Inner":   "  int x = 1;\n  x++;\n",
+		"Overlap": "  x++;\n  return x;\n",
+	}
+	for id, code := range expected {
+		ci, err := parseRevInsertCode("rev_insert_code(foo.cpp:"+id+")", env)
+		if renderedCode := ci.renderCodeBlock(); err != nil || renderedCode != code {
+			t.Log("err: ", err, " renderedCode:\n", renderedCode)
+			t.Errorf("Code of block %s was wrongly generated.", id)
+		}
+	}
+
+	// Relative lines only count the rendered lines, without the markers.
+	ci, _ := parseRevInsertCode("rev_insert_code(foo.cpp:Outer)r{2,3}", env)
+	if renderedCode := ci.renderCodeBlock(); renderedCode != "int main() {\n* int x = 1;\n* x++;\n  return x;\n}\n" {
+		t.Log("renderedCode:\n", renderedCode)
+		t.Error("Relative highlight was not mapped onto the rendered lines.")
+	}
+
+	_, diags := TransformLine("rev_insert_code(foo.cpp:Outer){3}", env)
+	if len(diags) != 1 || diags.HasErrors() || !strings.Contains(diags[0].Error(), "code_block marker") {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Highlight on a marker line was not reported as warning.")
+	}
+
+	if _, err := parseRevInsertCode("rev_insert_code(foo.cpp:Unclosed)", env); err == nil || !strings.Contains(err.Error(), "no matching code_block_end") {
+		t.Log("err: ", err)
+		t.Error("Unclosed block was not reported.")
+	}
+}
+
+func TestVisualsUseCommentSyntaxOfLanguage(t *testing.T) {
+	env := makeTestEnv("foo.py", "def foo(a, b):\n    x = bar(a, b)\n    return x\n")
+	ci, err := parseInsertCode("insert_code(foo.py:1-3)<d2,d1:{8-12}>", env)
+	if renderedCode := ci.renderCodeBlock(); err != nil || renderedCode != "def foo( ... ):\n    # ...\n    return x\n" {
+		t.Log("err: ", err, " renderedCode:\n", renderedCode)
+		t.Error("Python code was not elided with Python comments.")
+	}
+	ci, _ = parseInsertCode("insert_code(foo.py:2)<d2:{8-17}>", env)
+	if renderedCode := ci.renderCodeBlock(); renderedCode != "    x = # ...\n" {
+		t.Log("renderedCode:\n", renderedCode)
+		t.Error("Elided end of a line was not turned into a line comment.")
+	}
+
+	env = makeTestEnv("page.html", "<ul>\n  <li>a</li>\n</ul>\n")
+	ci, _ = parseInsertCode("insert_code(page.html:1-3)<d2>", env)
+	if renderedCode := ci.renderCodeBlock(); renderedCode != "<ul>\n  <!-- ... -->\n</ul>\n" {
+		t.Log("renderedCode:\n", renderedCode)
+		t.Error("HTML code was not elided with a block comment.")
+	}
+
+	env = makeTestEnv("foo.tpl", "{{ a }}\n{{ b }}\n")
+	env.Comments = CommentRegistry{"tpl": {BlockStart: "{#", BlockEnd: "#}"}}
+	ci, _ = parseInsertCode("insert_code(foo.tpl:1-2)<d2>", env)
+	if renderedCode := ci.renderCodeBlock(); renderedCode != "{{ a }}\n{# ... #}\n" {
+		t.Log("renderedCode:\n", renderedCode)
+		t.Error("Registered comment syntax was not used.")
+	}
+}
+
 func TestMissingParseRevInsertCode(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/foo.cpp"
-	filet.File(t, codeFilePath, `// no code block here
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `// no code block here
 template <typename T>
 T shaveTheYak(T t) {
   return t;
@@ -550,7 +590,7 @@ int main(/* int argc, char *argv[] */) {
 }
 `)
 
-	_, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", "")
+	_, err := parseRevInsertCode("rev_insert_code("+codeFilePath+":FooID)", env)
 
 	if err == nil {
 		t.Error("Code was wrongly generated for `insert_code`.")
@@ -558,9 +598,8 @@ int main(/* int argc, char *argv[] */) {
 }
 
 func TestRenderDotReplacementsLinesRevInsert(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/bazz.cpp"
-	filet.File(t, codeFilePath, `// code_block(BazzID:1-8)
+	codeFilePath := "bazz.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(BazzID:1-8)
 template <typename T>
 T shaveTheYak(T t) {
   return t;
@@ -572,7 +611,7 @@ int main(int argc, char *argv[]) {
 `)
 	dsl_string := "rev_insert_code(" + codeFilePath + ":BazzID)r<d2-3,d7,d6:{9-31}>"
 
-	ci, err := parseRevInsertCode(dsl_string, "")
+	ci, err := parseRevInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -590,9 +629,8 @@ int main(/* ... */) {
 }
 
 func TestRenderRemoveLinesRevInsert(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/bazz.cpp"
-	filet.File(t, codeFilePath, `// code_block(BazzID:1-8)
+	codeFilePath := "bazz.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(BazzID:1-8)
 template <typename T>
 T shaveTheYak(T t) {
   return t;
@@ -604,7 +642,7 @@ int main(int argc, char *argv[]) {
 `)
 	dsl_string := "rev_insert_code(" + codeFilePath + ":BazzID)r<r2-3,r7,d6:{9-31}>"
 
-	ci, err := parseRevInsertCode(dsl_string, "")
+	ci, err := parseRevInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -620,9 +658,8 @@ int main(/* ... */) {
 }
 
 func TestRenderDotReplacementsLinesAndHighlightRevInsert(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/bazz.cpp"
-	filet.File(t, codeFilePath, `// code_block(BazzID:1-9)
+	codeFilePath := "bazz.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(BazzID:1-9)
 template <typename T>
 T shaveTheYak(T t) {
 	t + 1;
@@ -635,7 +672,7 @@ int main(int argc, char *argv[]) {
 `)
 	dsl_string := "rev_insert_code(" + codeFilePath + ":BazzID)r<r3-4,r8,d7:{9-31}>r{1-2,7}"
 
-	ci, err := parseRevInsertCode(dsl_string, "")
+	ci, err := parseRevInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -652,9 +689,8 @@ int main(int argc, char *argv[]) {
 }
 
 func TestRevInsertRenderWithCommentThatShouldBeRemoved(t *testing.T) {
-	defer filet.CleanUp(t)
-	codeFilePath := filet.TmpDir(t, "") + "/bazz.cpp"
-	filet.File(t, codeFilePath, `// code_block(BazzID:1-9)
+	codeFilePath := "bazz.cpp"
+	env := makeTestEnv(codeFilePath, `// code_block(BazzID:1-9)
 template <typename T>
 T shaveTheYak(T t) {
   // comment
@@ -663,14 +699,7 @@ T shaveTheYak(T t) {
 `)
 	dsl_string := "rev_insert_code(" + codeFilePath + ":BazzID)[comments=false]"
 
-	ci, err := parseRevInsertCode(dsl_string, "")
-	ci.highlights.Init()
-
-	optionsStr := ""
-	optionsStr, dsl_string = consumeOptionsString(dsl_string)
-	ci.options = ParseCodeGenOptions(optionsStr)
-
-	parseHighlights(dsl_string, &ci.highlights, nil /*should not be needed*/)
+	ci, err := parseRevInsertCode(dsl_string, env)
 
 	renderedCode := ci.renderCodeBlock()
 
@@ -698,3 +727,94 @@ func TestGetProgrammingLanguage(t *testing.T) {
 		t.Error("Wrong language detected from filename")
 	}
 }
+
+//===----------------------------------------------------------------------===//
+// Diagnostics
+
+func TestMissingSourceFileIsReported(t *testing.T) {
+	line := "insert_code(does/not/exist.cpp:1-4)"
+
+	transformedLine, diags := TransformLine(line, makeTestEnv("foo.cpp", ""))
+
+	if transformedLine != line {
+		t.Error("Line with an error was modified.")
+	}
+	if len(diags) != 1 || !diags.HasErrors() {
+		t.Fatal("Missing source file was not reported.")
+	}
+	if diags[0].Column != 13 || diags[0].Fragment != "does/not/exist.cpp" {
+		t.Log("Diagnostic: ", diags[0])
+		t.Error("Diagnostic points to the wrong part of the command.")
+	}
+}
+
+func TestTooLongVisualCharRangeIsReported(t *testing.T) {
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `int main() {
+  return 0;
+}
+`)
+	line := "insert_code(" + codeFilePath + ":1-3)<d1:{4-40}>"
+
+	transformedLine, diags := TransformLine(line, env)
+
+	if transformedLine != line {
+		t.Error("Line with an error was modified.")
+	}
+	if !diags.HasErrors() || diags[0].Fragment != "4-40" {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Too long char range was not reported.")
+	}
+}
+
+func TestHighlightOutsideOfRangeIsWarning(t *testing.T) {
+	codeFilePath := "foo.cpp"
+	env := makeTestEnv(codeFilePath, `int main() {
+  return 0;
+}
+`)
+	line := "insert_code(" + codeFilePath + ":1-3){7}"
+
+	transformedLine, diags := TransformLine(line, env)
+
+	if transformedLine == line {
+		t.Error("Line with only warnings was not transformed.")
+	}
+	if len(diags) != 1 || diags.HasErrors() {
+		t.Log("Diagnostics: ", diags)
+		t.Error("Highlight outside of the range was not reported as warning.")
+	}
+}
+
+func TestDiagnosticsInDocument(t *testing.T) {
+	_, diags := TransformLine("insert_code(foo.cpp:1-4){2,}", &Env{})
+	diags.InDocument("index_raw.html", 12)
+
+	expected := "index_raw.html:12:28: error: expected number but found \"}\" at \"}\""
+	if len(diags) != 1 || diags[0].Error() != expected {
+		t.Log("Diagnostics: ", diags, " but expected ", expected)
+		t.Error("Diagnostic was wrongly formatted.")
+	}
+}
+
+func TestAmbiguousSourceFileIsWarning(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/foo.cpp": &fstest.MapFile{Data: []byte("int a;\n")},
+		"b/foo.cpp": &fstest.MapFile{Data: []byte("int b;\n")},
+	}
+	a, _ := fs.Sub(fsys, "a")
+	b, _ := fs.Sub(fsys, "b")
+	env := &Env{Sources: NewMultiRootSourceResolver([]SourceRoot{{Dir: "a", FS: a}, {Dir: "b", FS: b}}, nil, nil)}
+
+	line, diags := TransformLine("insert_code(foo.cpp:1)", env)
+	if line != "```cpp\nint a;\n```" || len(diags) != 1 || diags.HasErrors() {
+		t.Log("line: ", line, " diagnostics: ", diags)
+		t.Error("Ambiguous source file was not reported as warning.")
+	}
+
+	dep, diags := GetFileDependency("insert_code(foo.cpp:1)", env)
+	if dep != filepath.Join("a", "foo.cpp") || len(diags) != 1 {
+		t.Log("dependency: ", dep, " diagnostics: ", diags)
+		t.Error("Dependency was not resolved to the first root.")
+	}
+}
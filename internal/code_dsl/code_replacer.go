@@ -1,7 +1,6 @@
 package code_dsl
 
 import (
-	"log"
 	"strings"
 )
 
@@ -10,22 +9,44 @@ import (
 //
 // line_num         = { digit };
 // range            = { digit }, "-", { digit };
-// char_range       = { digit }, "|", { digit };
-// char_range_list  = char_range | [ { "," , char_range } ];
-// ln_range_list    = range | line_num, [ { "," , range | line_num } ];
-// vis_select       = "r", "<", ["h" | "d" | "r"], ln_range_list , ">";
-// hl_select        = "r", "{" , ln_range_list , "}";
-// option           = "key=value"
-// options          = "[", option * ,"]"
+// char_range       = { digit }, "-", { digit };
+// char_range_list  = line_num, ":", ["{"], char_range, [ { "|" , char_range } ], ["}"];
+// ln_range_list    = range | line_num | char_range_list, [ { "," , range | line_num | char_range_list } ];
+// vis_select       = ["r"], "<", ["h" | "d" | "r"], ln_range_list , ">";
+// hl_select        = ["r"], "{" , ln_range_list , "}";
+// option           = key, "=", value | quoted_value;
+// options          = "[", [ option, { "," , option } ], "]";
+// filename         = path | quoted_path;
 //
+// A filename is searched in all code roots. If it starts with "@alias/", it
+// is resolved relative to the folder of the alias instead.
+//
+// The lexer and parser for this grammar live in dsl_lexer.go and
+// dsl_parser.go. Selections and options may follow the command in any order
+// but each of them at most once.
 //===----------------------------------------------------------------------===//
 // Commands:
 //  * "insert_code(filename:" , range | line_num , ")" , vis_select , hl_select, options
 //  * "rev_insert_code(filename:BlockID)" , vis_select , hl_select, options
+//  * "insert_symbol(filename:symbol)" , vis_select , hl_select, options
+//
+// rev_insert_code can leave out the filename, i.e., "rev_insert_code(:BlockID)"
+// or "rev_insert_code(BlockID)", to look the BlockID up in all code files.
+// insert_symbol quotes a declaration of a Go file by name, see go_symbol.go,
+// or a function or class of a C, C++, Java or Python file, see
+// symbol_extractor.go.
 //===----------------------------------------------------------------------===//
 // Options:
 //  * indent: +/- level of spaces that should be added/removed for indenting
 //  * comments: include comments (default: true)
+//  * doc: include the doc comment of an insert_symbol symbol (default: true)
+//  * bodies: "elide" replaces the bodies of Go functions with "{ /* ... */ }"
+//  * signature: "only" drops the bodies of Go functions entirely
+//  * fields: "exported" drops the unexported fields of Go structs
+//  * errcheck: "fold" replaces Go error checks with a comment line, "remove"
+//    drops them (default: keep)
+//  * errcheck_placeholder: text of the comment of folded error checks
+//  * format: "gofmt" formats Go code with gofmt (default: none)
 //===----------------------------------------------------------------------===//
 
 // Checks if the line contains an code DSL command.
@@ -36,20 +57,31 @@ func ContainsDSLCommand(line string) bool {
 	if isRevInsertCode(line) {
 		return true
 	}
+	if isInsertSymbol(line) {
+		return true
+	}
 	return false
 }
 
 // Transforms a line by replacing the DSL specific part with the generated
-// content.
-func TransformLine(line string, codeRoot string) string {
+// content. If the command could not be processed, the line is returned
+// unchanged together with the diagnostics that describe the problems.
+func TransformLine(line string, env *Env) (string, Diagnostics) {
 	if isInsertCode(line) {
-		return handleInsertCode(line, codeRoot)
+		return handleInsertCode(line, env)
 	}
 	if isRevInsertCode(line) {
-		return handleRevInsertCode(line, codeRoot)
+		return handleRevInsertCode(line, env)
 	}
-	log.Fatal("Transform was called without a transformable line.")
-	return line
+	if isInsertSymbol(line) {
+		return handleInsertSymbol(line, env)
+	}
+	return line, Diagnostics{&Diagnostic{
+		Severity: SeverityError,
+		Column:   1,
+		Fragment: line,
+		Msg:      "line does not contain a DSL command",
+	}}
 }
 
 func wrapWithCodeBlock(text string, lang string) string {
@@ -68,16 +100,15 @@ func isInsertCode(line string) bool {
 	return strings.HasPrefix(line, "insert_code")
 }
 
-func handleInsertCode(line string, codeRoot string) string {
-	ci, err := parseInsertCode(line, codeRoot)
+func handleInsertCode(line string, env *Env) (string, Diagnostics) {
+	ci, err := parseInsertCode(line, env)
 	if err != nil { // In the error case we return the unprocessed line to not destroy the doc.
-		print("Could not process insert_code line: ", line)
-		return line
+		return line, AsDiagnostics(err)
 	}
 
 	codeLanguageType := ci.progLang
 
-	return wrapWithCodeBlock(ci.renderCodeBlock(), codeLanguageType)
+	return wrapWithCodeBlock(ci.renderCodeBlock(), codeLanguageType), ci.warnings
 }
 
 //===----------------------------------------------------------------------===//
@@ -90,12 +121,30 @@ func isRevInsertCode(line string) bool {
 	return strings.HasPrefix(line, "rev_insert_code")
 }
 
-func handleRevInsertCode(line string, codeRoot string) string {
-	ci, err := parseRevInsertCode(line, codeRoot)
+func handleRevInsertCode(line string, env *Env) (string, Diagnostics) {
+	ci, err := parseRevInsertCode(line, env)
+	if err != nil { // In the error case we return the unprocessed line to not destroy the doc.
+		return line, AsDiagnostics(err)
+	}
+
+	return wrapWithCodeBlock(ci.renderCodeBlock(), ci.progLang), ci.warnings
+}
+
+//===----------------------------------------------------------------------===//
+// insert_symbol
+//
+// Examples usage:
+//   insert_symbol(server.go:Server.Handle)r<d3-5>r{1}[doc=false]
+
+func isInsertSymbol(line string) bool {
+	return strings.HasPrefix(line, "insert_symbol")
+}
+
+func handleInsertSymbol(line string, env *Env) (string, Diagnostics) {
+	ci, err := parseInsertSymbol(line, env)
 	if err != nil { // In the error case we return the unprocessed line to not destroy the doc.
-		print("Could not process rev_insert_code line: ", line)
-		return line
+		return line, AsDiagnostics(err)
 	}
 
-	return wrapWithCodeBlock(ci.renderCodeBlock(), ci.progLang)
+	return wrapWithCodeBlock(ci.renderCodeBlock(), ci.progLang), ci.warnings
 }
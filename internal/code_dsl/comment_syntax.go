@@ -0,0 +1,221 @@
+package code_dsl
+
+import "strings"
+
+//===----------------------------------------------------------------------===//
+// Comment syntax
+//
+// Markers like code_block are written into code files as comments, so we need
+// to know how to write a comment in the language of a file. Languages are
+// named like the languages of the generated code blocks, see Env.language.
+//
+// The same table is used to find the comments of a line when scanning for
+// markers, so a "code_block(" inside a string literal is not a marker.
+// Strings are recognized by their quotes, '"', '\'' or '`', which only start a
+// string if the line also contains the closing quote. This is a heuristic
+// that works for the common languages without knowing all their literals.
+//===----------------------------------------------------------------------===//
+
+// CommentSyntax describes how comments are written in a language. Languages
+// without line comments only set the block delimiters.
+type CommentSyntax struct {
+	Line       string
+	BlockStart string
+	BlockEnd   string
+}
+
+var (
+	slashComments = CommentSyntax{Line: "//", BlockStart: "/*", BlockEnd: "*/"}
+	hashComments  = CommentSyntax{Line: "#"}
+	dashComments  = CommentSyntax{Line: "--"}
+	sqlComments   = CommentSyntax{Line: "--", BlockStart: "/*", BlockEnd: "*/"}
+	hsComments    = CommentSyntax{Line: "--", BlockStart: "{-", BlockEnd: "-}"}
+	semiComments  = CommentSyntax{Line: ";"}
+)
+
+var commentSyntaxes = map[string]CommentSyntax{
+	"c": slashComments, "h": slashComments, "cc": slashComments, "cpp": slashComments,
+	"cxx": slashComments, "hpp": slashComments, "hh": slashComments, "hxx": slashComments,
+	"cs": slashComments, "go": slashComments, "java": slashComments, "kt": slashComments,
+	"scala": slashComments, "swift": slashComments, "rs": slashComments, "rust": slashComments,
+	"js": slashComments, "jsx": slashComments, "ts": slashComments, "tsx": slashComments,
+	"javascript": slashComments, "typescript": slashComments, "php": slashComments,
+	"dart": slashComments, "groovy": slashComments, "proto": slashComments,
+
+	"python": hashComments, "sh": hashComments, "bash": hashComments, "zsh": hashComments,
+	"rb": hashComments, "ruby": hashComments, "pl": hashComments, "perl": hashComments,
+	"r": hashComments, "yaml": hashComments, "yml": hashComments, "toml": hashComments,
+	"cmake": hashComments, "make": hashComments, "mk": hashComments, "jl": hashComments,
+	"julia": hashComments, "nim": hashComments, "ps1": hashComments, "powershell": hashComments,
+
+	"sql": sqlComments, "hs": hsComments, "haskell": hsComments,
+	"lua": dashComments, "elm": dashComments, "ada": dashComments,
+
+	"lisp": semiComments, "clj": semiComments, "clojure": semiComments, "scm": semiComments,
+	"scheme": semiComments, "el": semiComments, "asm": semiComments, "ini": semiComments,
+
+	"tex":     {Line: "%"},
+	"erl":     {Line: "%"},
+	"erlang":  {Line: "%"},
+	"f90":     {Line: "!"},
+	"fortran": {Line: "!"},
+	"vim":     {Line: "\""},
+
+	"css":  {BlockStart: "/*", BlockEnd: "*/"},
+	"html": {BlockStart: "<!--", BlockEnd: "-->"},
+	"xml":  {BlockStart: "<!--", BlockEnd: "-->"},
+	"md":   {BlockStart: "<!--", BlockEnd: "-->"},
+	"ml":   {BlockStart: "(*", BlockEnd: "*)"},
+}
+
+// Returns the built-in comment syntax of a language.
+func commentSyntaxFor(language string) (CommentSyntax, bool) {
+	cs, found := commentSyntaxes[language]
+	return cs, found
+}
+
+// CommentRegistry maps languages to their comment syntax. It extends the
+// built-in table and overrides it for the languages it lists.
+type CommentRegistry map[string]CommentSyntax
+
+// Returns the comment syntax of a language, preferring the registry over the
+// built-in table.
+func (r CommentRegistry) Lookup(language string) (CommentSyntax, bool) {
+	if cs, found := r[language]; found {
+		return cs, true
+	}
+	return commentSyntaxFor(language)
+}
+
+// Turns the text into a single line comment, using a block comment for
+// languages without line comments.
+func (cs CommentSyntax) Comment(text string) string {
+	if cs.Line != "" {
+		return cs.Line + " " + text
+	}
+	return cs.BlockStart + " " + text + " " + cs.BlockEnd
+}
+
+// Comment syntaxes that are tried for files of unknown languages.
+var fallbackCommentSyntaxes = []CommentSyntax{
+	slashComments, hashComments, dashComments, semiComments, commentSyntaxes["html"],
+}
+
+// Returns the comment syntaxes markers may be written in for a language,
+// preferring the registry over the built-in table. Unknown languages accept
+// all common comment syntaxes.
+func (r CommentRegistry) markerSyntaxes(language string) []CommentSyntax {
+	if cs, found := r.Lookup(language); found {
+		return []CommentSyntax{cs}
+	}
+	return fallbackCommentSyntaxes
+}
+
+// commentScanner finds the comments in the lines of a file. As block
+// comments can span several lines, lines have to be scanned in order.
+type commentScanner struct {
+	syntaxes []CommentSyntax
+	// Index of the syntax of the block comment that is still open at the
+	// end of the last line, -1 if none is open
+	openBlock int
+}
+
+func newCommentScanner(syntaxes []CommentSyntax) *commentScanner {
+	return &commentScanner{syntaxes: syntaxes, openBlock: -1}
+}
+
+// Returns the text of all comments in the next line, without the comment
+// delimiters.
+func (s *commentScanner) comments(line string) []string {
+	comments, _ := s.scan(line)
+	return comments
+}
+
+// Returns the next line with its comments and string literals replaced by
+// spaces, so the code can be searched without being misled by them.
+func (s *commentScanner) code(line string) string {
+	_, code := s.scan(line)
+	return code
+}
+
+// Scans the next line for comments and string literals. Returns the text of
+// the comments and the line with comments and strings blanked out.
+func (s *commentScanner) scan(line string) ([]string, string) {
+	comments := []string{}
+	code := []byte(line)
+	blank := func(from int, to int) {
+		for idx := from; idx < to; idx++ {
+			code[idx] = ' '
+		}
+	}
+
+	idx := 0
+	for idx < len(line) {
+		if s.openBlock >= 0 {
+			blockEnd := s.syntaxes[s.openBlock].BlockEnd
+			end := strings.Index(line[idx:], blockEnd)
+			if end < 0 {
+				blank(idx, len(line))
+				return append(comments, line[idx:]), string(code)
+			}
+			comments = append(comments, line[idx:idx+end])
+			blank(idx, idx+end+len(blockEnd))
+			idx += end + len(blockEnd)
+			s.openBlock = -1
+			continue
+		}
+
+		if end := stringLiteralEnd(line, idx); end > idx {
+			blank(idx, end)
+			idx = end
+			continue
+		}
+		started := false
+		for syntaxIdx, cs := range s.syntaxes {
+			if cs.Line != "" && strings.HasPrefix(line[idx:], cs.Line) {
+				blank(idx, len(line))
+				return append(comments, line[idx+len(cs.Line):]), string(code)
+			}
+			if cs.BlockStart != "" && strings.HasPrefix(line[idx:], cs.BlockStart) {
+				s.openBlock = syntaxIdx
+				blank(idx, idx+len(cs.BlockStart))
+				idx += len(cs.BlockStart)
+				started = true
+				break
+			}
+		}
+		if !started {
+			idx++
+		}
+	}
+	return comments, string(code)
+}
+
+// Returns the lines with their comments and string literals replaced by
+// spaces.
+func codeOnly(lines []string, syntax CommentSyntax) []string {
+	scanner := newCommentScanner([]CommentSyntax{syntax})
+	code := make([]string, len(lines))
+	for idx, line := range lines {
+		code[idx] = scanner.code(line)
+	}
+	return code
+}
+
+// Returns the index after the string literal that starts at idx, or idx if
+// there is none. Backslashes escape the next character.
+func stringLiteralEnd(line string, idx int) int {
+	quote := line[idx]
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return idx
+	}
+	for end := idx + 1; end < len(line); end++ {
+		switch line[end] {
+		case '\\':
+			end++
+		case quote:
+			return end + 1
+		}
+	}
+	return idx
+}
@@ -0,0 +1,180 @@
+package code_dsl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Diagnostics
+//
+// Problems found while parsing or rendering DSL commands are reported as
+// diagnostics instead of aborting the run. A diagnostic knows where in the
+// document the problem is, so all problems of a document can be collected and
+// reported at once.
+//===----------------------------------------------------------------------===//
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic describes a single problem found while processing a document.
+type Diagnostic struct {
+	Severity Severity
+	// Path of the document, empty if not known
+	Document string
+	// 1-based line in the document, 0 if not known
+	Line int
+	// 1-based column in the line, 0 if not known
+	Column int
+	// The offending DSL fragment
+	Fragment string
+	Msg      string
+	// The underlying error, e.g., from opening a source file
+	Err error
+}
+
+func (d *Diagnostic) Error() string {
+	var sb strings.Builder
+	if d.Document != "" {
+		sb.WriteString(d.Document + ":")
+	}
+	if d.Line > 0 {
+		sb.WriteString(fmt.Sprintf("%d:", d.Line))
+		if d.Column > 0 {
+			sb.WriteString(fmt.Sprintf("%d:", d.Column))
+		}
+	}
+	if sb.Len() > 0 {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(d.Severity.String() + ": " + d.Msg)
+	if d.Fragment != "" {
+		sb.WriteString(fmt.Sprintf(" at %q", d.Fragment))
+	}
+	return sb.String()
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// Diagnostics is a list of diagnostics that can be used as an error.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := make([]string, 0, len(ds))
+	for _, d := range ds {
+		msgs = append(msgs, d.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Checks if at least one of the diagnostics is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the diagnostics that are errors.
+func (ds Diagnostics) Errors() Diagnostics {
+	errs := Diagnostics{}
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// Attaches the document path and line to all diagnostics that do not know
+// where they are located yet.
+func (ds Diagnostics) InDocument(document string, line int) Diagnostics {
+	for _, d := range ds {
+		if d.Document == "" {
+			d.Document = document
+		}
+		if d.Line == 0 {
+			d.Line = line
+		}
+	}
+	return ds
+}
+
+// Creates a diagnostic for the part of a DSL text between from and to.
+func newDiagnosticAt(severity Severity, text string, from Pos, to Pos, format string, args ...interface{}) *Diagnostic {
+	cmd := Command{Text: text}
+	return &Diagnostic{
+		Severity: severity,
+		Column:   from.Column(),
+		Fragment: cmd.Fragment(from, to),
+		Msg:      fmt.Sprintf(format, args...),
+	}
+}
+
+// Creates an error diagnostic for the part of a command between from and to.
+func newCommandError(cmd *Command, from Pos, to Pos, format string, args ...interface{}) *Diagnostic {
+	return newDiagnosticAt(SeverityError, cmd.Text, from, to, format, args...)
+}
+
+// Creates a warning diagnostic for the part of a command between from and to.
+func newCommandWarning(cmd *Command, from Pos, to Pos, format string, args ...interface{}) *Diagnostic {
+	return newDiagnosticAt(SeverityWarning, cmd.Text, from, to, format, args...)
+}
+
+// Creates an error diagnostic for a document that could not be processed as
+// a whole, e.g., because it could not be opened.
+func NewDocumentError(document string, err error) *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityError,
+		Document: document,
+		Msg:      err.Error(),
+		Err:      err,
+	}
+}
+
+// Converts an arbitrary error into diagnostics.
+func AsDiagnostics(err error) Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var ds Diagnostics
+	if errors.As(err, &ds) {
+		return ds
+	}
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return Diagnostics{d}
+	}
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return Diagnostics{&Diagnostic{
+			Severity: SeverityError,
+			Column:   parseErr.Pos.Column(),
+			Fragment: parseErr.Fragment,
+			Msg:      parseErr.Msg,
+			Err:      parseErr,
+		}}
+	}
+	return Diagnostics{&Diagnostic{Severity: SeverityError, Msg: err.Error(), Err: err}}
+}
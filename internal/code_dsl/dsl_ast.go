@@ -0,0 +1,202 @@
+package code_dsl
+
+//===----------------------------------------------------------------------===//
+// AST
+//
+// The parser turns a DSL command line into a Command. All nodes carry the
+// byte offsets of their first character and of the character directly after
+// them in the command line, so errors can point to the offending part of a
+// command.
+//===----------------------------------------------------------------------===//
+
+// Pos is a 0-based byte offset into a DSL command line.
+type Pos int
+
+// Column returns the 1-based column of the position.
+func (p Pos) Column() int {
+	return int(p) + 1
+}
+
+type CommandKind int
+
+const (
+	InsertCodeCommand CommandKind = iota
+	RevInsertCodeCommand
+	InsertSymbolCommand
+)
+
+var commandKeywords = map[string]CommandKind{
+	"insert_code":     InsertCodeCommand,
+	"rev_insert_code": RevInsertCodeCommand,
+	"insert_symbol":   InsertSymbolCommand,
+}
+
+func (k CommandKind) String() string {
+	switch k {
+	case InsertCodeCommand:
+		return "insert_code"
+	case RevInsertCodeCommand:
+		return "rev_insert_code"
+	case InsertSymbolCommand:
+		return "insert_symbol"
+	default:
+		return "unknown"
+	}
+}
+
+// Command is the root of the AST of a single DSL command, e.g.,
+//
+//	insert_code(filename.cpp:4-17)<4-8,17>{5-6,8}[indent=2]
+type Command struct {
+	Kind CommandKind
+	Pos  Pos
+	End  Pos
+
+	// Empty for rev_insert_code commands that look up their BlockID in all
+	// code files
+	File FileRef
+	// Set for insert_code
+	Range *RangeSpec
+	// Set for rev_insert_code
+	Block *BlockRef
+	// Set for insert_symbol
+	Symbol *SymbolRef
+
+	Visuals    *VisualSelection
+	Highlights *HighlightSelection
+	Options    *OptionList
+
+	// Text of the whole command line
+	Text string
+}
+
+type FileRef struct {
+	Path string
+	Pos  Pos
+	End  Pos
+}
+
+// RangeSpec is the line range of the file that should be inserted. A single
+// line is represented by a range with the same start and end.
+type RangeSpec struct {
+	Start  int
+	End    int
+	Pos    Pos
+	EndPos Pos
+}
+
+type BlockRef struct {
+	ID  string
+	Pos Pos
+	End Pos
+}
+
+// SymbolRef names a declaration of a code file, e.g., "Server.Handle".
+type SymbolRef struct {
+	Name string
+	Pos  Pos
+	End  Pos
+}
+
+// Selector selects lines or parts of lines, either for highlights or for
+// visual modifications.
+type Selector interface {
+	Position() Pos
+	EndPosition() Pos
+	selectorNode()
+}
+
+// LineSelector selects a single line, e.g., "17".
+type LineSelector struct {
+	Line int
+	Pos  Pos
+	End  Pos
+}
+
+// LineRangeSelector selects a range of lines, e.g., "4-8".
+type LineRangeSelector struct {
+	Start  int
+	End    int
+	Pos    Pos
+	EndPos Pos
+}
+
+// CharRangeSelector selects character ranges in a line, e.g., "2:{3-13|17-17}".
+type CharRangeSelector struct {
+	Line   int
+	Ranges []CharSpan
+	Pos    Pos
+	End    Pos
+}
+
+type CharSpan struct {
+	Start  int
+	End    int
+	Pos    Pos
+	EndPos Pos
+}
+
+func (s *LineSelector) Position() Pos      { return s.Pos }
+func (s *LineRangeSelector) Position() Pos { return s.Pos }
+func (s *CharRangeSelector) Position() Pos { return s.Pos }
+
+func (s *LineSelector) EndPosition() Pos      { return s.End }
+func (s *LineRangeSelector) EndPosition() Pos { return s.EndPos }
+func (s *CharRangeSelector) EndPosition() Pos { return s.End }
+
+func (*LineSelector) selectorNode()      {}
+func (*LineRangeSelector) selectorNode() {}
+func (*CharRangeSelector) selectorNode() {}
+
+// VisualMode is the modification letter of a visual item, i.e., 'h', 'd' or
+// 'r'. It is zero when no mode was specified.
+type VisualMode byte
+
+type VisualItem struct {
+	Mode     VisualMode
+	Selector Selector
+	Pos      Pos
+	End      Pos
+}
+
+// VisualSelection is the "<...>" or "r<...>" part of a command.
+type VisualSelection struct {
+	Relative bool
+	Items    []VisualItem
+	Pos      Pos
+	End      Pos
+}
+
+// HighlightSelection is the "{...}" or "r{...}" part of a command.
+type HighlightSelection struct {
+	Relative bool
+	Items    []Selector
+	Pos      Pos
+	End      Pos
+}
+
+type Option struct {
+	Key   string
+	Value string
+	Pos   Pos
+	End   Pos
+}
+
+// OptionList is the "[key=value,...]" part of a command.
+type OptionList struct {
+	Items []Option
+	Pos   Pos
+	End   Pos
+}
+
+// Returns the text of the command between two positions, e.g., to show the
+// offending part of a command in an error message.
+func (cmd *Command) Fragment(from Pos, to Pos) string {
+	if from < 0 || int(from) > len(cmd.Text) || to < from {
+		return ""
+	}
+	if int(to) > len(cmd.Text) {
+		to = Pos(len(cmd.Text))
+	}
+	return cmd.Text[from:to]
+}
@@ -0,0 +1,399 @@
+package code_dsl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//===----------------------------------------------------------------------===//
+// Lexer
+//
+// The lexer splits a DSL command line into tokens. Which tokens are valid
+// depends on where we are in the command, e.g., a letter inside a visual
+// selection is a modification mode while it is part of a block ID inside the
+// command argument. The lexer therefore runs as a small state machine with
+// one state function per section of the command.
+//===----------------------------------------------------------------------===//
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokError
+	tokKeyword  // insert_code, rev_insert_code, insert_symbol
+	tokPath     // filename inside the command argument
+	tokIdent    // block IDs, symbol names and option keys
+	tokNumber   // line and char numbers
+	tokLetter   // single letter modifiers, e.g., 'r' or 'd'
+	tokValue    // raw option value
+	tokString   // quoted option value or filename
+	tokLParen   // (
+	tokRParen   // )
+	tokLBrace   // {
+	tokRBrace   // }
+	tokLAngle   // <
+	tokRAngle   // >
+	tokLBracket // [
+	tokRBracket // ]
+	tokColon    // :
+	tokComma    // ,
+	tokDash     // -
+	tokPipe     // |
+	tokEquals   // =
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of command",
+	tokError:    "error",
+	tokKeyword:  "command",
+	tokPath:     "filename",
+	tokIdent:    "identifier",
+	tokNumber:   "number",
+	tokLetter:   "letter",
+	tokValue:    "value",
+	tokString:   "string",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokLBrace:   "'{'",
+	tokRBrace:   "'}'",
+	tokLAngle:   "'<'",
+	tokRAngle:   "'>'",
+	tokLBracket: "'['",
+	tokRBracket: "']'",
+	tokColon:    "':'",
+	tokComma:    "','",
+	tokDash:     "'-'",
+	tokPipe:     "'|'",
+	tokEquals:   "'='",
+}
+
+func (k tokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+	// Position directly after the token in the input
+	end Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return t.kind.String()
+	case tokError:
+		return t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type stateFn func(*lexer) stateFn
+
+type lexer struct {
+	input  string
+	start  int
+	pos    int
+	tokens []token
+
+	// Set when lexing an option list that is not enclosed in brackets.
+	bareOptions bool
+}
+
+// Splits a DSL command line into its tokens. The token list always ends with
+// either a tokEOF or a tokError token.
+func lex(input string) []token {
+	l := &lexer{input: input}
+	for state := lexKeyword; state != nil; {
+		state = state(l)
+	}
+	return l.tokens
+}
+
+// Splits the content of an options block, i.e., the text between '[' and
+// ']', into its tokens.
+func lexOptionList(input string) []token {
+	l := &lexer{input: input, bareOptions: true}
+	for state := lexOptions; state != nil; {
+		state = state(l)
+	}
+	return l.tokens
+}
+
+func (l *lexer) emit(kind tokenKind) {
+	l.tokens = append(l.tokens, token{kind, l.input[l.start:l.pos], Pos(l.start), Pos(l.pos)})
+	l.start = l.pos
+}
+
+func (l *lexer) emitText(kind tokenKind, text string) {
+	l.tokens = append(l.tokens, token{kind, text, Pos(l.start), Pos(l.pos)})
+	l.start = l.pos
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) stateFn {
+	l.tokens = append(l.tokens, token{tokError, fmt.Sprintf(format, args...), Pos(l.pos), Pos(len(l.input))})
+	return nil
+}
+
+func (l *lexer) peek() byte {
+	if l.pos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos]
+}
+
+func (l *lexer) atEnd() bool {
+	return l.pos >= len(l.input)
+}
+
+func (l *lexer) skipSpace() {
+	for !l.atEnd() && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	l.start = l.pos
+}
+
+func (l *lexer) acceptRun(valid func(byte) bool) {
+	for !l.atEnd() && valid(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isIdentChar(c byte) bool {
+	return isLetter(c) || isDigit(c)
+}
+
+func isOptionKeyChar(c byte) bool {
+	return isIdentChar(c) || c == '-'
+}
+
+// Lexes the command keyword and the opening parenthesis.
+func lexKeyword(l *lexer) stateFn {
+	l.skipSpace()
+	l.acceptRun(isIdentChar)
+	if l.pos == l.start {
+		return l.errorf("expected a DSL command")
+	}
+	l.emit(tokKeyword)
+	if l.peek() != '(' {
+		return l.errorf("expected '(' after %q", l.tokens[len(l.tokens)-1].text)
+	}
+	l.pos++
+	l.emit(tokLParen)
+	return lexArgument
+}
+
+// Block IDs are identifiers that may contain dots, e.g., to reference a
+// namespaced block.
+var blockIDRgx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+var fileRangeRgx = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+
+func isValidSelector(text string) bool {
+	return fileRangeRgx.MatchString(text) || blockIDRgx.MatchString(text)
+}
+
+// Lexes the command argument, i.e., "filename:selector)", where the filename
+// can be left out for BlockIDs. Filenames may contain ':' and ')' themselves,
+// so we search for the first ')' that terminates a well formed
+// "filename:selector" pair. Filenames can also be quoted to remove any
+// ambiguity.
+func lexArgument(l *lexer) stateFn {
+	if l.peek() == '"' {
+		if !l.lexQuoted(tokPath) {
+			return l.errorf("unterminated quoted filename")
+		}
+		if l.peek() != ':' {
+			return l.errorf("expected ':' after filename")
+		}
+		l.pos++
+		l.emit(tokColon)
+		closing := strings.IndexByte(l.input[l.pos:], ')')
+		if closing < 0 || !isValidSelector(l.input[l.pos:l.pos+closing]) {
+			return l.errorf("expected a range or BlockID followed by ')'")
+		}
+		return l.lexSelector(l.pos + closing)
+	}
+
+	rest := l.input[l.pos:]
+	for closing := 0; closing < len(rest); closing++ {
+		if rest[closing] != ')' {
+			continue
+		}
+		arg := rest[:closing]
+		colon := strings.LastIndexByte(arg, ':')
+		if colon < 0 || !isValidSelector(arg[colon+1:]) {
+			continue
+		}
+		if colon == 0 {
+			// "(:BlockID)" looks the BlockID up in all code files.
+			l.pos++
+			l.emit(tokColon)
+			return l.lexSelector(l.start + len(arg) - 1)
+		}
+
+		l.pos += colon
+		l.emit(tokPath)
+		l.pos++
+		l.emit(tokColon)
+		return l.lexSelector(l.start + len(arg) - colon - 1)
+	}
+
+	// "(BlockID)" is the short form of "(:BlockID)".
+	if closing := strings.IndexByte(rest, ')'); closing >= 0 && blockIDRgx.MatchString(rest[:closing]) {
+		return l.lexSelector(l.pos + closing)
+	}
+	return l.errorf("expected \"filename:range\" or \"filename:BlockID\" followed by ')'")
+}
+
+// Lexes the already validated selector part of the command argument, ending
+// at the closing parenthesis at position end.
+func (l *lexer) lexSelector(end int) stateFn {
+	if blockIDRgx.MatchString(l.input[l.pos:end]) {
+		l.pos = end
+		l.emit(tokIdent)
+	} else {
+		for l.pos < end {
+			if l.peek() == '-' {
+				l.pos++
+				l.emit(tokDash)
+				continue
+			}
+			l.acceptRun(isDigit)
+			l.emit(tokNumber)
+		}
+	}
+	l.pos++
+	l.emit(tokRParen)
+	return lexSelections
+}
+
+// Lexes a quoted string, supporting '\"' and '\\' escapes, and emits it
+// as a token of the given kind without the quotes.
+func (l *lexer) lexQuoted(kind tokenKind) bool {
+	var sb strings.Builder
+	l.pos++ // opening quote
+	for !l.atEnd() {
+		c := l.input[l.pos]
+		switch c {
+		case '\\':
+			if l.pos+1 < len(l.input) {
+				l.pos++
+				c = l.input[l.pos]
+			}
+		case '"':
+			l.pos++
+			l.emitText(kind, sb.String())
+			return true
+		}
+		sb.WriteByte(c)
+		l.pos++
+	}
+	return false
+}
+
+// Lexes the visual and highlight selections as well as the opening bracket
+// of the options block.
+func lexSelections(l *lexer) stateFn {
+	l.skipSpace()
+	if l.atEnd() {
+		l.emit(tokEOF)
+		return nil
+	}
+
+	c := l.peek()
+	switch {
+	case isDigit(c):
+		l.acceptRun(isDigit)
+		l.emit(tokNumber)
+	case isLetter(c):
+		l.pos++
+		l.emit(tokLetter)
+	case c == '[':
+		l.pos++
+		l.emit(tokLBracket)
+		return lexOptions
+	default:
+		kind, ok := punctuation[c]
+		if !ok {
+			return l.errorf("unexpected character %q", c)
+		}
+		l.pos++
+		l.emit(kind)
+	}
+	return lexSelections
+}
+
+var punctuation = map[byte]tokenKind{
+	'{': tokLBrace,
+	'}': tokRBrace,
+	'<': tokLAngle,
+	'>': tokRAngle,
+	']': tokRBracket,
+	':': tokColon,
+	',': tokComma,
+	'-': tokDash,
+	'|': tokPipe,
+}
+
+// Lexes the content of an options block and the closing bracket. For a
+// standalone option list, the end of the input terminates the options.
+func lexOptions(l *lexer) stateFn {
+	l.skipSpace()
+	if l.atEnd() {
+		if l.bareOptions {
+			l.emit(tokEOF)
+			return nil
+		}
+		return l.errorf("expected ']' to close the options")
+	}
+
+	switch c := l.peek(); {
+	case c == ']' && !l.bareOptions:
+		l.pos++
+		l.emit(tokRBracket)
+		return lexSelections
+	case c == ',':
+		l.pos++
+		l.emit(tokComma)
+	case c == '=':
+		l.pos++
+		l.emit(tokEquals)
+		return lexOptionValue
+	case isOptionKeyChar(c):
+		l.acceptRun(isOptionKeyChar)
+		l.emit(tokIdent)
+	default:
+		return l.errorf("unexpected character %q in options", c)
+	}
+	return lexOptions
+}
+
+// Lexes an option value, which is either quoted or runs until the next ','
+// or ']'.
+func lexOptionValue(l *lexer) stateFn {
+	l.skipSpace()
+	if l.peek() == '"' {
+		if !l.lexQuoted(tokString) {
+			return l.errorf("unterminated quoted option value")
+		}
+		return lexOptions
+	}
+
+	l.acceptRun(func(c byte) bool { return c != ',' && (c != ']' || l.bareOptions) })
+	l.emitText(tokValue, strings.TrimSpace(l.input[l.start:l.pos]))
+	return lexOptions
+}
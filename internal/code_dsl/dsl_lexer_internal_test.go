@@ -0,0 +1,88 @@
+package code_dsl
+
+import (
+	"testing"
+)
+
+func tokenKinds(tokens []token) []tokenKind {
+	kinds := []tokenKind{}
+	for _, tok := range tokens {
+		kinds = append(kinds, tok.kind)
+	}
+	return kinds
+}
+
+func checkTokenKinds(t *testing.T, input string, expected []tokenKind) []token {
+	tokens := lex(input)
+	kinds := tokenKinds(tokens)
+	if len(kinds) != len(expected) {
+		t.Log("tokens:", tokens, " but expected kinds ", expected)
+		t.Fatal("Wrong number of tokens for", input)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Log("token", i, "was", tokens[i], "of kind", kinds[i], " but expected ", expected[i])
+			t.Error("Wrong token kind for", input)
+		}
+	}
+	return tokens
+}
+
+func TestLexInsertCode(t *testing.T) {
+	checkTokenKinds(t, "insert_code(foo.cpp:4-17)", []tokenKind{
+		tokKeyword, tokLParen, tokPath, tokColon, tokNumber, tokDash, tokNumber, tokRParen, tokEOF,
+	})
+}
+
+func TestLexRevInsertCodeWithSelections(t *testing.T) {
+	checkTokenKinds(t, "rev_insert_code(foo.cpp:FooID)r<d2-3>{1}[indent=2]", []tokenKind{
+		tokKeyword, tokLParen, tokPath, tokColon, tokIdent, tokRParen,
+		tokLetter, tokLAngle, tokLetter, tokNumber, tokDash, tokNumber, tokRAngle,
+		tokLBrace, tokNumber, tokRBrace,
+		tokLBracket, tokIdent, tokEquals, tokValue, tokRBracket, tokEOF,
+	})
+}
+
+func TestLexFilenameWithColonAndParen(t *testing.T) {
+	tokens := checkTokenKinds(t, "insert_code(C:foo(1).cpp:4-17)", []tokenKind{
+		tokKeyword, tokLParen, tokPath, tokColon, tokNumber, tokDash, tokNumber, tokRParen, tokEOF,
+	})
+
+	if tokens[2].text != "C:foo(1).cpp" {
+		t.Log("filename: ", tokens[2].text, " but expected ", "C:foo(1).cpp")
+		t.Error("Filename was wrongly lexed.")
+	}
+}
+
+func TestLexQuotedFilename(t *testing.T) {
+	tokens := checkTokenKinds(t, `insert_code("foo:1-2).cpp":4-17)`, []tokenKind{
+		tokKeyword, tokLParen, tokPath, tokColon, tokNumber, tokDash, tokNumber, tokRParen, tokEOF,
+	})
+
+	if tokens[2].text != "foo:1-2).cpp" {
+		t.Log("filename: ", tokens[2].text, " but expected ", "foo:1-2).cpp")
+		t.Error("Quoted filename was wrongly lexed.")
+	}
+}
+
+func TestLexOptionValueWithAngle(t *testing.T) {
+	tokens := checkTokenKinds(t, "insert_code(foo.cpp:4)[key=a>b, other=\"x,y]\"]", []tokenKind{
+		tokKeyword, tokLParen, tokPath, tokColon, tokNumber, tokRParen,
+		tokLBracket, tokIdent, tokEquals, tokValue, tokComma, tokIdent, tokEquals, tokString, tokRBracket, tokEOF,
+	})
+
+	if tokens[9].text != "a>b" || tokens[13].text != "x,y]" {
+		t.Log("values: ", tokens[9].text, tokens[13].text)
+		t.Error("Option values were wrongly lexed.")
+	}
+}
+
+func TestLexMissingClosingParen(t *testing.T) {
+	tokens := lex("insert_code(foo.cpp:4-17")
+	last := tokens[len(tokens)-1]
+
+	if last.kind != tokError {
+		t.Log("tokens: ", tokens)
+		t.Error("Missing ')' was not reported.")
+	}
+}
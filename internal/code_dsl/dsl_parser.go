@@ -0,0 +1,395 @@
+package code_dsl
+
+import (
+	"fmt"
+	"strconv"
+)
+
+//===----------------------------------------------------------------------===//
+// Parser
+//
+// Recursive-descent parser for the DSL grammar described in code_replacer.go.
+//===----------------------------------------------------------------------===//
+
+// ParseError describes a syntax error inside a DSL command.
+type ParseError struct {
+	Pos      Pos
+	Fragment string
+	Msg      string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Pos.Column(), e.Msg)
+}
+
+type parser struct {
+	line   string
+	tokens []token
+	pos    int
+	// End of the last consumed token
+	lastEnd Pos
+}
+
+// Parses a DSL command line into its AST.
+func ParseCommand(line string) (*Command, error) {
+	p := &parser{line: line, tokens: lex(line)}
+	return p.parseCommand()
+}
+
+// Parses a standalone option list, e.g., "indent=2,comments=false".
+func ParseOptionList(options string) (*OptionList, error) {
+	p := &parser{line: options, tokens: lexOptionList(options)}
+	ol, err := p.parseOptionItems(tokEOF)
+	if err != nil {
+		return nil, err
+	}
+	return ol, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// Returns the token after the next one.
+func (p *parser) peekSecond() token {
+	if p.pos+1 < len(p.tokens) {
+		return p.tokens[p.pos+1]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF && tok.kind != tokError {
+		p.pos++
+		p.lastEnd = tok.end
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	if tok.kind == tokError {
+		return &ParseError{tok.pos, p.fragment(tok), tok.text}
+	}
+	return &ParseError{tok.pos, p.fragment(tok), fmt.Sprintf(format, args...)}
+}
+
+// Returns the offending part of the line for a token, i.e., the token text or
+// for errors the rest of the line.
+func (p *parser) fragment(tok token) string {
+	if tok.kind == tokError || tok.kind == tokEOF {
+		if int(tok.pos) < len(p.line) {
+			return p.line[tok.pos:]
+		}
+		return ""
+	}
+	return tok.text
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s but found %s", kind, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) expectNumber() (int, token, error) {
+	tok, err := p.expect(tokNumber)
+	if err != nil {
+		return 0, tok, err
+	}
+	num, err := strconv.Atoi(tok.text)
+	if err != nil {
+		return 0, tok, p.errorf(tok, "invalid number %s", tok)
+	}
+	return num, tok, nil
+}
+
+// command = keyword "(" ( filename ":" ( range | line_num | BlockID | symbol ) | [ ":" ] BlockID ) ")" { selection }
+func (p *parser) parseCommand() (*Command, error) {
+	kwTok, err := p.expect(tokKeyword)
+	if err != nil {
+		return nil, err
+	}
+	kind, ok := commandKeywords[kwTok.text]
+	if !ok {
+		return nil, p.errorf(kwTok, "unknown command %s", kwTok)
+	}
+	cmd := &Command{Kind: kind, Pos: kwTok.pos, Text: p.line}
+
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	argTok := p.peek()
+	switch argTok.kind {
+	case tokPath:
+		p.next()
+		cmd.File = FileRef{argTok.text, argTok.pos, argTok.end}
+		if _, err := p.expect(tokColon); err != nil {
+			return nil, err
+		}
+	case tokColon:
+		p.next()
+		cmd.File = FileRef{"", argTok.pos, argTok.pos}
+	case tokIdent:
+		cmd.File = FileRef{"", argTok.pos, argTok.pos}
+	default:
+		if _, err := p.expect(tokPath); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.File.Path == "" && kind != RevInsertCodeCommand {
+		return nil, p.errorf(argTok, "%s needs a filename, only rev_insert_code can leave it out", kwTok.text)
+	}
+
+	switch kind {
+	case InsertCodeCommand:
+		if cmd.Range, err = p.parseFileRange(); err != nil {
+			return nil, err
+		}
+	case RevInsertCodeCommand:
+		idTok, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Block = &BlockRef{idTok.text, idTok.pos, idTok.end}
+	case InsertSymbolCommand:
+		nameTok, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, p.errorf(nameTok, "expected a symbol name, e.g., Server.Handle, but found %s", nameTok)
+		}
+		cmd.Symbol = &SymbolRef{nameTok.text, nameTok.pos, nameTok.end}
+	}
+
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseSelections(cmd); err != nil {
+		return nil, err
+	}
+	cmd.End = p.lastEnd
+	return cmd, nil
+}
+
+// file_range = range | line_num
+func (p *parser) parseFileRange() (*RangeSpec, error) {
+	start, startTok, err := p.expectNumber()
+	if err != nil {
+		return nil, err
+	}
+	end := start
+	if p.peek().kind == tokDash {
+		p.next()
+		if end, _, err = p.expectNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if end < start {
+		return nil, p.errorf(startTok, "file range %d-%d ends before it starts", start, end)
+	}
+	return &RangeSpec{start, end, startTok.pos, p.lastEnd}, nil
+}
+
+// Parses the visual selection, highlight selection and options that follow
+// the command argument. Each of them may occur at most once.
+func (p *parser) parseSelections(cmd *Command) error {
+	for {
+		tok := p.peek()
+		relative := false
+		if tok.kind == tokLetter && tok.text == "r" {
+			if second := p.peekSecond(); second.kind == tokLAngle || second.kind == tokLBrace {
+				relative = true
+				p.next()
+			}
+		}
+
+		var err error
+		switch p.peek().kind {
+		case tokEOF:
+			return nil
+		case tokLAngle:
+			if cmd.Visuals != nil {
+				return p.errorf(p.peek(), "duplicate visual selection")
+			}
+			cmd.Visuals, err = p.parseVisuals(tok.pos, relative)
+		case tokLBrace:
+			if cmd.Highlights != nil {
+				return p.errorf(p.peek(), "duplicate highlight selection")
+			}
+			cmd.Highlights, err = p.parseHighlights(tok.pos, relative)
+		case tokLBracket:
+			if cmd.Options != nil {
+				return p.errorf(p.peek(), "duplicate options")
+			}
+			p.next()
+			cmd.Options, err = p.parseOptionItems(tokRBracket)
+			if cmd.Options != nil {
+				cmd.Options.Pos = tok.pos
+				cmd.Options.End = p.lastEnd
+			}
+		default:
+			return p.errorf(p.peek(), "unexpected %s after command", p.peek())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// vis_select = ["r"], "<", vis_item, { ",", vis_item }, ">"
+func (p *parser) parseVisuals(pos Pos, relative bool) (*VisualSelection, error) {
+	p.next() // '<'
+	vs := &VisualSelection{Relative: relative, Pos: pos}
+	for {
+		item := VisualItem{Pos: p.peek().pos}
+		if tok := p.peek(); tok.kind == tokLetter {
+			switch tok.text {
+			case "h", "d", "r":
+				item.Mode = VisualMode(tok.text[0])
+			default:
+				return nil, p.errorf(tok, "unknown visual modification %s, expected 'h', 'd' or 'r'", tok)
+			}
+			p.next()
+		}
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		item.Selector = sel
+		item.End = p.lastEnd
+		vs.Items = append(vs.Items, item)
+
+		if done, err := p.parseListSeparator(tokRAngle); done || err != nil {
+			vs.End = p.lastEnd
+			return vs, err
+		}
+	}
+}
+
+// hl_select = ["r"], "{", selector, { ",", selector }, "}"
+func (p *parser) parseHighlights(pos Pos, relative bool) (*HighlightSelection, error) {
+	p.next() // '{'
+	hs := &HighlightSelection{Relative: relative, Pos: pos}
+	for {
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		hs.Items = append(hs.Items, sel)
+
+		if done, err := p.parseListSeparator(tokRBrace); done || err != nil {
+			hs.End = p.lastEnd
+			return hs, err
+		}
+	}
+}
+
+// Consumes either a ',' or the closing token of a list and reports whether
+// the list ended.
+func (p *parser) parseListSeparator(closing tokenKind) (bool, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokComma:
+		return false, nil
+	case closing:
+		return true, nil
+	default:
+		return true, p.errorf(tok, "expected ',' or %s but found %s", closing, tok)
+	}
+}
+
+// selector = line_num | range | line_num, ":", ["{"], char_range, { "|", char_range }, ["}"]
+func (p *parser) parseSelector() (Selector, error) {
+	start, startTok, err := p.expectNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.peek().kind {
+	case tokColon:
+		p.next()
+		return p.parseCharRanges(start, startTok.pos)
+	case tokDash:
+		p.next()
+		end, _, err := p.expectNumber()
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, p.errorf(startTok, "range %d-%d ends before it starts", start, end)
+		}
+		return &LineRangeSelector{start, end, startTok.pos, p.lastEnd}, nil
+	default:
+		return &LineSelector{start, startTok.pos, p.lastEnd}, nil
+	}
+}
+
+// char_range = { digit }, "-", { digit }
+func (p *parser) parseCharRanges(line int, pos Pos) (Selector, error) {
+	braced := p.peek().kind == tokLBrace
+	if braced {
+		p.next()
+	}
+
+	cr := &CharRangeSelector{Line: line, Pos: pos}
+	for {
+		start, startTok, err := p.expectNumber()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokDash); err != nil {
+			return nil, err
+		}
+		end, _, err := p.expectNumber()
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, p.errorf(startTok, "char range %d-%d ends before it starts", start, end)
+		}
+		cr.Ranges = append(cr.Ranges, CharSpan{start, end, startTok.pos, p.lastEnd})
+
+		if p.peek().kind != tokPipe {
+			break
+		}
+		p.next()
+	}
+
+	if braced {
+		if _, err := p.expect(tokRBrace); err != nil {
+			return nil, err
+		}
+	}
+	cr.End = p.lastEnd
+	return cr, nil
+}
+
+// options = option, { ",", option }
+// option  = key, "=", value
+func (p *parser) parseOptionItems(closing tokenKind) (*OptionList, error) {
+	ol := &OptionList{Pos: p.peek().pos}
+	for {
+		tok := p.next()
+		switch tok.kind {
+		case closing:
+			ol.End = p.lastEnd
+			return ol, nil
+		case tokComma:
+			continue
+		case tokIdent:
+		default:
+			return nil, p.errorf(tok, "expected option key but found %s", tok)
+		}
+
+		if _, err := p.expect(tokEquals); err != nil {
+			return nil, p.errorf(tok, "option %s needs a value, e.g., %s=value", tok, tok.text)
+		}
+		value := p.next()
+		if value.kind != tokValue && value.kind != tokString {
+			return nil, p.errorf(value, "expected option value but found %s", value)
+		}
+		ol.Items = append(ol.Items, Option{tok.text, value.text, tok.pos, p.lastEnd})
+	}
+}
@@ -0,0 +1,233 @@
+package code_dsl
+
+import (
+	"testing"
+)
+
+func TestParseInsertCodeCommand(t *testing.T) {
+	cmd, err := ParseCommand("insert_code(foo.cpp:4-17)")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Kind != InsertCodeCommand {
+		t.Error("Command kind was wrong.")
+	}
+	if cmd.File.Path != "foo.cpp" || cmd.File.Pos != 12 {
+		t.Log("File: ", cmd.File)
+		t.Error("Filename was wrongly parsed.")
+	}
+	if cmd.Range.Start != 4 || cmd.Range.End != 17 {
+		t.Log("Range: ", *cmd.Range)
+		t.Error("File range was wrongly parsed.")
+	}
+	if cmd.Visuals != nil || cmd.Highlights != nil || cmd.Options != nil {
+		t.Error("Command had selections but none were specified.")
+	}
+}
+
+func TestParseInsertCodeSingleLine(t *testing.T) {
+	cmd, err := ParseCommand("insert_code(foo.cpp:4)")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Range.Start != 4 || cmd.Range.End != 4 {
+		t.Log("Range: ", *cmd.Range)
+		t.Error("Single line file range was wrongly parsed.")
+	}
+}
+
+func TestParseRevInsertCodeCommand(t *testing.T) {
+	cmd, err := ParseCommand("rev_insert_code(dir/foo.cpp:FooID)")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Kind != RevInsertCodeCommand {
+		t.Error("Command kind was wrong.")
+	}
+	if cmd.File.Path != "dir/foo.cpp" || cmd.Block.ID != "FooID" {
+		t.Log("File: ", cmd.File, " Block: ", *cmd.Block)
+		t.Error("rev_insert_code argument was wrongly parsed.")
+	}
+}
+
+func TestParseRevInsertCodeWithoutFilename(t *testing.T) {
+	for _, line := range []string{"rev_insert_code(:FooID){2}", "rev_insert_code(FooID){2}"} {
+		cmd, err := ParseCommand(line)
+		if err != nil {
+			t.Fatal("Could not parse command:", err)
+		}
+		if cmd.File.Path != "" || cmd.Block.ID != "FooID" || cmd.Highlights == nil {
+			t.Log("File: ", cmd.File, " Block: ", *cmd.Block)
+			t.Error("rev_insert_code without filename was wrongly parsed: ", line)
+		}
+		if cmd.String() != "rev_insert_code(:FooID){2}" {
+			t.Error("Command was not printed in canonical form: ", cmd.String())
+		}
+	}
+
+	if _, err := ParseCommand("insert_code(:4-17)"); err == nil {
+		t.Error("insert_code without filename was not reported.")
+	}
+}
+
+func TestParseSelections(t *testing.T) {
+	cmd, err := ParseCommand("insert_code(foo.cpp:1-9)r<d2-3,h7,6:{9-31|33-40}>{1,4-5}[indent=-2, comments=false]")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Visuals == nil || !cmd.Visuals.Relative || len(cmd.Visuals.Items) != 3 {
+		t.Fatal("Visual selection was wrongly parsed.")
+	}
+	if cmd.Visuals.Items[0].Mode != 'd' || cmd.Visuals.Items[1].Mode != 'h' || cmd.Visuals.Items[2].Mode != 0 {
+		t.Error("Visual modes were wrongly parsed.")
+	}
+	lr, ok := cmd.Visuals.Items[0].Selector.(*LineRangeSelector)
+	if !ok || lr.Start != 2 || lr.End != 3 {
+		t.Error("Visual line range was wrongly parsed.")
+	}
+	cr, ok := cmd.Visuals.Items[2].Selector.(*CharRangeSelector)
+	if !ok || cr.Line != 6 || len(cr.Ranges) != 2 || cr.Ranges[1].Start != 33 || cr.Ranges[1].End != 40 {
+		t.Error("Visual char range was wrongly parsed.")
+	}
+
+	if cmd.Highlights == nil || cmd.Highlights.Relative || len(cmd.Highlights.Items) != 2 {
+		t.Fatal("Highlight selection was wrongly parsed.")
+	}
+	if ln, ok := cmd.Highlights.Items[0].(*LineSelector); !ok || ln.Line != 1 {
+		t.Error("Highlight line was wrongly parsed.")
+	}
+
+	if cmd.Options == nil || len(cmd.Options.Items) != 2 {
+		t.Fatal("Options were wrongly parsed.")
+	}
+	if cmd.Options.Items[0].Key != "indent" || cmd.Options.Items[0].Value != "-2" {
+		t.Log("Option: ", cmd.Options.Items[0])
+		t.Error("Option was wrongly parsed.")
+	}
+	if cmd.Options.Items[1].Key != "comments" || cmd.Options.Items[1].Value != "false" {
+		t.Log("Option: ", cmd.Options.Items[1])
+		t.Error("Option was wrongly parsed.")
+	}
+}
+
+func TestParseSelectionsInAnyOrder(t *testing.T) {
+	cmd, err := ParseCommand("insert_code(foo.cpp:1-9)[indent=2]r{2}<r3>")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Visuals == nil || cmd.Highlights == nil || cmd.Options == nil {
+		t.Error("Selections were not all parsed.")
+	}
+}
+
+func TestParseFilenameWithParen(t *testing.T) {
+	cmd, err := ParseCommand("insert_code(foo(1).cpp:1-9){2}")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.File.Path != "foo(1).cpp" {
+		t.Log("File: ", cmd.File.Path)
+		t.Error("Filename was wrongly parsed.")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	badCommands := map[string]Pos{
+		"insert_code(foo.cpp)":              12,
+		"insert_code(foo.cpp:1-9)<x2>":      25,
+		"insert_code(foo.cpp:1-9){2,}":      27,
+		"insert_code(foo.cpp:1-9){2}{3}":    27,
+		"insert_code(foo.cpp:9-1)":          20,
+		"insert_code(foo.cpp:1-9)[indent]":  25,
+		"insert_code(foo.cpp:1-9) trailing": 25,
+		"other_code(foo.cpp:1-9)":           0,
+	}
+
+	for command, expectedPos := range badCommands {
+		_, err := ParseCommand(command)
+		if err == nil {
+			t.Errorf("Command %s was parsed without an error.", command)
+			continue
+		}
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Command %s did not produce a ParseError.", command)
+			continue
+		}
+		if parseErr.Pos != expectedPos {
+			t.Log("Error: ", parseErr, " expected pos ", expectedPos)
+			t.Errorf("Error for %s pointed to the wrong position.", command)
+		}
+	}
+}
+
+func TestParseOptionList(t *testing.T) {
+	ol, err := ParseOptionList("indent=2, comments=\"false\"")
+	if err != nil {
+		t.Fatal("Could not parse options:", err)
+	}
+
+	if len(ol.Items) != 2 || ol.Items[1].Value != "false" {
+		t.Log("Options: ", ol.Items)
+		t.Error("Option list was wrongly parsed.")
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	testCases := map[string]string{
+		"insert_code(foo.cpp:4-17)":                                   "insert_code(foo.cpp:4-17)",
+		"insert_code(foo.cpp:4-4)":                                    "insert_code(foo.cpp:4)",
+		"insert_code(foo.cpp:1-9)[indent=2]  {1, 4-5}r< d2-3,6:9-31>": "insert_code(foo.cpp:1-9)r<d2-3,6:9-31>{1,4-5}[indent=2]",
+		"insert_code(foo.cpp:1-9){2:{3-5|7-8}}":                       "insert_code(foo.cpp:1-9){2:{3-5|7-8}}",
+		"rev_insert_code(\"dir/foo.cpp\":FooID)[label=\"a, b\"]":      "rev_insert_code(dir/foo.cpp:FooID)[label=\"a, b\"]",
+		"insert_code(\"a:1)b.cpp\":2)":                                "insert_code(\"a:1)b.cpp\":2)",
+		"insert_code(foo.cpp:1)[]":                                    "insert_code(foo.cpp:1)",
+	}
+
+	for input, expected := range testCases {
+		formatted, err := FormatCommand(input)
+		if err != nil {
+			t.Error("Could not format command:", err)
+			continue
+		}
+		if formatted != expected {
+			t.Log("Input:     ", input)
+			t.Log("Formatted: ", formatted)
+			t.Error("Command was not formatted canonically.")
+		}
+		if again, _ := FormatCommand(formatted); again != formatted {
+			t.Log("Formatted twice: ", again)
+			t.Error("Formatting was not idempotent.")
+		}
+	}
+}
+
+func TestParseInsertSymbolCommand(t *testing.T) {
+	cmd, err := ParseCommand("insert_symbol(pkg/server.go:Server.Handle)r{2}[doc=false]")
+	if err != nil {
+		t.Fatal("Could not parse command:", err)
+	}
+
+	if cmd.Kind != InsertSymbolCommand {
+		t.Error("Command kind was wrong.")
+	}
+	if cmd.File.Path != "pkg/server.go" || cmd.Symbol == nil || cmd.Symbol.Name != "Server.Handle" {
+		t.Log("File: ", cmd.File, " Symbol: ", cmd.Symbol)
+		t.Error("insert_symbol argument was wrongly parsed.")
+	}
+	if cmd.String() != "insert_symbol(pkg/server.go:Server.Handle)r{2}[doc=false]" {
+		t.Error("Command was not printed in canonical form: ", cmd.String())
+	}
+
+	for _, line := range []string{"insert_symbol(server.go:4-17)", "insert_symbol(Server.Handle)"} {
+		if _, err := ParseCommand(line); err == nil {
+			t.Error("Invalid insert_symbol command was accepted: ", line)
+		}
+	}
+}
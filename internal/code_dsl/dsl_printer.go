@@ -0,0 +1,135 @@
+package code_dsl
+
+import (
+	"fmt"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Printer
+//
+// Prints a Command in its canonical form, i.e., without spaces, with the
+// selections in the order visuals, highlights, options, and with quotes only
+// where they are needed. Parsing the printed command yields the same AST.
+//===----------------------------------------------------------------------===//
+
+// Returns the canonical form of the command.
+func (cmd *Command) String() string {
+	var selector string
+	switch {
+	case cmd.Range != nil:
+		selector = formatLineRange(cmd.Range.Start, cmd.Range.End)
+	case cmd.Block != nil:
+		selector = cmd.Block.ID
+	case cmd.Symbol != nil:
+		selector = cmd.Symbol.Name
+	}
+
+	var sb strings.Builder
+	if cmd.Visuals != nil {
+		cmd.Visuals.format(&sb)
+	}
+	if cmd.Highlights != nil {
+		cmd.Highlights.format(&sb)
+	}
+	if cmd.Options != nil && len(cmd.Options.Items) > 0 {
+		cmd.Options.format(&sb)
+	}
+
+	unquoted := fmt.Sprintf("%s(%s:%s)", cmd.Kind, cmd.File.Path, selector)
+	if reparsed, err := ParseCommand(unquoted); err == nil && reparsed.File.Path == cmd.File.Path {
+		return unquoted + sb.String()
+	}
+	return fmt.Sprintf("%s(%s:%s)", cmd.Kind, quote(cmd.File.Path), selector) + sb.String()
+}
+
+// Reformats a DSL command line into its canonical form.
+func FormatCommand(line string) (string, error) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return line, err
+	}
+	return cmd.String(), nil
+}
+
+func formatLineRange(start int, end int) string {
+	if start == end {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+func formatSelector(sel Selector) string {
+	switch s := sel.(type) {
+	case *LineSelector:
+		return fmt.Sprint(s.Line)
+	case *LineRangeSelector:
+		return fmt.Sprintf("%d-%d", s.Start, s.End)
+	case *CharRangeSelector:
+		spans := make([]string, 0, len(s.Ranges))
+		for _, span := range s.Ranges {
+			spans = append(spans, fmt.Sprintf("%d-%d", span.Start, span.End))
+		}
+		if len(spans) == 1 {
+			return fmt.Sprintf("%d:%s", s.Line, spans[0])
+		}
+		return fmt.Sprintf("%d:{%s}", s.Line, strings.Join(spans, "|"))
+	default:
+		return ""
+	}
+}
+
+func (vs *VisualSelection) format(sb *strings.Builder) {
+	if vs.Relative {
+		sb.WriteByte('r')
+	}
+	sb.WriteByte('<')
+	for idx, item := range vs.Items {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		if item.Mode != 0 {
+			sb.WriteByte(byte(item.Mode))
+		}
+		sb.WriteString(formatSelector(item.Selector))
+	}
+	sb.WriteByte('>')
+}
+
+func (hs *HighlightSelection) format(sb *strings.Builder) {
+	if hs.Relative {
+		sb.WriteByte('r')
+	}
+	sb.WriteByte('{')
+	for idx, sel := range hs.Items {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(formatSelector(sel))
+	}
+	sb.WriteByte('}')
+}
+
+func (ol *OptionList) format(sb *strings.Builder) {
+	sb.WriteByte('[')
+	for idx, option := range ol.Items {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(option.Key + "=" + formatOptionValue(option.Value))
+	}
+	sb.WriteByte(']')
+}
+
+// Option values are only quoted if they would be read differently otherwise.
+func formatOptionValue(value string) string {
+	if value == "" || value != strings.TrimSpace(value) || strings.ContainsAny(value, ",]\"") {
+		return quote(value)
+	}
+	return value
+}
+
+// Quotes a string so that lexQuoted reads it back unchanged.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,64 @@
+package code_dsl
+
+import "strings"
+
+// Env holds everything that is needed to resolve and render DSL commands
+// besides the command itself.
+type Env struct {
+	// Resolves the filenames in DSL commands to code files
+	Sources *SourceResolver
+	// Options that are used unless a command overrides them
+	Defaults CodeGenOptionsImpl
+	// Maps file extensions without the dot, e.g., "tpp", to the language
+	// of the generated code block. Extensions that are not listed use the
+	// built-in mapping.
+	Languages map[string]string
+	// Comment syntaxes of languages that are not built in or should be
+	// written differently, by the language of the generated code block
+	Comments CommentRegistry
+	// Maps the text of insert_code commands to the fingerprints of the
+	// lines they quoted, so shifted ranges can be relocated, see Anchor.
+	Anchors map[string]Anchor
+}
+
+// Returns a copy of the environment that relocates commands with the given
+// anchors.
+func (env *Env) WithAnchors(anchors map[string]Anchor) *Env {
+	copied := *env
+	copied.Anchors = anchors
+	return &copied
+}
+
+// Returns the language of the code block for a code file.
+func (env *Env) language(filename string) string {
+	if env != nil {
+		extension := strings.TrimPrefix(getFiletype(filename), ".")
+		if language, found := env.Languages[extension]; found {
+			return language
+		}
+	}
+	return getProgrammingLanguage(filename)
+}
+
+// Returns the comment syntaxes code_block markers may be written in for a
+// code file.
+func (env *Env) markerSyntaxes(filename string) []CommentSyntax {
+	var registry CommentRegistry
+	if env != nil {
+		registry = env.Comments
+	}
+	return registry.markerSyntaxes(env.language(filename))
+}
+
+// Returns the comment syntax of a language. Unknown languages are commented
+// like C.
+func (env *Env) commentSyntax(language string) CommentSyntax {
+	var registry CommentRegistry
+	if env != nil {
+		registry = env.Comments
+	}
+	if cs, found := registry.Lookup(language); found {
+		return cs
+	}
+	return slashComments
+}
@@ -0,0 +1,242 @@
+package code_dsl
+
+import (
+	"go/format"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"strings"
+
+	"github.com/vulder/remark_code_injector/internal/diff"
+)
+
+//===----------------------------------------------------------------------===//
+// gofmt
+//
+// "format=gofmt" formats the rendered Go code with go/format, which fixes
+// the indentation and stray blank lines that hidden and removed lines leave
+// behind. Quoted code is rarely a complete file, so fragments are formatted
+// in a context that makes them valid Go:
+//  * declaration and statement lists are accepted by go/format itself,
+//  * braces that are opened but not closed in the fragment, or closed but
+//    not opened, are balanced with additional lines,
+//  * struct fields are wrapped in a struct type.
+// The lines that were added are removed again after formatting.
+//
+// Highlights are applied after formatting. gofmt mostly changes whitespace,
+// so formatted lines are matched with the rendered lines by their code
+// without whitespace.
+//===----------------------------------------------------------------------===//
+
+// Formats the code of a Go insertion if its options ask for it. Code that
+// cannot be formatted is inserted as it is and reported as warning.
+func formatGoCode(cmd *Command, ci *CodeInsertion) Diagnostics {
+	if !ci.options.formatGo() || ci.progLang != "go" {
+		return nil
+	}
+	code, err := ci.codeBlock.renderGoFormatted(&ci.highlights, &ci.visuals, ci.markerSyntaxes, ci.options)
+	if err != nil {
+		from, to := cmd.File.Pos, cmd.End
+		if cmd.Options != nil {
+			from, to = cmd.Options.Pos, cmd.Options.End
+		}
+		return Diagnostics{newCommandWarning(cmd, from, to, "could not format the code with gofmt, it is inserted as it is: %s", err)}
+	}
+	ci.formatted, ci.isFormatted = code, true
+	return nil
+}
+
+// renderedLine is a line of a code block after visuals were applied.
+type renderedLine struct {
+	lineNum int
+	text    string
+}
+
+// Renders a CodeBlock like render but formats the code with gofmt before the
+// highlights and the indent are applied.
+func (cb CodeBlock) renderGoFormatted(highlights *Highlights, visuals *VisualModifications, markerSyntaxes []CommentSyntax, options CodeGenOptions) (string, error) {
+	rendered := []renderedLine{}
+	lineNum := cb.fileRange.start
+	for e := cb.lines.Front(); e != nil; e, lineNum = e.Next(), lineNum+1 {
+		line := e.Value.(string)
+		if cb.removed[lineNum] || isCodeBlockPairMarker(line, markerSyntaxes) {
+			continue
+		}
+		if visuals != nil {
+			vLine, useLine := visuals.ModifyLine(line, lineNum)
+			if !useLine {
+				continue
+			}
+			line = vLine
+		}
+		if options.hideComments() && strings.HasPrefix(strings.Trim(line, " "), "//") {
+			continue
+		}
+		rendered = append(rendered, renderedLine{lineNum, line})
+	}
+
+	texts := make([]string, len(rendered))
+	for idx, line := range rendered {
+		texts[idx] = line.text
+	}
+	formatted, err := formatGoFragment(texts)
+	if err != nil {
+		return "", err
+	}
+
+	source := map[int]renderedLine{}
+	for _, edit := range diff.Lines(codeWithoutSpaces(texts), codeWithoutSpaces(formatted)) {
+		if edit.Kind == diff.Equal {
+			source[edit.NewLine] = rendered[edit.OldLine]
+		}
+	}
+
+	strRepr := ""
+	for idx, line := range formatted {
+		if original, found := source[idx]; found && highlights != nil && highlights.Contains(original.lineNum) {
+			if highlights.HasSubrange(original.lineNum) {
+				line = highlightFormattedLine(highlights, original, line)
+			} else {
+				strRepr += "*"
+				line = strings.TrimPrefix(line, " ")
+			}
+		}
+		if options.getIndent() != 0 {
+			line = adaptIndent(line, options.getIndent())
+		}
+		strRepr += line + "\n"
+	}
+	return strRepr, nil
+}
+
+// Applies the char range highlights of a rendered line to its formatted
+// line. The highlights are only kept if gofmt changed nothing but the
+// indentation of the line.
+func highlightFormattedLine(highlights *Highlights, original renderedLine, line string) string {
+	originalIndent := leadingSpace(original.text)
+	if original.text[len(originalIndent):] != line[len(leadingSpace(line)):] {
+		return line
+	}
+	highlighted := highlights.RenderSubrange(original.text, original.lineNum)
+	if !strings.HasPrefix(highlighted, originalIndent) {
+		return line
+	}
+	return leadingSpace(line) + highlighted[len(originalIndent):]
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+func codeWithoutSpaces(lines []string) []string {
+	codes := make([]string, len(lines))
+	for idx, line := range lines {
+		codes[idx] = strings.Join(strings.Fields(line), "")
+	}
+	return codes
+}
+
+// goFragmentContext makes a fragment of Go code valid by adding lines before
+// and after it.
+type goFragmentContext struct {
+	before []string
+	after  []string
+	// Set if the lines of the fragment are indented by one more level inside
+	// of the context
+	nested bool
+}
+
+// Formats lines of Go code that are not necessarily a complete file.
+func formatGoFragment(lines []string) ([]string, error) {
+	code, err := format.Source([]byte(strings.Join(lines, "\n")))
+	if err == nil {
+		return splitFormatted(code), nil
+	}
+
+	opening, closing := unmatchedBraces(strings.Join(lines, "\n"))
+	balanced := goFragmentContext{
+		before: repeatLine("{", closing),
+		after:  repeatLine("}", opening),
+	}
+	contexts := []goFragmentContext{
+		balanced,
+		{before: append([]string{"type _ struct {"}, balanced.before...), after: append(balanced.after, "}"), nested: true},
+	}
+	for _, context := range contexts {
+		if len(context.before) == 0 && len(context.after) == 0 {
+			continue
+		}
+		wrapped := append(append(append([]string{}, context.before...), lines...), context.after...)
+		code, wrappedErr := format.Source([]byte(strings.Join(wrapped, "\n")))
+		if wrappedErr != nil {
+			continue
+		}
+		if formatted, ok := context.unwrap(splitFormatted(code)); ok {
+			return formatted, nil
+		}
+	}
+	return nil, err
+}
+
+// Removes the lines of the context from the formatted code.
+func (context goFragmentContext) unwrap(formatted []string) ([]string, bool) {
+	if len(formatted) < len(context.before)+len(context.after) {
+		return nil, false
+	}
+	for idx, line := range context.before {
+		if strings.TrimSpace(formatted[idx]) != line {
+			return nil, false
+		}
+	}
+	for idx, line := range context.after {
+		if strings.TrimSpace(formatted[len(formatted)-len(context.after)+idx]) != line {
+			return nil, false
+		}
+	}
+
+	unwrapped := formatted[len(context.before) : len(formatted)-len(context.after)]
+	if context.nested {
+		for idx, line := range unwrapped {
+			unwrapped[idx] = strings.TrimPrefix(line, "\t")
+		}
+	}
+	return unwrapped, true
+}
+
+// Counts the braces that are opened but not closed in Go code and the ones
+// that are closed without being opened. Braces in strings and comments are
+// not counted.
+func unmatchedBraces(code string) (int, int) {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var scanner goscanner.Scanner
+	scanner.Init(file, []byte(code), nil, 0)
+
+	opening, closing := 0, 0
+	for {
+		_, tok, _ := scanner.Scan()
+		switch tok {
+		case gotoken.EOF:
+			return opening, closing
+		case gotoken.LBRACE:
+			opening++
+		case gotoken.RBRACE:
+			if opening > 0 {
+				opening--
+			} else {
+				closing++
+			}
+		}
+	}
+}
+
+func repeatLine(line string, count int) []string {
+	lines := []string{}
+	for idx := 0; idx < count; idx++ {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func splitFormatted(code []byte) []string {
+	return strings.Split(strings.TrimRight(string(code), "\n"), "\n")
+}
@@ -0,0 +1,65 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+const goFormatTestFile = `package main
+
+func run() error {
+    x := sum(1,
+        2)
+
+
+
+    if x>0 {
+  return nil
+    }
+	return  nil
+}
+
+type T struct {
+	A int
+    bb string
+}
+`
+
+func TestFormatGoFragments(t *testing.T) {
+	env := makeTestEnv("main.go", goFormatTestFile)
+
+	checks := []struct {
+		line     string
+		expected string
+	}{
+		// A complete function, the highlight follows its line.
+		{"insert_code(main.go:3-14){9}[format=gofmt]",
+			"func run() error {\n\tx := sum(1,\n\t\t2)\n\n*\tif x > 0 {\n\t\treturn nil\n\t}\n\treturn nil\n}\n"},
+		// Braces that are not closed, with hidden lines.
+		{"insert_code(main.go:3-10)r<h4-6>[format=gofmt]",
+			"func run() error {\n\tx := sum(1,\n\t\t2)\n\n\tif x > 0 {\n\t\treturn nil\n"},
+		// A brace that is closed but not opened.
+		{"insert_code(main.go:9-13){12}[format=gofmt]",
+			"\tif x > 0 {\n\t\treturn nil\n\t}\n*\treturn nil\n}\n"},
+		// Struct fields, char range highlights are kept.
+		{"insert_code(main.go:16-17){17:5-6}[format=gofmt]", "A  int\n`bb` string\n"},
+	}
+	for _, check := range checks {
+		transformed, diags := TransformLine(check.line, env)
+		if len(diags) != 0 || transformed != "```go\n"+check.expected+"```" {
+			t.Log("command: ", check.line, " transformed: ", transformed, " diags: ", diags)
+			t.Error("Code was not formatted with gofmt.")
+		}
+	}
+}
+
+func TestFormatGoFailureIsWarning(t *testing.T) {
+	env := makeTestEnv("main.go", goFormatTestFile)
+
+	transformed, diags := TransformLine("insert_code(main.go:4)[format=gofmt]", env)
+	if len(diags) != 1 || diags[0].Severity != SeverityWarning || !strings.Contains(diags[0].Msg, "could not format") ||
+		transformed != "```go\n    x := sum(1,\n```" {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Code that gofmt rejects was not inserted as it is with a warning.")
+	}
+}
@@ -0,0 +1,252 @@
+package code_dsl
+
+import (
+	"go/ast"
+	gotoken "go/token"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Go rewrites
+//
+// Options that shorten Go code with the help of its AST, e.g., to show the
+// method set of a type on a slide:
+//   bodies=elide      func (s *Server) Handle() { /* ... */ }
+//   signature=only    func (s *Server) Handle()
+//   fields=exported   drops unexported struct fields and leaves a
+//                     "// contains filtered or unexported fields" line
+//   errcheck=fold     replaces "if err != nil { return err }" statements
+//                     with a "// if err != nil { ... }" line
+//   errcheck=remove   drops such statements
+// The rewrites are applied to the lines of the file before highlights and
+// visuals, and every line keeps its line number, so highlights and visuals
+// still select the lines of the file. Code of other languages is left as it
+// is.
+//===----------------------------------------------------------------------===//
+
+// lineEdit is the new text of a line of a code file.
+type lineEdit struct {
+	text    string
+	removed bool
+}
+
+// Checks if the options ask for any of the Go rewrites.
+func rewritesGo(options CodeGenOptions) bool {
+	return options.elideBodies() || options.signatureOnly() || options.exportedFieldsOnly() ||
+		options.errCheckMode() != KeepErrChecks
+}
+
+// Applies the Go rewrites the options of a command ask for to the code block
+// of the insertion.
+func rewriteGoCode(cmd *Command, ci *CodeInsertion, filename string, env *Env) Diagnostics {
+	if !rewritesGo(ci.options) || ci.progLang != "go" {
+		return nil
+	}
+	sourceFile, err := env.Sources.Load(filename)
+	if err != nil {
+		return nil
+	}
+
+	gf := sourceFile.goAST()
+	diags := Diagnostics{}
+	if gf.err != nil {
+		diags = append(diags, newCommandWarning(cmd, cmd.File.Pos, cmd.File.End,
+			"%s has syntax errors, code after them is not rewritten: %s", filename, gf.err))
+	}
+	if gf.file != nil {
+		ci.codeBlock.applyEdits(goLineEdits(gf, sourceFile.Lines, ci.codeBlock.fileRange, ci.options, ci.visuals.comments))
+	}
+	return diags
+}
+
+// Collects the edits of the Go rewrites for the declarations that start
+// within the line range.
+func goLineEdits(gf *goFile, lines []string, lineRange LineRange, options CodeGenOptions, comments CommentSyntax) map[int]lineEdit {
+	edits := map[int]lineEdit{}
+	position := func(pos gotoken.Pos) gotoken.Position {
+		return gf.fset.Position(pos)
+	}
+
+	if options.exportedFieldsOnly() {
+		ast.Inspect(gf.file, func(node ast.Node) bool {
+			if st, ok := node.(*ast.StructType); ok && st.Fields != nil {
+				removeUnexportedFields(edits, lines, lineRange, st.Fields.List, position, comments)
+			}
+			return true
+		})
+	}
+
+	if options.errCheckMode() != KeepErrChecks {
+		ast.Inspect(gf.file, func(node ast.Node) bool {
+			errVar, ok := errCheckVariable(node)
+			if !ok {
+				return true
+			}
+			from, to := position(node.Pos()), position(node.End())
+			if !lineRange.Contains(from.Line) || !ownsLines(lines, from, to) {
+				return true
+			}
+			for line := from.Line; line <= to.Line; line++ {
+				edits[line] = lineEdit{removed: true}
+			}
+			if options.errCheckMode() == FoldErrChecks {
+				placeholder := options.errCheckPlaceholder()
+				if placeholder == "" {
+					placeholder = "if " + errVar + " != nil { ... }"
+				}
+				edits[from.Line] = lineEdit{text: lines[from.Line-1][:from.Column-1] + makeComment(" "+placeholder, comments)}
+			}
+			return false
+		})
+	}
+
+	// Function bodies are rewritten last, as they replace edits of structs
+	// that are declared inside of them.
+	if options.signatureOnly() || options.elideBodies() {
+		for _, decl := range gf.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || !lineRange.Contains(position(fn.Body.Lbrace).Line) {
+				continue
+			}
+			from, to := position(fn.Body.Lbrace), position(fn.Body.Rbrace+1)
+			if options.signatureOnly() {
+				signature := strings.TrimRight(lines[from.Line-1][:from.Column-1], " \t")
+				from.Column = len(signature) + 1
+				replaceLines(edits, lines, from, to, "")
+			} else {
+				replaceLines(edits, lines, from, to, "{ "+makeMultilineComment(" ... ", comments, false)+" }")
+			}
+		}
+	}
+	return edits
+}
+
+// Checks if a statement is an error check, i.e., an if statement without
+// init and else whose condition compares an error variable to nil and whose
+// last statement returns or calls a function, e.g., log.Fatal. Returns the
+// name of the error variable.
+func errCheckVariable(node ast.Node) (string, bool) {
+	stmt, ok := node.(*ast.IfStmt)
+	if !ok || stmt.Init != nil || stmt.Else != nil || len(stmt.Body.List) == 0 {
+		return "", false
+	}
+	cond, ok := stmt.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != gotoken.NEQ {
+		return "", false
+	}
+	errVar, ok := cond.X.(*ast.Ident)
+	if nilIdent, isIdent := cond.Y.(*ast.Ident); !ok || !isIdent || nilIdent.Name != "nil" ||
+		!(errVar.Name == "err" || strings.HasSuffix(errVar.Name, "Err")) {
+		return "", false
+	}
+
+	switch last := stmt.Body.List[len(stmt.Body.List)-1].(type) {
+	case *ast.ReturnStmt:
+		return errVar.Name, true
+	case *ast.ExprStmt:
+		_, isCall := last.X.(*ast.CallExpr)
+		return errVar.Name, isCall
+	default:
+		return "", false
+	}
+}
+
+// Removes the unexported fields of a struct that occupy lines of their own
+// and leaves a comment in place of the last one.
+func removeUnexportedFields(edits map[int]lineEdit, lines []string, lineRange LineRange, fields []*ast.Field,
+	position func(gotoken.Pos) gotoken.Position, comments CommentSyntax) {
+	lastRemoved := 0
+	for _, field := range fields {
+		if isExportedField(field) {
+			continue
+		}
+		first, last := field.Pos(), field.End()
+		if field.Doc != nil {
+			first = field.Doc.Pos()
+		}
+		if field.Comment != nil {
+			last = field.Comment.End()
+		}
+		from, to := position(first), position(last)
+		if !lineRange.Contains(from.Line) || !ownsLines(lines, from, to) {
+			continue
+		}
+		for line := from.Line; line <= to.Line; line++ {
+			edits[line] = lineEdit{removed: true}
+		}
+		lastRemoved = to.Line
+	}
+
+	if lastRemoved != 0 {
+		line := lines[lastRemoved-1]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		edits[lastRemoved] = lineEdit{text: indent + makeComment(" contains filtered or unexported fields", comments)}
+	}
+}
+
+// Checks if a field has an exported name. Embedded fields are named by their
+// type, e.g., "*bytes.Buffer" is named "Buffer".
+func isExportedField(field *ast.Field) bool {
+	for _, name := range field.Names {
+		if name.IsExported() {
+			return true
+		}
+	}
+	if len(field.Names) > 0 {
+		return false
+	}
+
+	expr := field.Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			expr = e.Sel
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.IsExported()
+		default:
+			return true
+		}
+	}
+}
+
+// Checks if the code between two positions fills its lines, i.e., there is
+// only whitespace before it on its first line and after it on its last line.
+func ownsLines(lines []string, from gotoken.Position, to gotoken.Position) bool {
+	return strings.TrimSpace(lines[from.Line-1][:from.Column-1]) == "" &&
+		strings.TrimSpace(lines[to.Line-1][to.Column-1:]) == ""
+}
+
+// Replaces the code between two positions with the text. The text is put
+// into the first line, together with the code before and after the
+// replaced code, and the other lines are removed.
+func replaceLines(edits map[int]lineEdit, lines []string, from gotoken.Position, to gotoken.Position, text string) {
+	edits[from.Line] = lineEdit{text: lines[from.Line-1][:from.Column-1] + text + lines[to.Line-1][to.Column-1:]}
+	for line := from.Line + 1; line <= to.Line; line++ {
+		edits[line] = lineEdit{removed: true}
+	}
+}
+
+// Applies the edits to the lines of the block. Removed lines keep their line
+// number but are not rendered.
+func (cb *CodeBlock) applyEdits(edits map[int]lineEdit) {
+	lineNum := cb.fileRange.start
+	for e := cb.lines.Front(); e != nil; e = e.Next() {
+		if edit, found := edits[lineNum]; found {
+			if edit.removed {
+				if cb.removed == nil {
+					cb.removed = map[int]bool{}
+				}
+				cb.removed[lineNum] = true
+			} else {
+				e.Value = edit.text
+			}
+		}
+		lineNum++
+	}
+}
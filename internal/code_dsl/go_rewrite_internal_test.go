@@ -0,0 +1,120 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+const goRewriteTestFile = `package server
+
+type Server struct {
+	// Addr is the listen address.
+	Addr string
+	// conns counts the open connections.
+	conns int
+	*bytes.Buffer
+	mu sync.Mutex // guards conns
+}
+
+// Handle serves a request.
+func (s *Server) Handle(w io.Writer,
+	r *Request) error {
+	s.conns++
+	return nil
+}
+
+func (s *Server) Close() error { return nil }
+`
+
+func TestRewriteGoBodies(t *testing.T) {
+	env := makeTestEnv("server.go", goRewriteTestFile)
+
+	transformed, diags := TransformLine("insert_code(server.go:12-19){13}[bodies=elide]", env)
+	expected := "```go\n// Handle serves a request.\n*func (s *Server) Handle(w io.Writer,\n\tr *Request) error { /* ... */ }\n\n" +
+		"func (s *Server) Close() error { /* ... */ }\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Function bodies were not elided.")
+	}
+
+	transformed, diags = TransformLine("insert_symbol(server.go:Server.Handle)r{2}[signature=only,doc=false]", env)
+	expected = "```go\nfunc (s *Server) Handle(w io.Writer,\n*\tr *Request) error\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Only the signature was not kept.")
+	}
+
+	// Hidden comments must not shift the lines of the elided bodies.
+	transformed, diags = TransformLine("insert_code(server.go:12-19)[bodies=elide,comments=false]", env)
+	expected = "```go\nfunc (s *Server) Handle(w io.Writer,\n\tr *Request) error { /* ... */ }\n\n" +
+		"func (s *Server) Close() error { /* ... */ }\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Function bodies were not elided without comments.")
+	}
+}
+
+func TestRewriteGoExportedFields(t *testing.T) {
+	env := makeTestEnv("server.go", goRewriteTestFile)
+
+	transformed, diags := TransformLine("insert_symbol(server.go:Server){5}[fields=exported]", env)
+	expected := "```go\ntype Server struct {\n\t// Addr is the listen address.\n*\tAddr string\n\t*bytes.Buffer\n" +
+		"\t// contains filtered or unexported fields\n}\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Unexported fields were not dropped.")
+	}
+
+	// Other languages are left as they are.
+	env = makeTestEnv("server.cpp", "void f() {\n  g();\n}\n")
+	transformed, diags = TransformLine("insert_code(server.cpp:1-3)[bodies=elide]", env)
+	if len(diags) != 0 || !strings.Contains(transformed, "g();") {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Code that is not Go was rewritten.")
+	}
+
+	if _, diags := TransformLine("insert_code(server.cpp:1-3)[bodies=drop]", env); !diags.HasErrors() {
+		t.Error("Invalid value of option bodies was not reported.")
+	}
+}
+
+const goErrCheckTestFile = `package main
+
+func run() error {
+	data, err := load()
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	if err := save(data); err != nil {
+		return err
+	}
+	if parseErr != nil {
+		log.Fatal(parseErr)
+	}
+	return nil
+}
+`
+
+func TestRewriteGoErrChecks(t *testing.T) {
+	env := makeTestEnv("main.go", goErrCheckTestFile)
+
+	transformed, diags := TransformLine("insert_symbol(main.go:run){8,14}[errcheck=fold]", env)
+	expected := "```go\nfunc run() error {\n\tdata, err := load()\n\t// if err != nil { ... }\n" +
+		"*\tif err := save(data); err != nil {\n\t\treturn err\n\t}\n\t// if parseErr != nil { ... }\n*\treturn nil\n}\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Error checks were not folded.")
+	}
+
+	transformed, diags = TransformLine(`insert_code(main.go:4-7)[errcheck=fold,errcheck_placeholder="handle err"]`, env)
+	if len(diags) != 0 || transformed != "```go\n\tdata, err := load()\n\t// handle err\n```" {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Error check was not folded with the placeholder.")
+	}
+
+	transformed, diags = TransformLine("insert_code(main.go:3-7)r<d2>[errcheck=remove]", env)
+	if len(diags) != 0 || transformed != "```go\nfunc run() error {\n// ...\n```" {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Error check was not removed.")
+	}
+}
@@ -0,0 +1,136 @@
+package code_dsl
+
+import (
+	"errors"
+	"go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Go symbols
+//
+// insert_symbol commands quote a declaration of a Go file by its name, e.g.,
+//   insert_symbol(server.go:NewServer)      a function
+//   insert_symbol(server.go:Server.Handle)  a method of Server or *Server
+//   insert_symbol(server.go:Server)         a type
+//   insert_symbol(server.go:MaxConns)       a const or var declaration
+// Consts and vars are quoted with the whole declaration they are part of, so
+// naming one constant of a "const ( ... )" block quotes the block. Types of a
+// "type ( ... )" block are quoted on their own.
+//
+// The line range of the symbol is taken from the Go AST and includes the doc
+// comment of the declaration unless the command sets "doc=false". Relative
+// selections count from the first line of the declaration, so "r{1}" is the
+// same line with and without the doc comment. As the range is looked up on
+// every run, refactorings that move code do not break the command.
+//===----------------------------------------------------------------------===//
+
+var errNoSymbol = errors.New("no symbol with this name found in file")
+
+// goFile is the parsed AST of a Go code file.
+type goFile struct {
+	fset *gotoken.FileSet
+	file *ast.File
+	// Set if the file has syntax errors, the AST then only holds the
+	// declarations that could be parsed
+	err error
+}
+
+// Returns the AST of the file, which is parsed on first use.
+func (sf *SourceFile) goAST() *goFile {
+	sf.goOnce.Do(func() {
+		fset := gotoken.NewFileSet()
+		file, err := goparser.ParseFile(fset, sf.Name, strings.Join(sf.Lines, "\n"), goparser.ParseComments)
+		sf.goSyntax = &goFile{fset, file, err}
+	})
+	return sf.goSyntax
+}
+
+// Returns the lines of the Go declaration with the given name and the first
+// line of the declaration itself. The doc comment of the declaration is only
+// included in the lines if withDoc is set.
+func findGoSymbol(gf *goFile, name string, withDoc bool) (LineRange, int, error) {
+	if gf.file == nil {
+		return LineRange{}, 0, gf.err
+	}
+	node, doc := lookupGoSymbol(gf.file, name)
+	if node == nil {
+		return LineRange{}, 0, errNoSymbol
+	}
+
+	declStart := gf.fset.Position(node.Pos()).Line
+	start := declStart
+	if withDoc && doc != nil {
+		start = gf.fset.Position(doc.Pos()).Line
+	}
+	return LineRange{start, gf.fset.Position(node.End()).Line}, declStart, nil
+}
+
+// Finds the declaration of a symbol and its doc comment. Methods are named
+// by their receiver type and their name, e.g., "Server.Handle".
+func lookupGoSymbol(file *ast.File, name string) (ast.Node, *ast.CommentGroup) {
+	receiver, method := "", name
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		receiver, method = name[:dot], name[dot+1:]
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Name.Name == method && goReceiverName(d) == receiver {
+				return d, d.Doc
+			}
+		case *ast.GenDecl:
+			if receiver != "" {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.Name != name {
+						continue
+					}
+					if d.Lparen.IsValid() {
+						return s, s.Doc
+					}
+					return d, d.Doc
+				case *ast.ValueSpec:
+					for _, ident := range s.Names {
+						if ident.Name == name {
+							return d, d.Doc
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Returns the name of the receiver type of a method without pointers and
+// type parameters, e.g., "Server" for "func (s *Server[T]) Handle()". Returns
+// an empty string for functions.
+func goReceiverName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	expr := fn.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
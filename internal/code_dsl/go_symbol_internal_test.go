@@ -0,0 +1,100 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+const goSymbolTestFile = `package server
+
+// MaxConns limits the connections.
+const (
+	MaxConns = 10
+	MinConns = 1
+)
+
+type (
+	// Handler handles requests.
+	Handler func()
+	Server[T any] struct {
+		conns int
+	}
+)
+
+// Handle serves a request.
+// It never fails.
+func (s *Server[T]) Handle() {
+	s.conns++
+}
+
+// Handle is not a method.
+func Handle() {}
+`
+
+func TestFindGoSymbol(t *testing.T) {
+	env := makeTestEnv("server.go", goSymbolTestFile)
+	sourceFile, err := env.Sources.Load("server.go")
+	if err != nil {
+		t.Fatal("Could not load test file:", err)
+	}
+
+	checks := []struct {
+		name    string
+		withDoc bool
+		lines   LineRange
+		decl    int
+	}{
+		{"Server.Handle", true, LineRange{17, 21}, 19},
+		{"Server.Handle", false, LineRange{19, 21}, 19},
+		{"Handle", true, LineRange{23, 24}, 24},
+		{"MinConns", true, LineRange{3, 7}, 4},
+		{"MinConns", false, LineRange{4, 7}, 4},
+		{"Handler", true, LineRange{10, 11}, 11},
+		{"Server", true, LineRange{12, 14}, 12},
+	}
+	for _, check := range checks {
+		lines, decl, err := findGoSymbol(sourceFile.goAST(), check.name, check.withDoc)
+		if err != nil || lines != check.lines || decl != check.decl {
+			t.Log("symbol: ", check.name, " doc: ", check.withDoc, " lines: ", lines, " declaration: ", decl, " err: ", err)
+			t.Error("Symbol was found at the wrong lines, expected ", check.lines, " and declaration ", check.decl)
+		}
+	}
+
+	for _, name := range []string{"Missing", "Handler.Handle", "Server.conns"} {
+		if _, _, err := findGoSymbol(sourceFile.goAST(), name, true); err != errNoSymbol {
+			t.Error("Missing symbol was found: ", name, " ", err)
+		}
+	}
+}
+
+func TestRenderInsertSymbol(t *testing.T) {
+	env := makeTestEnv("server.go", goSymbolTestFile)
+
+	transformed, diags := TransformLine("insert_symbol(server.go:Server.Handle)r{2}[doc=false]", env)
+	expected := "```go\nfunc (s *Server[T]) Handle() {\n*\ts.conns++\n}\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Symbol was not rendered with its relative highlights.")
+	}
+
+	// The doc comment is not counted by relative selections.
+	transformed, diags = TransformLine("insert_symbol(server.go:Server.Handle)r{2}", env)
+	expected = "```go\n// Handle serves a request.\n// It never fails.\nfunc (s *Server[T]) Handle() {\n*\ts.conns++\n}\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Relative highlight counted the doc comment.")
+	}
+
+	if _, diags := TransformLine("insert_symbol(server.go:Client)", env); len(diags) != 1 ||
+		!strings.Contains(diags[0].Msg, "could not find symbol Client in server.go") {
+		t.Log("diags: ", diags)
+		t.Error("Missing symbol was not reported.")
+	}
+
+	env = makeTestEnv("server.rb", "def main\nend\n")
+	if _, diags := TransformLine("insert_symbol(server.rb:main)", env); len(diags) != 1 ||
+		!strings.Contains(diags[0].Msg, "insert_symbol does not support server.rb") {
+		t.Log("diags: ", diags)
+		t.Error("insert_symbol on a file of an unsupported language was not reported.")
+	}
+}
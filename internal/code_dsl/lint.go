@@ -0,0 +1,164 @@
+package code_dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Lint
+//
+// Checks the code_block markers of all code roots and the rev_insert_code
+// commands that reference them. Markers are problematic if
+//  * their BlockID is defined more than once, in one or in several files,
+//  * their range could not be parsed or runs past the end of the file,
+//  * their range ends in the middle of a construct, i.e., the brackets of
+//    the marked lines are unbalanced,
+//  * no document references them.
+// Commands are problematic if the BlockID they reference does not exist.
+//===----------------------------------------------------------------------===//
+
+// Linter collects the blocks that documents reference, so the markers can be
+// checked for unused blocks afterwards.
+type Linter struct {
+	env *Env
+	// Used blocks by the path of their file and their BlockID
+	used map[blockKey]bool
+}
+
+type blockKey struct {
+	path string
+	id   string
+}
+
+func NewLinter(env *Env) *Linter {
+	return &Linter{env: env, used: map[blockKey]bool{}}
+}
+
+// Checks that the block a rev_insert_code command references exists and
+// records it as used. Other commands are not checked.
+func (l *Linter) CheckCommand(line string) Diagnostics {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return AsDiagnostics(err)
+	}
+	if cmd.Kind != RevInsertCodeCommand {
+		return nil
+	}
+
+	info, err := parseRevInsertCodeInfo(cmd, l.env)
+	if err != nil {
+		return AsDiagnostics(err)
+	}
+	sourceFile, err := l.env.Sources.Load(info.filename)
+	if err != nil {
+		return nil
+	}
+	l.used[blockKey{sourceFile.Source.Path(), cmd.Block.ID}] = true
+	return nil
+}
+
+// Checks all code_block markers in the code roots. Unused blocks are only
+// reported if reportUnused is set, i.e., if all documents were checked with
+// CheckCommand before.
+func (l *Linter) CheckMarkers(reportUnused bool) Diagnostics {
+	index, err := l.env.Sources.BlockIndex(l.env)
+	if err != nil {
+		return AsDiagnostics(err)
+	}
+
+	diags := Diagnostics{}
+	for _, file := range index.files() {
+		sourceFile, err := l.env.Sources.Load(file)
+		if err != nil {
+			diags = append(diags, NewDocumentError(file, err))
+			continue
+		}
+		path := sourceFile.Source.Path()
+		problem := func(severity Severity, line int, format string, args ...interface{}) {
+			diags = append(diags, &Diagnostic{Severity: severity, Document: path, Line: line, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		language := l.env.language(sourceFile.Name)
+		defined := map[string]int{}
+		for _, marker := range listCodeBlockMarkers(sourceFile.Lines, l.env.markerSyntaxes(sourceFile.Name)) {
+			if firstLine, found := defined[marker.id]; found {
+				problem(SeverityError, marker.line, "code_block %s is already defined in line %d", marker.id, firstLine)
+				continue
+			}
+			defined[marker.id] = marker.line
+
+			if locations := index.Lookup(marker.id); len(locations) > 1 {
+				others := []string{}
+				for _, location := range locations {
+					if location.Path != path {
+						others = append(others, fmt.Sprintf("%s:%d", location.Path, location.Line))
+					}
+				}
+				problem(SeverityError, marker.line, "code_block %s is also defined in %s", marker.id, strings.Join(others, ", "))
+			}
+			if marker.err != nil {
+				problem(SeverityError, marker.line, "%s", marker.err)
+				continue
+			}
+			if marker.lineRange.start < 1 || marker.lineRange.end > len(sourceFile.Lines) {
+				problem(SeverityError, marker.line, "range %s of code_block %s is outside of the %d lines of the file",
+					formatLineRange(marker.lineRange.start, marker.lineRange.end), marker.id, len(sourceFile.Lines))
+				continue
+			}
+			if unbalanced := unbalancedBrackets(sourceFile.Lines[marker.lineRange.start-1:marker.lineRange.end], l.env.commentSyntax(language)); unbalanced != "" {
+				problem(SeverityWarning, marker.line, "code_block %s ends in the middle of a construct, %s", marker.id, unbalanced)
+			}
+			if reportUnused && !l.used[blockKey{path, marker.id}] {
+				problem(SeverityWarning, marker.line, "code_block %s is not used by any document", marker.id)
+			}
+		}
+	}
+	return diags
+}
+
+// Returns the files that contain markers, in order.
+func (bi *BlockIndex) files() []string {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, locations := range bi.blocks {
+		for _, location := range locations {
+			if !seen[location.File] {
+				seen[location.File] = true
+				files = append(files, location.File)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+var closingBrackets = map[byte]byte{')': '(', ']': '[', '}': '{'}
+
+// Counts the brackets of the lines and describes the first one that is not
+// balanced, e.g., "1 '{' not closed". Returns an empty string if all
+// brackets are balanced. Brackets in strings and comments are not counted.
+func unbalancedBrackets(lines []string, comments CommentSyntax) string {
+	depth := map[byte]int{}
+	for _, line := range codeOnly(lines, comments) {
+		for idx := 0; idx < len(line); idx++ {
+			switch c := line[idx]; c {
+			case '(', '[', '{':
+				depth[c]++
+			case ')', ']', '}':
+				opening := closingBrackets[c]
+				if depth[opening] == 0 {
+					return fmt.Sprintf("'%c' closes a bracket that was opened before the block", c)
+				}
+				depth[opening]--
+			}
+		}
+	}
+	for _, opening := range []byte{'{', '(', '['} {
+		if depth[opening] > 0 {
+			return fmt.Sprintf("%d '%c' not closed", depth[opening], opening)
+		}
+	}
+	return ""
+}
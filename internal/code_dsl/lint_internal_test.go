@@ -0,0 +1,50 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUnbalancedBrackets(t *testing.T) {
+	if unbalanced := unbalancedBrackets([]string{"int foo(int a) {", "  return a[0];", "}"}, slashComments); unbalanced != "" {
+		t.Error("Balanced block was reported: ", unbalanced)
+	}
+	if unbalanced := unbalancedBrackets([]string{"int foo() {", "  if (a) {", "  }"}, slashComments); unbalanced != "1 '{' not closed" {
+		t.Error("Open brace was not reported: ", unbalanced)
+	}
+	if unbalanced := unbalancedBrackets([]string{"  return 0;", "}"}, slashComments); !strings.Contains(unbalanced, "'}'") {
+		t.Error("Brace closing a block before the marked lines was not reported: ", unbalanced)
+	}
+
+	inStringsAndComments := []string{`printf("{[(");`, "// closes a block }", "/* ) and", "   ] */ char c = '}';"}
+	if unbalanced := unbalancedBrackets(inStringsAndComments, slashComments); unbalanced != "" {
+		t.Error("Brackets in strings and comments were reported: ", unbalanced)
+	}
+	if unbalanced := unbalancedBrackets([]string{"def f(): # (", "    return '['"}, hashComments); unbalanced != "" {
+		t.Error("Brackets in Python strings and comments were reported: ", unbalanced)
+	}
+}
+
+func TestLinterReportsDuplicateMarkersInFile(t *testing.T) {
+	fsys := fstest.MapFS{"foo.cpp": &fstest.MapFile{Data: []byte(`// code_block(FooID:1-1)
+int foo;
+// code_block_begin(FooID)
+int bar;
+// code_block_end(FooID)
+`)}}
+	linter := NewLinter(&Env{Sources: NewSourceResolver(fsys)})
+
+	if diags := linter.CheckCommand("rev_insert_code(foo.cpp:FooID)"); len(diags) != 0 {
+		t.Error("Existing block was reported: ", diags)
+	}
+	if diags := linter.CheckCommand("rev_insert_code(foo.cpp:BarID)"); !diags.HasErrors() {
+		t.Error("Missing block was not reported.")
+	}
+
+	diags := linter.CheckMarkers(true)
+	if len(diags) != 1 || diags[0].Line != 3 || !strings.Contains(diags[0].Msg, "already defined in line 1") {
+		t.Log("diags: ", diags)
+		t.Error("Second FooID marker was not reported as duplicate.")
+	}
+}
@@ -0,0 +1,337 @@
+package code_dsl
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Migration
+//
+// Converts insert_code commands with numeric line ranges into rev_insert_code
+// commands. For every range, a code_block marker is inserted into the code
+// file right above the quoted lines, e.g.,
+//   insert_code(foo.cpp:4-17){5}   becomes   rev_insert_code(foo.cpp:foo_4_17)r{2}
+// and line 4 of foo.cpp is preceded by "// code_block(foo_4_17:1-14)".
+// Absolute highlights and visuals are translated into their relative forms.
+//
+// A marker inside of a quoted range would show up in the code and would move
+// the lines of offset based markers, so ranges that overlap other ranges are
+// not migrated. Every inserted marker shifts the lines below it, so a code
+// file that is still quoted by line ranges that could not be migrated must
+// not get markers, see Migration.Check.
+//===----------------------------------------------------------------------===//
+
+// Migration collects the markers that have to be inserted into code files
+// while the commands of one or more documents are migrated.
+type Migration struct {
+	env   *Env
+	files map[string]*migratedFile
+	order []string
+}
+
+type migratedFile struct {
+	source   *SourceFile
+	language string
+	comments CommentRegistry
+	markers  []plannedMarker
+	// Markers the file already has
+	existing map[string]codeBlockMarker
+	ids      map[string]bool
+	// Number of insert_code commands that quote the file by line numbers
+	// and were not migrated
+	unmigrated int
+}
+
+type plannedMarker struct {
+	id    string
+	start int
+	end   int
+}
+
+// MarkerInsertion is a marker line that is inserted into a code file.
+type MarkerInsertion struct {
+	// 1-based line of the current file the marker is inserted before
+	Line int
+	Text string
+}
+
+// MigratedFile is a code file that gets new markers.
+type MigratedFile struct {
+	// Path of the code file, including the folder of its code root
+	Path       string
+	Insertions []MarkerInsertion
+}
+
+func NewMigration(env *Env) *Migration {
+	return &Migration{env: env, files: map[string]*migratedFile{}}
+}
+
+// Converts the insert_code command of a line into a rev_insert_code command
+// and plans the marker for it. Other commands are returned unchanged.
+// Commands that cannot be migrated are returned unchanged together with a
+// diagnostic that explains why.
+func (m *Migration) MigrateCommand(line string) (string, Diagnostics) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return line, AsDiagnostics(err)
+	}
+	if cmd.Kind != InsertCodeCommand {
+		return line, nil
+	}
+
+	file, err := m.file(cmd.File.Path)
+	if err != nil {
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not read source file: %s", err)
+		d.Err = err
+		return line, Diagnostics{d}
+	}
+	start, end := cmd.Range.Start, cmd.Range.End
+	if end > len(file.source.Lines) {
+		end = len(file.source.Lines)
+	}
+	if start < 1 || start > end {
+		file.unmigrated++
+		return line, Diagnostics{newCommandWarning(cmd, cmd.Range.Pos, cmd.Range.EndPos,
+			"range is outside of %s and is not migrated", cmd.File.Path)}
+	}
+	markerLines := map[int]bool{}
+	syntaxes := m.env.markerSyntaxes(cmd.File.Path)
+	for lineNum := start; lineNum <= end; lineNum++ {
+		if isCodeBlockPairMarker(file.source.Lines[lineNum-1], syntaxes) {
+			markerLines[lineNum] = true
+		}
+	}
+	if d := checkRelativeSelections(cmd, start, end, markerLines); d != nil {
+		file.unmigrated++
+		return line, Diagnostics{d}
+	}
+
+	id, d := file.markerFor(cmd, start, end)
+	if d != nil {
+		file.unmigrated++
+		return line, Diagnostics{d}
+	}
+
+	makeSelectionsRelative(cmd, start, markerLines)
+	cmd.Kind = RevInsertCodeCommand
+	cmd.Range = nil
+	cmd.Block = &BlockRef{ID: id}
+	return cmd.String(), nil
+}
+
+// Loads a code file and its existing markers.
+func (m *Migration) file(filename string) (*migratedFile, error) {
+	sourceFile, err := m.env.Sources.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := sourceFile.Source.Path()
+	if file, found := m.files[key]; found {
+		return file, nil
+	}
+
+	file := &migratedFile{source: sourceFile, language: m.env.language(filename), comments: m.env.Comments,
+		existing: sourceFile.markers(m.env), ids: map[string]bool{}}
+	for id := range file.existing {
+		file.ids[id] = true
+	}
+	m.files[key] = file
+	m.order = append(m.order, key)
+	return file, nil
+}
+
+// Returns the BlockID for a range, reusing existing markers with the same
+// range, or plans a new marker.
+func (f *migratedFile) markerFor(cmd *Command, start int, end int) (string, *Diagnostic) {
+	for _, marker := range f.markers {
+		if marker.start == start && marker.end == end {
+			return marker.id, nil
+		}
+	}
+	for _, marker := range f.existing {
+		if marker.err == nil && marker.lineRange.start == start && marker.lineRange.end == end {
+			return marker.id, nil
+		}
+	}
+
+	if _, found := f.comments.Lookup(f.language); !found {
+		return "", newCommandWarning(cmd, cmd.File.Pos, cmd.File.End,
+			"no comment syntax is known for %s files, %s is not migrated", f.language, cmd.File.Path)
+	}
+	for _, marker := range f.markers {
+		if start <= marker.end && marker.start <= end {
+			return "", newCommandWarning(cmd, cmd.Range.Pos, cmd.Range.EndPos,
+				"range overlaps the range %d-%d of %s and is not migrated", marker.start, marker.end, marker.id)
+		}
+	}
+	for _, marker := range f.existing {
+		if marker.err == nil && marker.lineRange.start <= start && start <= marker.lineRange.end {
+			return "", newCommandWarning(cmd, cmd.Range.Pos, cmd.Range.EndPos,
+				"range starts inside of code_block %s and is not migrated", marker.id)
+		}
+	}
+
+	id := f.newID(cmd.File.Path, start, end)
+	f.markers = append(f.markers, plannedMarker{id, start, end})
+	return id, nil
+}
+
+var nonIDCharRgx = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Creates a BlockID that is not used in the file yet, e.g., "foo_4_17".
+func (f *migratedFile) newID(filename string, start int, end int) string {
+	stem := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	stem = nonIDCharRgx.ReplaceAllString(stem, "_")
+	if stem == "" || (stem[0] >= '0' && stem[0] <= '9') {
+		stem = "_" + stem
+	}
+
+	base := fmt.Sprintf("%s_%d_%d", stem, start, end)
+	id := base
+	for suffix := 2; f.ids[id]; suffix++ {
+		id = fmt.Sprintf("%s_%d", base, suffix)
+	}
+	f.ids[id] = true
+	return id
+}
+
+// Checks that all absolute selections are inside of the range and not on the
+// markers of code_block pairs, as they could not be expressed relative to it
+// otherwise.
+func checkRelativeSelections(cmd *Command, start int, end int, markerLines map[int]bool) *Diagnostic {
+	var outside *Diagnostic
+	check := func(sel Selector) {
+		lines := []int{}
+		switch s := sel.(type) {
+		case *LineSelector:
+			lines = append(lines, s.Line)
+		case *LineRangeSelector:
+			lines = append(lines, s.Start, s.End)
+		case *CharRangeSelector:
+			lines = append(lines, s.Line)
+		}
+		for _, lineNum := range lines {
+			if outside == nil && (lineNum < start || lineNum > end) {
+				outside = newCommandWarning(cmd, sel.Position(), sel.EndPosition(),
+					"line %d is outside of the inserted lines %d-%d, the command is not migrated", lineNum, start, end)
+			}
+			if outside == nil && markerLines[lineNum] {
+				outside = newCommandWarning(cmd, sel.Position(), sel.EndPosition(),
+					"line %d is a code_block marker, the command is not migrated", lineNum)
+			}
+		}
+	}
+	if cmd.Highlights != nil && !cmd.Highlights.Relative {
+		for _, sel := range cmd.Highlights.Items {
+			check(sel)
+		}
+	}
+	if cmd.Visuals != nil && !cmd.Visuals.Relative {
+		for _, item := range cmd.Visuals.Items {
+			check(item.Selector)
+		}
+	}
+	return outside
+}
+
+// Turns all absolute selections into selections relative to the first line
+// of the range. Relative lines do not count the markers of code_block pairs,
+// as they are not rendered.
+func makeSelectionsRelative(cmd *Command, start int, markerLines map[int]bool) {
+	toRelative := func(lineNum int) int {
+		relative := lineNum - start + 1
+		for markerLine := range markerLines {
+			if markerLine < lineNum {
+				relative--
+			}
+		}
+		return relative
+	}
+	convert := func(sel Selector) {
+		switch s := sel.(type) {
+		case *LineSelector:
+			s.Line = toRelative(s.Line)
+		case *LineRangeSelector:
+			s.Start, s.End = toRelative(s.Start), toRelative(s.End)
+		case *CharRangeSelector:
+			s.Line = toRelative(s.Line)
+		}
+	}
+	if cmd.Highlights != nil && !cmd.Highlights.Relative {
+		for _, sel := range cmd.Highlights.Items {
+			convert(sel)
+		}
+		cmd.Highlights.Relative = true
+	}
+	if cmd.Visuals != nil && !cmd.Visuals.Relative {
+		for _, item := range cmd.Visuals.Items {
+			convert(item.Selector)
+		}
+		cmd.Visuals.Relative = true
+	}
+}
+
+// Checks that no code file that gets new markers is still quoted by
+// insert_code commands that were not migrated, as the markers would shift the
+// lines these commands quote. Returns an error for every such file. Commands
+// of documents that are not part of the migration cannot be checked.
+func (m *Migration) Check() []error {
+	errs := []error{}
+	for _, key := range m.order {
+		file := m.files[key]
+		if len(file.markers) > 0 && file.unmigrated > 0 {
+			errs = append(errs, fmt.Errorf("%s would get code_block markers, but %d insert_code command(s) that quote it by line numbers could not be migrated "+
+				"and would quote other lines afterwards, fix or remove them and migrate again", key, file.unmigrated))
+		}
+	}
+	return errs
+}
+
+// Returns the code files that get new markers, in the order they were first
+// used.
+func (m *Migration) Files() []MigratedFile {
+	files := []MigratedFile{}
+	for _, key := range m.order {
+		file := m.files[key]
+		if len(file.markers) == 0 {
+			continue
+		}
+		syntax, _ := file.comments.Lookup(file.language)
+
+		markers := append([]plannedMarker{}, file.markers...)
+		sort.Slice(markers, func(i, j int) bool { return markers[i].start < markers[j].start })
+		migrated := MigratedFile{Path: key}
+		for _, marker := range markers {
+			firstLine := file.source.Lines[marker.start-1]
+			indent := firstLine[:len(firstLine)-len(strings.TrimLeft(firstLine, " \t"))]
+			text := indent + syntax.Comment(fmt.Sprintf("code_block(%s:1-%d)", marker.id, marker.end-marker.start+1))
+			migrated.Insertions = append(migrated.Insertions, MarkerInsertion{marker.start, text})
+		}
+		files = append(files, migrated)
+	}
+	return files
+}
+
+// Inserts the markers into the content of the code file. Line endings of the
+// file are kept, the markers use the line ending of the line they precede.
+func (f MigratedFile) Apply(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	var sb strings.Builder
+	next := 0
+	for idx, line := range lines {
+		for next < len(f.Insertions) && f.Insertions[next].Line == idx+1 {
+			ending := line[len(strings.TrimRight(line, "\r\n")):]
+			if ending == "" {
+				ending = "\n"
+			}
+			sb.WriteString(f.Insertions[next].Text + ending)
+			next++
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
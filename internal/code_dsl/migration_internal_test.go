@@ -0,0 +1,95 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const migrationTestCode = `package main
+
+func main() {
+	x := 1
+	println(x)
+}
+`
+
+func TestMigrateCommand(t *testing.T) {
+	env := &Env{Sources: NewSourceResolver(fstest.MapFS{"main.go": &fstest.MapFile{Data: []byte(migrationTestCode)}})}
+	migration := NewMigration(env)
+
+	migrated, diags := migration.MigrateCommand("insert_code(main.go:3-6)<d5>{4:{2-3}}[indent=2]")
+	if migrated != "rev_insert_code(main.go:main_3_6)r<d3>r{2:2-3}[indent=2]" || len(diags) != 0 {
+		t.Log("migrated: ", migrated, " diagnostics: ", diags)
+		t.Error("Command was wrongly migrated.")
+	}
+	if again, _ := migration.MigrateCommand("insert_code(main.go:3-6)"); again != "rev_insert_code(main.go:main_3_6)" {
+		t.Error("Marker for the same range was not reused: ", again)
+	}
+	if kept, diags := migration.MigrateCommand("insert_code(main.go:4-5)"); kept != "insert_code(main.go:4-5)" || len(diags) != 1 {
+		t.Log("diagnostics: ", diags)
+		t.Error("Overlapping range was migrated.")
+	}
+	if kept, diags := migration.MigrateCommand("insert_code(main.go:3-6){1}"); kept != "insert_code(main.go:3-6){1}" || len(diags) != 1 {
+		t.Log("diagnostics: ", diags)
+		t.Error("Highlight outside of the range was migrated.")
+	}
+
+	if errs := migration.Check(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "2 insert_code command(s)") {
+		t.Log("errors: ", errs)
+		t.Error("Commands that were not migrated did not block the markers of main.go.")
+	}
+
+	files := migration.Files()
+	if len(files) != 1 || len(files[0].Insertions) != 1 {
+		t.Fatal("Expected a single marker but got ", files)
+	}
+	expected := "package main\n\n// code_block(main_3_6:1-4)\nfunc main() {\n\tx := 1\n\tprintln(x)\n}\n"
+	if applied := files[0].Apply(migrationTestCode); applied != expected {
+		t.Logf("file:\n%s\nbut expected\n%s", applied, expected)
+		t.Error("Marker was wrongly inserted.")
+	}
+}
+
+func TestMigrateCommandSkipsPairMarkers(t *testing.T) {
+	code := "func main() {\n\t// code_block_begin(Body)\n\tx := 1\n\t// code_block_end(Body)\n\tprintln(x)\n}\n"
+	env := &Env{Sources: NewSourceResolver(fstest.MapFS{"main.go": &fstest.MapFile{Data: []byte(code)}})}
+	migration := NewMigration(env)
+
+	migrated, diags := migration.MigrateCommand("insert_code(main.go:1-6){3,5}")
+	if migrated != "rev_insert_code(main.go:main_1_6)r{2,3}" || len(diags) != 0 {
+		t.Log("migrated: ", migrated, " diagnostics: ", diags)
+		t.Error("Relative selections counted the code_block markers.")
+	}
+	if kept, diags := migration.MigrateCommand("insert_code(main.go:1-6){2}"); kept != "insert_code(main.go:1-6){2}" || len(diags) != 1 {
+		t.Log("diagnostics: ", diags)
+		t.Error("Highlight of a code_block marker was migrated.")
+	}
+}
+
+func TestMigrationCheck(t *testing.T) {
+	env := &Env{Sources: NewSourceResolver(fstest.MapFS{
+		"main.go":  &fstest.MapFile{Data: []byte(migrationTestCode)},
+		"other.go": &fstest.MapFile{Data: []byte(migrationTestCode)},
+	})}
+	migration := NewMigration(env)
+
+	migration.MigrateCommand("insert_code(main.go:3-4)")
+	migration.MigrateCommand("insert_code(main.go:5-6)")
+	if errs := migration.Check(); len(errs) != 0 {
+		t.Error("Fully migrated file was blocked: ", errs)
+	}
+
+	// A file without new markers keeps its lines, so its commands that were
+	// not migrated do no harm.
+	migration.MigrateCommand("insert_code(other.go:30-40)")
+	if errs := migration.Check(); len(errs) != 0 {
+		t.Error("File without markers was blocked: ", errs)
+	}
+
+	migration.MigrateCommand("insert_code(main.go:4-5)")
+	if errs := migration.Check(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "main.go") {
+		t.Log("errors: ", errs)
+		t.Error("Overlapping command did not block the markers of main.go.")
+	}
+}
@@ -0,0 +1,156 @@
+package code_dsl
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Sandbox
+//
+// Code ends up in published documents, so a DSL command must not be able to
+// read arbitrary files, e.g., "../../etc/passwd" or a symlink that points out
+// of the code tree. Filenames are therefore confined to the code roots, the
+// folders of the path aliases and an explicit allow-list of folders.
+//===----------------------------------------------------------------------===//
+
+// Reported if a filename or a symlink leads outside of the permitted folders.
+var ErrOutsideRoot error = &sandboxError{"path is outside of the code roots and allowed folders"}
+
+// Reported if a filename contains a symlink but symlinks are denied.
+var ErrSymlink error = &sandboxError{"path contains a symlink, which is not allowed"}
+
+type sandboxError struct {
+	msg string
+}
+
+func (e *sandboxError) Error() string {
+	return e.msg
+}
+
+// Paths that violate the sandbox are invalid paths for the fs package.
+func (e *sandboxError) Is(target error) bool {
+	return target == fs.ErrInvalid
+}
+
+// SymlinkPolicy decides which symlinks are followed when reading code files
+// from the OS file system.
+type SymlinkPolicy int
+
+const (
+	// Follow symlinks as long as their target is inside of a code root, an
+	// alias folder or an allowed folder.
+	SymlinksWithinRoots SymlinkPolicy = iota
+	// Follow all symlinks, wherever they point to.
+	SymlinksFollow
+	// Never read files through a symlink.
+	SymlinksDeny
+)
+
+// Checks if target is base or inside of base and returns the path of target
+// relative to base.
+func pathWithin(base string, target string) (string, bool) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// Returns the absolute path of a folder with all symlinks resolved. Folders
+// that do not exist are only made absolute.
+func realPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+// osRootFS reads files from a folder of the OS and applies the symlink
+// policy. Unlike os.DirFS, it does not follow symlinks out of the permitted
+// folders.
+type osRootFS struct {
+	dir    string
+	policy SymlinkPolicy
+	// Real paths of all folders that symlinks may point into
+	permitted []string
+}
+
+// Creates a file system for an OS folder. Permitted are the folders symlinks
+// may point into with SymlinksWithinRoots, the folder itself is always
+// permitted. Relative folders are resolved against the working directory, so
+// the real paths of files can be compared with the permitted folders.
+func NewOSRootFS(dir string, policy SymlinkPolicy, permitted []string) fs.FS {
+	dir = realPath(dir)
+	osFS := &osRootFS{dir: dir, policy: policy, permitted: []string{dir}}
+	for _, folder := range permitted {
+		osFS.permitted = append(osFS.permitted, realPath(folder))
+	}
+	return osFS
+}
+
+func (o *osRootFS) path(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(o.dir, filepath.FromSlash(name))
+	if err := o.checkSymlinks(name, full); err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return full, nil
+}
+
+func (o *osRootFS) Open(name string) (fs.File, error) {
+	full, err := o.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
+}
+
+func (o *osRootFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := o.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(full)
+}
+
+// Checks the symlinks on the way from the folder to the file. Files that do
+// not exist pass, opening them reports the error.
+func (o *osRootFS) checkSymlinks(name string, full string) error {
+	switch o.policy {
+	case SymlinksFollow:
+		return nil
+	case SymlinksDeny:
+		current := o.dir
+		for _, elem := range strings.Split(name, "/") {
+			current = filepath.Join(current, elem)
+			info, err := os.Lstat(current)
+			if err != nil {
+				return nil
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return ErrSymlink
+			}
+		}
+		return nil
+	default:
+		real, err := filepath.EvalSymlinks(full)
+		if err != nil {
+			return nil
+		}
+		for _, folder := range o.permitted {
+			if _, ok := pathWithin(folder, real); ok {
+				return nil
+			}
+		}
+		return ErrOutsideRoot
+	}
+}
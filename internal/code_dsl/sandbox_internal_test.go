@@ -0,0 +1,143 @@
+package code_dsl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates a code root with a file, a symlink that stays inside of the root
+// and a symlink that leaves it. Returns the code root and the outside folder.
+func makeSandboxTestTree(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	codeRoot := filepath.Join(dir, "code")
+	outside := filepath.Join(dir, "outside")
+	for _, folder := range []string{codeRoot, outside} {
+		if err := os.Mkdir(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(codeRoot, "foo.cpp"):   "int foo;\n",
+		filepath.Join(outside, "secret.txt"): "secret\n",
+		filepath.Join(outside, "shared.cpp"): "int shared;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(codeRoot, "foo.cpp"), filepath.Join(codeRoot, "inside.cpp")); err != nil {
+		t.Skip("Symlinks are not supported:", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(codeRoot, "leak.cpp")); err != nil {
+		t.Fatal(err)
+	}
+	return codeRoot, outside
+}
+
+func TestSandboxSymlinkPolicies(t *testing.T) {
+	codeRoot, outside := makeSandboxTestTree(t)
+
+	testCases := []struct {
+		policy    SymlinkPolicy
+		permitted []string
+		filename  string
+		expected  error
+	}{
+		{SymlinksWithinRoots, nil, "foo.cpp", nil},
+		{SymlinksWithinRoots, nil, "inside.cpp", nil},
+		{SymlinksWithinRoots, nil, "leak.cpp", ErrOutsideRoot},
+		{SymlinksWithinRoots, []string{outside}, "leak.cpp", nil},
+		{SymlinksFollow, nil, "leak.cpp", nil},
+		{SymlinksDeny, nil, "foo.cpp", nil},
+		{SymlinksDeny, nil, "inside.cpp", ErrSymlink},
+	}
+
+	for _, testCase := range testCases {
+		fsys := NewOSRootFS(codeRoot, testCase.policy, testCase.permitted)
+		file, err := fsys.Open(testCase.filename)
+		if err == nil {
+			file.Close()
+		}
+		if !errors.Is(err, testCase.expected) && !(err == nil && testCase.expected == nil) {
+			t.Errorf("Opening %s with policy %d returned %v but expected %v", testCase.filename, testCase.policy, err, testCase.expected)
+		}
+	}
+}
+
+func TestSandboxRelativeRoot(t *testing.T) {
+	codeRoot, _ := makeSandboxTestTree(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Dir(codeRoot)); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, dir := range []string{"code", "code/", "./code"} {
+		fsys := NewOSRootFS(dir, SymlinksWithinRoots, nil)
+		for _, filename := range []string{"foo.cpp", "inside.cpp"} {
+			file, err := fsys.Open(filename)
+			if err != nil {
+				t.Errorf("Opening %s in relative root %s failed: %v", filename, dir, err)
+				continue
+			}
+			file.Close()
+		}
+		if _, err := fsys.Open("leak.cpp"); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Opening leak.cpp in relative root %s returned %v but expected %v", dir, err, ErrOutsideRoot)
+		}
+	}
+}
+
+func TestSandboxRejectsPathsOutsideOfRoots(t *testing.T) {
+	codeRoot, outside := makeSandboxTestTree(t)
+	root := SourceRoot{Dir: codeRoot, FS: NewOSRootFS(codeRoot, SymlinksWithinRoots, nil)}
+	sources := NewMultiRootSourceResolver([]SourceRoot{root}, nil, nil)
+
+	for _, filename := range []string{"../outside/secret.txt", filepath.Join(outside, "secret.txt")} {
+		if _, err := sources.Load(filename); !errors.Is(err, ErrOutsideRoot) || !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Loading %s was not rejected but returned %v", filename, err)
+		}
+	}
+}
+
+func TestSandboxAllowList(t *testing.T) {
+	codeRoot, outside := makeSandboxTestTree(t)
+	root := SourceRoot{Dir: codeRoot, FS: NewOSRootFS(codeRoot, SymlinksWithinRoots, []string{outside})}
+	allowed := SourceRoot{Dir: outside, FS: NewOSRootFS(outside, SymlinksWithinRoots, []string{codeRoot})}
+	env := &Env{Sources: NewMultiRootSourceResolver([]SourceRoot{root}, nil, []SourceRoot{allowed})}
+
+	line, diags := TransformLine("insert_code(../outside/shared.cpp:1)", env)
+	if line != "```cpp\nint shared;\n```" || len(diags) != 0 {
+		t.Log("line: ", line, " diagnostics: ", diags)
+		t.Error("File in allowed folder was not read.")
+	}
+
+	dep, _ := GetFileDependency("insert_code(../outside/shared.cpp:1)", env)
+	if dep != filepath.Join(outside, "shared.cpp") {
+		t.Log("dependency: ", dep)
+		t.Error("Dependency in allowed folder was wrongly resolved.")
+	}
+}
+
+func TestSandboxViolationIsReportedAtFilename(t *testing.T) {
+	codeRoot, _ := makeSandboxTestTree(t)
+	root := SourceRoot{Dir: codeRoot, FS: NewOSRootFS(codeRoot, SymlinksWithinRoots, nil)}
+	env := &Env{Sources: NewMultiRootSourceResolver([]SourceRoot{root}, nil, nil)}
+
+	line := "insert_code(../../etc/passwd:1-3)"
+	transformed, diags := TransformLine(line, env)
+	if transformed != line || len(diags) != 1 || !diags.HasErrors() {
+		t.Fatal("Sandbox violation was not reported: ", diags)
+	}
+	if diags[0].Column != 13 || diags[0].Fragment != "../../etc/passwd" || !errors.Is(diags[0], ErrOutsideRoot) {
+		t.Log("diagnostic: ", diags[0])
+		t.Error("Sandbox violation does not point to the filename.")
+	}
+}
@@ -0,0 +1,87 @@
+package code_dsl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Snippets
+//
+// A snippet is the part of a code file a DSL command quotes. Its hash only
+// covers the quoted lines of the file, so it changes if the quoted code
+// changes but not if highlights or visuals of the command are edited.
+//===----------------------------------------------------------------------===//
+
+// Snippet describes the lines of a code file a DSL command quotes.
+type Snippet struct {
+	// Path of the code file, including the folder of its code root
+	File string
+	// 1-based first and last line of the snippet in the file
+	Start int
+	End   int
+	// Hash of the quoted lines, see HashLines
+	Hash string
+	// Fingerprints of the quoted lines, see FingerprintLines
+	Fingerprints []string
+}
+
+// Returns the hash of the given lines, prefixed with the name of the hash
+// function, e.g., "sha256:6b86...".
+func HashLines(lines []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Determines the snippet a DSL command quotes without rendering it. The end of
+// the snippet is clamped to the last line of the file. If the environment has
+// an anchor for the command, the snippet is relocated like for rendering.
+func GetSnippet(line string, env *Env) (Snippet, Diagnostics) {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		return Snippet{}, AsDiagnostics(err)
+	}
+
+	diags := Diagnostics{}
+	if relocated := relocateInsertCode(cmd, env); relocated != nil {
+		diags = append(diags, relocated)
+	}
+
+	var icInfo insertCodeInfo
+	switch cmd.Kind {
+	case RevInsertCodeCommand:
+		icInfo, err = parseRevInsertCodeInfo(cmd, env)
+	case InsertSymbolCommand:
+		icInfo, err = parseInsertSymbolInfo(cmd, env)
+	default:
+		icInfo, err = parserInsertCodeInfo(cmd)
+	}
+	if err != nil {
+		return Snippet{}, AsDiagnostics(err)
+	}
+
+	sourceFile, err := env.Sources.Load(icInfo.filename)
+	if err != nil {
+		d := newCommandError(cmd, cmd.File.Pos, cmd.File.End, "could not read source file: %s", err)
+		d.Err = err
+		return Snippet{}, append(diags, d)
+	}
+
+	lines := []string{}
+	start := icInfo.filerange.start
+	if start < 1 {
+		start = 1
+	}
+	for lineNumber := start; lineNumber <= icInfo.filerange.end && lineNumber <= len(sourceFile.Lines); lineNumber++ {
+		lines = append(lines, sourceFile.Lines[lineNumber-1])
+	}
+
+	return Snippet{
+		File:         sourceFile.Source.Path(),
+		Start:        start,
+		End:          start + len(lines) - 1,
+		Hash:         HashLines(lines),
+		Fingerprints: FingerprintLines(lines),
+	}, diags
+}
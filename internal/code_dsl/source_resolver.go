@@ -0,0 +1,262 @@
+package code_dsl
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SourceResolver opens the code files that are referenced in DSL commands.
+// Files are read through an fs.FS, so code can come from the OS file system,
+// an embed.FS or an in-memory file system.
+//
+// Filenames are searched in an ordered list of code roots. A filename can
+// also start with a path alias, e.g., "@sdk/client.go", to pick a root
+// explicitly. Filenames that leave the roots, e.g., "../secret.txt", are only
+// resolved if they point into an allowed folder, see sandbox.go.
+//
+// Loaded files are cached, so every file is only read once no matter how many
+// DSL commands reference it. The resolver is safe for concurrent use.
+type SourceResolver struct {
+	roots   []SourceRoot
+	aliases map[string]SourceRoot
+	// Folders outside of the roots that filenames like "../shared/foo.cpp"
+	// may point into
+	allowed []SourceRoot
+
+	mutex sync.Mutex
+	cache map[string]*cacheEntry
+	// Index of the code_block markers in all roots, see block_index.go
+	index         *blockIndexEntry
+	indexCacheDir string
+}
+
+// SourceRoot is a folder that contains code files.
+type SourceRoot struct {
+	// Path of the folder, used to report where a file was found. Empty if
+	// the files do not belong to a folder, e.g., for an in-memory file system.
+	Dir string
+	FS  fs.FS
+}
+
+type cacheEntry struct {
+	once sync.Once
+	file *SourceFile
+	err  error
+}
+
+// SourceFile is a code file that was loaded by the resolver.
+type SourceFile struct {
+	// Path of the file inside the file system of its code root
+	Name string
+	// The lines of the file without line endings
+	Lines []string
+	// Where the file was found, see ResolvedSource
+	Source ResolvedSource
+
+	markerOnce  sync.Once
+	markerIndex map[string]codeBlockMarker
+
+	fingerprintOnce  sync.Once
+	lineFingerprints []string
+
+	goOnce   sync.Once
+	goSyntax *goFile
+}
+
+// Returns the index of all code_block markers in the file, which is built on
+// first use. The languages and comment syntaxes of env tell which comments
+// can hold markers, all users of a resolver have to share them.
+func (sf *SourceFile) markers(env *Env) map[string]codeBlockMarker {
+	sf.markerOnce.Do(func() {
+		sf.markerIndex = scanCodeBlockMarkers(sf.Lines, env.markerSyntaxes(sf.Name))
+	})
+	return sf.markerIndex
+}
+
+// Returns the fingerprints of all lines of the file, which are computed on
+// first use.
+func (sf *SourceFile) fingerprints() []string {
+	sf.fingerprintOnce.Do(func() {
+		sf.lineFingerprints = FingerprintLines(sf.Lines)
+	})
+	return sf.lineFingerprints
+}
+
+// ResolvedSource describes which code file a filename of a DSL command
+// refers to.
+type ResolvedSource struct {
+	Root SourceRoot
+	// Path of the file inside the file system of the root
+	Name string
+	// Paths of files with the same name in later code roots, which are
+	// hidden by the file that was found first
+	Shadowed []string
+
+	// Identifies the root in the cache
+	rootKey string
+}
+
+// Returns the path of the file including the folder of its code root.
+func (rs ResolvedSource) Path() string {
+	return joinRootPath(rs.Root, rs.Name)
+}
+
+func joinRootPath(root SourceRoot, name string) string {
+	if root.Dir == "" {
+		return name
+	}
+	return filepath.Join(root.Dir, filepath.FromSlash(name))
+}
+
+// Creates a resolver that resolves filenames relative to the root of fsys.
+func NewSourceResolver(fsys fs.FS) *SourceResolver {
+	if fsys == nil {
+		return NewMultiRootSourceResolver(nil, nil, nil)
+	}
+	return NewMultiRootSourceResolver([]SourceRoot{{FS: fsys}}, nil, nil)
+}
+
+// Creates a resolver that searches filenames in the roots in the given order.
+// Aliases map the names used in "@alias/filename" to their roots. Allowed are
+// further folders that filenames may lead into, e.g., with "../".
+func NewMultiRootSourceResolver(roots []SourceRoot, aliases map[string]SourceRoot, allowed []SourceRoot) *SourceResolver {
+	return &SourceResolver{roots: roots, aliases: aliases, allowed: allowed, cache: make(map[string]*cacheEntry)}
+}
+
+// Resolves a filename that leaves the roots it is relative to. This is only
+// possible if it points into one of the allowed folders.
+func (sr *SourceResolver) resolveOutside(filename string, name string, bases []SourceRoot) (ResolvedSource, error) {
+	for _, base := range bases {
+		target := filepath.FromSlash(name)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(realPath(base.Dir), target)
+		}
+		for idx, allowed := range sr.allowed {
+			if rel, ok := pathWithin(realPath(allowed.Dir), target); ok {
+				return ResolvedSource{Root: allowed, Name: filepath.ToSlash(rel), rootKey: "allowed" + strconv.Itoa(idx)}, nil
+			}
+		}
+	}
+	return ResolvedSource{}, &fs.PathError{Op: "open", Path: filename, Err: ErrOutsideRoot}
+}
+
+// Finds the code file a filename of a DSL command refers to. If a file is
+// found in several code roots, the first one is used and the others are
+// listed as shadowed. If the file does not exist in any root, the error wraps
+// fs.ErrNotExist and the returned source is the path in the first root.
+func (sr *SourceResolver) Resolve(filename string) (ResolvedSource, error) {
+	if sr == nil || (len(sr.roots) == 0 && len(sr.aliases) == 0) {
+		return ResolvedSource{}, fmt.Errorf("no code root configured to open %s", filename)
+	}
+
+	if strings.HasPrefix(filename, "@") {
+		alias, rest, found := strings.Cut(filename[1:], "/")
+		root, known := sr.aliases[alias]
+		if !found || !known {
+			return ResolvedSource{}, fmt.Errorf("unknown path alias @%s in %s", alias, filename)
+		}
+		name := path.Clean(filepath.ToSlash(rest))
+		if !fs.ValidPath(name) {
+			return sr.resolveOutside(filename, name, []SourceRoot{root})
+		}
+		return ResolvedSource{Root: root, Name: name, rootKey: "@" + alias}, nil
+	}
+
+	if len(sr.roots) == 0 {
+		return ResolvedSource{}, fmt.Errorf("no code root configured to open %s, only path aliases", filename)
+	}
+	name := path.Clean(filepath.ToSlash(filename))
+	if !fs.ValidPath(name) {
+		return sr.resolveOutside(filename, name, sr.roots)
+	}
+	first := ResolvedSource{Root: sr.roots[0], Name: name, rootKey: "0"}
+	if len(sr.roots) == 1 {
+		// Nothing to choose from, errors are reported when the file is read.
+		return first, nil
+	}
+
+	found := []int{}
+	for idx, root := range sr.roots {
+		if _, err := fs.Stat(root.FS, name); err == nil {
+			found = append(found, idx)
+		}
+	}
+	if len(found) == 0 {
+		return first, &fs.PathError{Op: "open", Path: filename, Err: fmt.Errorf("not found in any code root: %w", fs.ErrNotExist)}
+	}
+
+	resolved := ResolvedSource{Root: sr.roots[found[0]], Name: name, rootKey: strconv.Itoa(found[0])}
+	for _, idx := range found[1:] {
+		resolved.Shadowed = append(resolved.Shadowed, joinRootPath(sr.roots[idx], name))
+	}
+	return resolved, nil
+}
+
+// Opens a source file by the name used in a DSL command.
+func (sr *SourceResolver) Open(filename string) (fs.File, error) {
+	resolved, err := sr.Resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.Root.FS.Open(resolved.Name)
+}
+
+// Loads a source file by the name used in a DSL command. Every file is only
+// read once, later calls return the cached file.
+func (sr *SourceResolver) Load(filename string) (*SourceFile, error) {
+	resolved, err := sr.Resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := resolved.rootKey + ":" + resolved.Name
+
+	sr.mutex.Lock()
+	entry, found := sr.cache[key]
+	if !found {
+		entry = &cacheEntry{}
+		sr.cache[key] = entry
+	}
+	sr.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.file, entry.err = readSourceFile(resolved)
+	})
+	return entry.file, entry.err
+}
+
+// Drops all cached files and the block index, so they are read again on the
+// next use.
+func (sr *SourceResolver) ClearCache() {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.cache = make(map[string]*cacheEntry)
+	sr.index = nil
+}
+
+// Maximum length of a single line in a code file
+const maxLineLength = 1024 * 1024
+
+func readSourceFile(resolved ResolvedSource) (*SourceFile, error) {
+	file, err := resolved.Root.FS.Open(resolved.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sourceFile := &SourceFile{Name: resolved.Name, Source: resolved}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineLength)
+	for scanner.Scan() {
+		sourceFile.Lines = append(sourceFile.Lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sourceFile, nil
+}
@@ -0,0 +1,202 @@
+package code_dsl
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSourceResolverOpen(t *testing.T) {
+	fsys := fstest.MapFS{"src/foo.cpp": &fstest.MapFile{Data: []byte("int main() {}\n")}}
+	sources := NewSourceResolver(fsys)
+
+	for _, filename := range []string{"src/foo.cpp", "./src/foo.cpp", "src/../src/foo.cpp"} {
+		file, err := sources.Open(filename)
+		if err != nil {
+			t.Errorf("Could not open %s: %s", filename, err)
+			continue
+		}
+		content, _ := io.ReadAll(file)
+		file.Close()
+		if string(content) != "int main() {}\n" {
+			t.Errorf("Read wrong content for %s", filename)
+		}
+	}
+}
+
+func TestSourceResolverRejectsInvalidPaths(t *testing.T) {
+	sources := NewSourceResolver(fstest.MapFS{})
+
+	for _, filename := range []string{"../foo.cpp", "/etc/passwd"} {
+		_, err := sources.Open(filename)
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Opening %s did not fail with an invalid path error but with %v", filename, err)
+		}
+	}
+}
+
+func TestSourceResolverWithoutFileSystem(t *testing.T) {
+	var sources *SourceResolver
+
+	if _, err := sources.Open("foo.cpp"); err == nil {
+		t.Error("Resolver without file system opened a file.")
+	}
+}
+
+// Counts how often each file was opened.
+type countingFS struct {
+	fstest.MapFS
+	opened map[string]int
+}
+
+func (cfs *countingFS) Open(name string) (fs.File, error) {
+	cfs.opened[name]++
+	return cfs.MapFS.Open(name)
+}
+
+func TestSourceResolverReadsFilesOnce(t *testing.T) {
+	fsys := &countingFS{fstest.MapFS{"foo.cpp": &fstest.MapFile{Data: []byte(`// code_block(BarID:1-2)
+void barFunc {
+}
+
+// code_block(FooID:1-1)
+int foo;
+`)}}, make(map[string]int)}
+	env := &Env{Sources: NewSourceResolver(fsys)}
+
+	for _, line := range []string{
+		"rev_insert_code(foo.cpp:FooID)",
+		"rev_insert_code(foo.cpp:BarID)",
+		"insert_code(foo.cpp:1-3)",
+		"insert_code(./foo.cpp:2)",
+	} {
+		if _, diags := TransformLine(line, env); len(diags) != 0 {
+			t.Error("Could not transform line:", diags)
+		}
+	}
+
+	if fsys.opened["foo.cpp"] != 1 {
+		t.Log("foo.cpp was opened", fsys.opened["foo.cpp"], "times")
+		t.Error("Source file was not cached.")
+	}
+
+	env.Sources.ClearCache()
+	TransformLine("insert_code(foo.cpp:1-3)", env)
+	if fsys.opened["foo.cpp"] != 2 {
+		t.Error("Source file was not read again after clearing the cache.")
+	}
+}
+
+func TestCodeBlockMarkerIndex(t *testing.T) {
+	markers := scanCodeBlockMarkers([]string{
+		"// code_block(BarID:1-2)",
+		"void barFunc {",
+		"}",
+		"// code_block(FooID:2-3)",
+		"// code_block(BarID:1-1)",
+		"// code_block(BrokenID:1)",
+	}, CommentRegistry(nil).markerSyntaxes("cpp"))
+
+	if len(markers) != 3 {
+		t.Fatal("Wrong number of markers found:", markers)
+	}
+	if bar := markers["BarID"]; bar.line != 1 || bar.lineRange != (LineRange{2, 3}) {
+		t.Log("BarID: ", bar)
+		t.Error("First BarID marker was not used.")
+	}
+	if foo := markers["FooID"]; foo.lineRange != (LineRange{6, 7}) {
+		t.Log("FooID: ", foo)
+		t.Error("FooID range was wrongly computed.")
+	}
+	if broken := markers["BrokenID"]; broken.err == nil {
+		t.Error("Marker without a valid range was not reported.")
+	}
+}
+
+func TestCodeBlockMarkersInComments(t *testing.T) {
+	python := scanCodeBlockMarkers([]string{
+		"# code_block(FooID:1-2)",
+		"marker = \"# code_block(StringID:1-1)\"",
+		"// code_block(SlashID:1-1)",
+		"x = 1  # code_block_begin(PairID)",
+		"y = 2",
+		"# code_block_end(PairID)",
+	}, CommentRegistry(nil).markerSyntaxes("python"))
+	if len(python) != 2 || python["FooID"].lineRange != (LineRange{2, 3}) || python["PairID"].lineRange != (LineRange{5, 5}) {
+		t.Log("markers: ", python)
+		t.Error("Markers in Python comments were wrongly found.")
+	}
+
+	cpp := scanCodeBlockMarkers([]string{
+		"const char *s = \"// code_block(StringID:1-1)\";",
+		"/* code_block(BlockID:2-2)",
+		"   code_block(NestedID:1-1) */",
+		"int x; /* code_block(InlineID:1-1) */ int y;",
+	}, CommentRegistry(nil).markerSyntaxes("cpp"))
+	if len(cpp) != 3 || cpp["BlockID"].lineRange != (LineRange{4, 4}) {
+		t.Log("markers: ", cpp)
+		t.Error("Markers in C++ block comments were wrongly found.")
+	}
+
+	sql := scanCodeBlockMarkers([]string{"SELECT 'it''s -- code_block(StringID:1-1)'; -- code_block(SqlID:1-1)"}, CommentRegistry(nil).markerSyntaxes("sql"))
+	if _, found := sql["SqlID"]; len(sql) != 1 || !found {
+		t.Log("markers: ", sql)
+		t.Error("Markers in SQL comments were wrongly found.")
+	}
+
+	sql = scanCodeBlockMarkers([]string{"/* code_block(BlockID:1-1) */", "SELECT 1;"}, CommentRegistry(nil).markerSyntaxes("sql"))
+	if sql["BlockID"].lineRange != (LineRange{2, 2}) {
+		t.Log("markers: ", sql)
+		t.Error("Markers in SQL block comments were not found.")
+	}
+
+	haskell := scanCodeBlockMarkers([]string{
+		"{- code_block(FooID:2-2)",
+		"   code_block(NestedID:1-1) -}",
+		"foo = \"{- code_block(StringID:1-1) -}\"",
+		"bar = 1 -- code_block(LineID:1-1)",
+	}, CommentRegistry(nil).markerSyntaxes("hs"))
+	if len(haskell) != 3 || haskell["FooID"].lineRange != (LineRange{3, 3}) {
+		t.Log("markers: ", haskell)
+		t.Error("Markers in Haskell comments were wrongly found.")
+	}
+}
+
+func TestSourceResolverSearchesRootsInOrder(t *testing.T) {
+	service := fstest.MapFS{"main.go": &fstest.MapFile{Data: []byte("service\n")}}
+	sdk := fstest.MapFS{
+		"main.go":   &fstest.MapFile{Data: []byte("sdk\n")},
+		"client.go": &fstest.MapFile{Data: []byte("client\n")},
+	}
+	sources := NewMultiRootSourceResolver(
+		[]SourceRoot{{Dir: "service", FS: service}, {Dir: "sdk", FS: sdk}},
+		map[string]SourceRoot{"sdk": {Dir: "sdk", FS: sdk}}, nil)
+
+	resolved, err := sources.Resolve("client.go")
+	if err != nil || resolved.Path() != filepath.Join("sdk", "client.go") || len(resolved.Shadowed) != 0 {
+		t.Log("resolved: ", resolved, " err: ", err)
+		t.Error("File was not found in the second root.")
+	}
+
+	resolved, err = sources.Resolve("main.go")
+	if err != nil || resolved.Path() != filepath.Join("service", "main.go") || len(resolved.Shadowed) != 1 {
+		t.Log("resolved: ", resolved, " err: ", err)
+		t.Error("Ambiguous file was not taken from the first root.")
+	}
+
+	file, err := sources.Load("@sdk/main.go")
+	if err != nil || file.Lines[0] != "sdk" {
+		t.Log("err: ", err)
+		t.Error("Alias did not select the sdk root.")
+	}
+
+	if _, err := sources.Resolve("missing.go"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Missing file was not reported: ", err)
+	}
+	if _, err := sources.Resolve("@examples/main.go"); err == nil {
+		t.Error("Unknown alias was not reported.")
+	}
+}
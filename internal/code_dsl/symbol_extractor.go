@@ -0,0 +1,341 @@
+package code_dsl
+
+import (
+	"regexp"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Symbols of other languages
+//
+// insert_symbol also finds functions and classes in C, C++, Java and Python
+// files, without parsing them:
+//  * In C-like languages, a function is a name followed by a parameter list
+//    and, after qualifiers like "const" or "throws E", by an opening brace.
+//    Classes, structs, enums, interfaces and namespaces are found by their
+//    keyword. The symbol ends at the matching closing brace.
+//  * In Python, the symbol is a "def" or "class" statement together with
+//    its decorators, and ends with the last line that is indented deeper.
+// Comments and string literals are blanked out before searching, so braces
+// and names inside of them do not confuse the search. Names of nested
+// symbols are separated by dots, e.g., "Server.handle" is the method handle
+// of the class Server. For C++, it also finds "Server::handle" definitions
+// outside of the class.
+//
+// Lines before the name that belong to the declaration, e.g., a return type
+// on its own line, "template <...>" or annotations, are part of the symbol.
+// The comment directly above the symbol is its doc comment.
+//===----------------------------------------------------------------------===//
+
+type symbolStyle int
+
+const (
+	braceSymbols symbolStyle = iota + 1
+	indentSymbols
+)
+
+// Languages the heuristic extractor knows how to find symbols in
+var symbolStyles = map[string]symbolStyle{
+	"c": braceSymbols, "h": braceSymbols, "cc": braceSymbols, "cpp": braceSymbols,
+	"cxx": braceSymbols, "hpp": braceSymbols, "hh": braceSymbols, "hxx": braceSymbols,
+	"java": braceSymbols,
+
+	"python": indentSymbols,
+}
+
+// Returns the lines of the function or class with the given name in the
+// lines of a file and the first line of the definition itself. The doc
+// comment of the symbol is only included in the lines if withDoc is set.
+func findHeuristicSymbol(lines []string, language string, comments CommentSyntax, name string, withDoc bool) (LineRange, int, error) {
+	code := codeOnly(lines, comments)
+
+	var decl LineRange
+	var found bool
+	switch symbolStyles[language] {
+	case braceSymbols:
+		decl, found = findBraceSymbol(code, strings.Split(name, "."))
+	case indentSymbols:
+		decl, found = findIndentSymbol(lines, code, strings.Split(name, "."))
+	}
+	if !found {
+		return LineRange{}, 0, errNoSymbol
+	}
+
+	declStart := decl.start
+	if withDoc {
+		// Comment lines are blank once the comments are blanked out.
+		for decl.start > 1 && strings.TrimSpace(code[decl.start-2]) == "" && strings.TrimSpace(lines[decl.start-2]) != "" {
+			decl.start--
+		}
+	}
+	return decl, declStart, nil
+}
+
+//===----------------------------------------------------------------------===//
+// C-like languages
+
+var typeKeywordRgx = regexp.MustCompile(`\b(class|struct|union|enum|interface|namespace|record)\s+([A-Za-z_]\w*\s+)*$`)
+
+// Matches the code before a name that uses the name instead of defining it,
+// e.g., a call in an expression or a member in an initializer list.
+var usageRgx = regexp.MustCompile(`([,(=.!?]|[^:]:|->|\b(return|new|throw|else))\s*$`)
+
+// Finds the symbol with the given nested names, searching every name inside
+// of the braces of the previous one. Falls back to C++ definitions like
+// "Server::handle" outside of the class.
+func findBraceSymbol(code []string, names []string) (LineRange, bool) {
+	text := strings.Join(code, "\n")
+	from, to := 0, len(text)
+	var start, end int
+	found := true
+	for _, name := range names {
+		if start, end, found = findBraceDefinition(text, name, "", from, to); !found {
+			break
+		}
+		from, to = start+len(name), end
+	}
+	if !found && len(names) > 1 {
+		start, end, found = findBraceDefinition(text, names[len(names)-1], names[len(names)-2], 0, len(text))
+	}
+	if !found {
+		return LineRange{}, false
+	}
+
+	startLine := strings.Count(text[:start], "\n")
+	return LineRange{declarationStart(code, startLine) + 1, strings.Count(text[:end], "\n") + 1}, true
+}
+
+// Finds the first definition of a function or type between two offsets of
+// the code. If qualifier is set, the name must be preceded by
+// "qualifier::". Returns the offsets of the name and of the closing brace of
+// the definition.
+func findBraceDefinition(text string, name string, qualifier string, from int, to int) (int, int, bool) {
+	nameRgx := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)
+	for _, match := range nameRgx.FindAllStringIndex(text[from:to], -1) {
+		start := from + match[0]
+		prefix := text[strings.LastIndexByte(text[:start], '\n')+1 : start]
+		if qualifier != "" && !strings.HasSuffix(strings.TrimRight(prefix, " \t"), qualifier+"::") {
+			continue
+		}
+		if usageRgx.MatchString(prefix) {
+			continue
+		}
+
+		var body int
+		var isDefinition bool
+		if typeKeywordRgx.MatchString(prefix) {
+			body, isDefinition = typeBody(text, from+match[1])
+		} else {
+			body, isDefinition = functionBody(text, from+match[1])
+		}
+		if !isDefinition {
+			continue
+		}
+		if end, closed := matchingBracket(text, body, '{', '}'); closed {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Checks if a type name is followed by a body, i.e., an opening brace comes
+// before the next ';'. Returns the offset of the brace.
+func typeBody(text string, offset int) (int, bool) {
+	for ; offset < len(text); offset++ {
+		switch text[offset] {
+		case '{':
+			return offset, true
+		case ';', '}', '(', ')', '=':
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// Checks if a name is followed by a parameter list and a function body, as
+// opposed to a call or a declaration. Between the parameters and the body,
+// qualifiers like "const", "-> T" or "throws E" and constructor initializer
+// lists, including members that are initialized with braces, are skipped.
+// Returns the offset of the opening brace of the body.
+func functionBody(text string, offset int) (int, bool) {
+	for offset < len(text) && strings.IndexByte(" \t\n", text[offset]) >= 0 {
+		offset++
+	}
+	if offset >= len(text) || text[offset] != '(' {
+		return 0, false
+	}
+	offset, ok := matchingBracket(text, offset, '(', ')')
+	if !ok {
+		return 0, false
+	}
+
+	initializers := false
+	for offset++; offset < len(text); offset++ {
+		switch c := text[offset]; {
+		case c == '{' && initializers && isIdentChar(text[offset-1]):
+			// Members that are initialized with braces, e.g., "b{2}"
+			if offset, ok = matchingBracket(text, offset, '{', '}'); !ok {
+				return 0, false
+			}
+		case c == '{':
+			return offset, true
+		case c == ':' && offset+1 < len(text) && text[offset+1] == ':':
+			offset++
+		case c == ':':
+			initializers = true
+		case c == '(' && (initializers || isIdentChar(text[offset-1])):
+			// Initializers of members or qualifiers like noexcept(true)
+			if offset, ok = matchingBracket(text, offset, '(', ')'); !ok {
+				return 0, false
+			}
+		case c == ';', c == ')', c == '}', c == '=' && !initializers:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// Returns the offset of the bracket that closes the bracket at offset.
+func matchingBracket(text string, offset int, opening byte, closing byte) (int, bool) {
+	depth := 0
+	for ; offset < len(text); offset++ {
+		switch text[offset] {
+		case opening:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return offset, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Returns the first line of the declaration whose name is in the given
+// 0-based line. Lines above that do not end a statement or block, e.g., a
+// return type, a template header or an annotation, belong to the
+// declaration.
+func declarationStart(code []string, line int) int {
+	for line > 0 {
+		previous := strings.TrimSpace(code[line-1])
+		if previous == "" || strings.HasPrefix(previous, "#") ||
+			strings.HasSuffix(previous, ";") || strings.HasSuffix(previous, "{") || strings.HasSuffix(previous, "}") ||
+			(strings.HasSuffix(previous, ":") && !strings.HasSuffix(previous, "::")) {
+			break
+		}
+		line--
+	}
+	return line
+}
+
+//===----------------------------------------------------------------------===//
+// Python
+
+var pythonDefRgx = regexp.MustCompile(`^(\s*)(?:async\s+def|def|class)\s+([A-Za-z_]\w*)\b`)
+
+// Finds the symbol with the given nested names, searching every name inside
+// of the block of the previous one.
+func findIndentSymbol(lines []string, code []string, names []string) (LineRange, bool) {
+	inString := pythonStringLines(lines)
+	from, to, parentIndent := 0, len(code)-1, -1
+	start, end := 0, 0
+	for _, name := range names {
+		found := false
+		for line := from; line <= to; line++ {
+			match := pythonDefRgx.FindStringSubmatch(code[line])
+			if match == nil || inString[line] || match[2] != name || len(match[1]) <= parentIndent {
+				continue
+			}
+			start, end = line, pythonBlockEnd(code, inString, line, len(match[1]))
+			from, to, parentIndent = line+1, end, len(match[1])
+			found = true
+			break
+		}
+		if !found {
+			return LineRange{}, false
+		}
+	}
+
+	// Decorators belong to the symbol.
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(code[start-1]), "@") {
+		start--
+	}
+	return LineRange{start + 1, end + 1}, true
+}
+
+// Returns the 0-based last line of the block of the def or class statement
+// in the given line, i.e., the last line that is indented deeper than the
+// statement. Blank lines, comments and lines inside of multi-line strings do
+// not end the block.
+func pythonBlockEnd(code []string, inString []bool, line int, indent int) int {
+	// The header ends with the ':' after the parameter list.
+	end, depth := line, 0
+	for ; end < len(code); end++ {
+		for _, c := range code[end] {
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth--
+			}
+		}
+		if depth <= 0 {
+			break
+		}
+	}
+	if end >= len(code) {
+		return len(code) - 1
+	}
+	if header := strings.TrimSpace(code[end]); !strings.HasSuffix(header, ":") {
+		// The body is on the same line, e.g., "def f(): return 1".
+		return end
+	}
+
+	for next := end + 1; next < len(code); next++ {
+		text := code[next]
+		if inString[next] || strings.TrimSpace(text) == "" {
+			continue
+		}
+		if len(text)-len(strings.TrimLeft(text, " \t")) <= indent {
+			break
+		}
+		end = next
+	}
+	return end
+}
+
+// Marks the lines that start inside of a multi-line string, i.e., a string
+// in triple quotes.
+func pythonStringLines(lines []string) []bool {
+	inString := make([]bool, len(lines))
+	open := ""
+	for idx, line := range lines {
+		inString[idx] = open != ""
+		for line != "" {
+			if open != "" {
+				end := strings.Index(line, open)
+				if end < 0 {
+					break
+				}
+				line, open = line[end+len(open):], ""
+				continue
+			}
+
+			start := strings.IndexAny(line, "#\"'")
+			if start < 0 || line[start] == '#' {
+				break
+			}
+			if quotes := strings.Repeat(line[start:start+1], 3); strings.HasPrefix(line[start:], quotes) {
+				line, open = line[start+3:], quotes
+				continue
+			}
+			end := stringLiteralEnd(line, start)
+			if end == start {
+				break
+			}
+			line = line[end:]
+		}
+	}
+	return inString
+}
@@ -0,0 +1,165 @@
+package code_dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+const cppSymbolTestFile = `#include <string>
+
+// Server serves requests.
+class Server {
+public:
+  Server(int port) : port_(port), name_("{") {}
+
+  // Handles a request.
+  int handle(const std::string &req) const {
+    if (req == "}") { // a brace }
+      return 1;
+    }
+    return 0;
+  }
+
+private:
+  int port_;
+  std::string name_;
+};
+
+int handle(int);
+
+/* Starts the server,
+   { never returns. */
+template <typename T>
+static void
+run(T server) noexcept(true)
+{
+  server.handle("");
+}
+
+int Server::stop() {
+  return port_;
+}
+
+struct Session {
+  Session();
+  int id_;
+  std::vector<int> ports_;
+};
+
+Session::Session() : id_(1), ports_{80, 443} {
+  connect();
+}
+`
+
+const javaSymbolTestFile = `package server;
+
+/**
+ * Server serves requests.
+ */
+public class Server {
+    private final String prefix = "class Client {";
+
+    @Override
+    public String toString() {
+        return prefix + '}';
+    }
+
+    public int handle(String req)
+            throws IOException {
+        handle(req.trim());
+        return 0;
+    }
+}
+`
+
+const pythonSymbolTestFile = `import functools
+
+# Server serves requests.
+class Server:
+    """A server.
+
+def fake():
+    """
+
+    @functools.cache
+    def handle(self, req: str,
+               timeout: int = 1) -> int:
+        if req == ")":
+            return 1
+
+        return 0
+
+    class Config: pass
+
+def run(server):
+    server.handle("")
+`
+
+func TestFindHeuristicSymbol(t *testing.T) {
+	testCases := []struct {
+		content  string
+		language string
+		name     string
+		withDoc  bool
+		expected LineRange
+		decl     int
+	}{
+		{cppSymbolTestFile, "cpp", "Server", true, LineRange{3, 19}, 4},
+		{cppSymbolTestFile, "cpp", "Server", false, LineRange{4, 19}, 4},
+		{cppSymbolTestFile, "cpp", "Server.Server", false, LineRange{6, 6}, 6},
+		{cppSymbolTestFile, "cpp", "Server.handle", true, LineRange{8, 14}, 9},
+		{cppSymbolTestFile, "cpp", "run", true, LineRange{23, 30}, 25},
+		{cppSymbolTestFile, "cpp", "run", false, LineRange{25, 30}, 25},
+		{cppSymbolTestFile, "cpp", "Server.stop", false, LineRange{32, 34}, 32},
+		{cppSymbolTestFile, "cpp", "Session", false, LineRange{36, 40}, 36},
+		{cppSymbolTestFile, "cpp", "Session.Session", false, LineRange{42, 44}, 42},
+		{javaSymbolTestFile, "java", "Server", true, LineRange{3, 19}, 6},
+		{javaSymbolTestFile, "java", "Server.toString", false, LineRange{9, 12}, 9},
+		{javaSymbolTestFile, "java", "handle", false, LineRange{14, 18}, 14},
+		{pythonSymbolTestFile, "python", "Server", true, LineRange{3, 18}, 4},
+		{pythonSymbolTestFile, "python", "Server.handle", false, LineRange{10, 16}, 10},
+		{pythonSymbolTestFile, "python", "Server.Config", false, LineRange{18, 18}, 18},
+		{pythonSymbolTestFile, "python", "run", false, LineRange{20, 21}, 20},
+	}
+
+	for _, tc := range testCases {
+		lines := strings.Split(tc.content, "\n")
+		comments, _ := commentSyntaxFor(tc.language)
+		lineRange, decl, err := findHeuristicSymbol(lines, tc.language, comments, tc.name, tc.withDoc)
+		if err != nil || lineRange != tc.expected || decl != tc.decl {
+			t.Log("symbol: ", tc.name, " range: ", lineRange, " declaration: ", decl, " err: ", err, " but expected ", tc.expected, " and ", tc.decl)
+			t.Error("Symbol was not found at the expected lines.")
+		}
+	}
+
+	for _, name := range []string{"Client", "fake", "port_", "Server.run"} {
+		lines := strings.Split(cppSymbolTestFile+pythonSymbolTestFile, "\n")
+		if _, _, err := findHeuristicSymbol(lines, "cpp", slashComments, name, true); err != errNoSymbol {
+			t.Error("Missing symbol was found: ", name, " ", err)
+		}
+	}
+}
+
+func TestRenderInsertHeuristicSymbol(t *testing.T) {
+	env := makeTestEnv("server.py", pythonSymbolTestFile)
+
+	transformed, diags := TransformLine("insert_symbol(server.py:run)r{2}", env)
+	expected := "```python\ndef run(server):\n*   server.handle(\"\")\n```"
+	if len(diags) != 0 || transformed != expected {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Python symbol was not rendered with its relative highlights.")
+	}
+
+	// The comment above the class is not counted by relative selections.
+	transformed, diags = TransformLine("insert_symbol(server.py:Server)r{1}", env)
+	if len(diags) != 0 || !strings.HasPrefix(transformed, "```python\n# Server serves requests.\n*class Server:\n") {
+		t.Log("transformed: ", transformed, " diags: ", diags)
+		t.Error("Relative highlight counted the comment of the symbol.")
+	}
+
+	if _, diags := TransformLine("insert_symbol(server.py:fake)", env); len(diags) != 1 ||
+		!strings.Contains(diags[0].Msg, "could not find symbol fake in server.py") {
+		t.Log("diags: ", diags)
+		t.Error("Missing symbol was not reported.")
+	}
+}
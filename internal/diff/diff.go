@@ -0,0 +1,221 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+//===----------------------------------------------------------------------===//
+// Diff
+//
+// Computes line based diffs with the algorithm of Myers ("An O(ND)
+// Difference Algorithm and Its Variations"), which finds a shortest edit
+// script, i.e., a longest common subsequence, in O((N+M)D) time. Documents
+// that are only slightly out of date therefore diff quickly even if they are
+// long.
+//===----------------------------------------------------------------------===//
+
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Delete
+	Insert
+)
+
+// Edit is a single line of an edit script. Lines are 0-based indices into
+// the old and the new lines. For inserted lines, OldLine is the index of the
+// old line the insertion happens before, and vice versa for deleted lines.
+type Edit struct {
+	Kind    OpKind
+	OldLine int
+	NewLine int
+	Text    string
+}
+
+// Returns the shortest edit script that turns the old lines into the new
+// lines.
+func Lines(oldLines []string, newLines []string) []Edit {
+	n, m := len(oldLines), len(newLines)
+	maxD := n + m
+	offset := maxD + 1
+	// v[offset+k] is the furthest x reached on diagonal k = x - y
+	v := make([]int, 2*maxD+3)
+	trace := [][]int{}
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && oldLines[x] == newLines[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(oldLines, newLines, trace, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// Walks the trace of the search backwards and collects the edits on the way.
+func backtrack(oldLines []string, newLines []string, trace [][]int, offset int) []Edit {
+	edits := []Edit{}
+	x, y := len(oldLines), len(newLines)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Equal, x, y, oldLines[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{Insert, x, prevY, newLines[prevY]})
+			} else {
+				edits = append(edits, Edit{Delete, prevX, y, oldLines[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Hunk is a group of changes together with their surrounding context lines.
+type Hunk struct {
+	// 0-based first line and number of lines of the hunk in the old and the
+	// new lines
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Edits    []Edit
+}
+
+// Groups the changes of an edit script into hunks with the given number of
+// context lines. Changes that are closer than twice the context end up in
+// the same hunk.
+func Hunks(edits []Edit, context int) []Hunk {
+	hunks := []Hunk{}
+	for idx := 0; idx < len(edits); {
+		if edits[idx].Kind == Equal {
+			idx++
+			continue
+		}
+
+		start := idx - context
+		if start < 0 {
+			start = 0
+		}
+		end := idx
+		for end < len(edits) {
+			if edits[end].Kind != Equal {
+				end++
+				continue
+			}
+			// Look for the next change within the context.
+			next := end
+			for next < len(edits) && edits[next].Kind == Equal && next-end < 2*context {
+				next++
+			}
+			if next < len(edits) && edits[next].Kind != Equal {
+				end = next
+				continue
+			}
+			break
+		}
+		last := end + context
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		hunks = append(hunks, makeHunk(edits[start:last]))
+		idx = last
+	}
+	return hunks
+}
+
+func makeHunk(edits []Edit) Hunk {
+	hunk := Hunk{OldStart: edits[0].OldLine, NewStart: edits[0].NewLine, Edits: edits}
+	for _, edit := range edits {
+		switch edit.Kind {
+		case Equal:
+			hunk.OldLines++
+			hunk.NewLines++
+		case Delete:
+			hunk.OldLines++
+		case Insert:
+			hunk.NewLines++
+		}
+	}
+	return hunk
+}
+
+// Returns the hunk in unified diff format, starting with the "@@" header.
+func (h Hunk) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", formatRange(h.OldStart, h.OldLines), formatRange(h.NewStart, h.NewLines)))
+	for _, edit := range h.Edits {
+		switch edit.Kind {
+		case Equal:
+			sb.WriteString(" ")
+		case Delete:
+			sb.WriteString("-")
+		case Insert:
+			sb.WriteString("+")
+		}
+		sb.WriteString(edit.Text + "\n")
+	}
+	return sb.String()
+}
+
+// Formats a range of a hunk header, which uses 1-based lines and refers to
+// the line before an empty range.
+func formatRange(start int, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if lines == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+// Returns the unified diff between the old and the new lines, or an empty
+// string if they are equal.
+func Unified(oldName string, newName string, oldLines []string, newLines []string, context int) string {
+	hunks := Hunks(Lines(oldLines, newLines), context)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("--- " + oldName + "\n")
+	sb.WriteString("+++ " + newName + "\n")
+	for _, hunk := range hunks {
+		sb.WriteString(hunk.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,71 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLinesFindsShortestEditScript(t *testing.T) {
+	oldLines := strings.Split("a b c a b b a", " ")
+	newLines := strings.Split("c b a b a c", " ")
+
+	edits := Lines(oldLines, newLines)
+	changes := 0
+	var gotOld, gotNew []string
+	for _, edit := range edits {
+		if edit.Kind != Equal {
+			changes++
+		}
+		if edit.Kind != Insert {
+			gotOld = append(gotOld, edit.Text)
+		}
+		if edit.Kind != Delete {
+			gotNew = append(gotNew, edit.Text)
+		}
+	}
+	if changes != 5 {
+		t.Error("Edit script is not the shortest one, changes: ", changes)
+	}
+	if strings.Join(gotOld, " ") != strings.Join(oldLines, " ") || strings.Join(gotNew, " ") != strings.Join(newLines, " ") {
+		t.Log("old: ", gotOld, " new: ", gotNew)
+		t.Error("Edit script does not reproduce both inputs.")
+	}
+}
+
+func TestUnified(t *testing.T) {
+	oldLines := strings.Split("1 2 3 4 5 6 7 8 9 10 11 12", " ")
+	newLines := strings.Split("1 2 3 4 five 6 7 8 9 10 11 12 13", " ")
+
+	expected := `--- old
++++ new
+@@ -3,5 +3,5 @@
+ 3
+ 4
+-5
++five
+ 6
+ 7
+@@ -11,2 +11,3 @@
+ 11
+ 12
++13
+`
+	if got := Unified("old", "new", oldLines, newLines, 2); got != expected {
+		t.Log("got:\n" + got)
+		t.Error("Unified diff was wrongly formatted.")
+	}
+	if got := Unified("old", "new", oldLines, oldLines, 2); got != "" {
+		t.Error("Equal lines produced a diff.")
+	}
+}
+
+func TestHunksMergeCloseChanges(t *testing.T) {
+	oldLines := strings.Split("1 2 3 4 5 6", " ")
+	newLines := strings.Split("1 x 3 4 y 6", " ")
+
+	hunks := Hunks(Lines(oldLines, newLines), 2)
+	if len(hunks) != 1 || hunks[0].OldStart != 0 || hunks[0].OldLines != 6 {
+		t.Log(hunks)
+		t.Error("Close changes were not merged into a single hunk.")
+	}
+}
@@ -2,63 +2,384 @@ package html_processor
 
 import (
 	"bufio"
-	"log"
-	"os"
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/vulder/remark_code_injector/internal/code_dsl"
 )
 
+// ErrorPolicy decides what happens when a DSL command could not be processed.
+type ErrorPolicy int
+
+const (
+	// Keep the DSL line as it is, report the error and continue with the
+	// rest of the document.
+	ContinueOnError ErrorPolicy = iota
+	// Stop processing the document at the first error.
+	StopOnError
+	// Like ContinueOnError, but errors are only reported as warnings.
+	WarnOnError
+)
+
+// Settings controls how documents are processed.
+type Settings struct {
+	ErrorPolicy ErrorPolicy
+	// Maximum number of DSL commands that are resolved concurrently. If not
+	// set, the number of CPUs is used.
+	Workers int
+}
+
+func (s Settings) workers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+	return runtime.NumCPU()
+}
+
 // Generates a new version of the HTML document, replacing all DSL annotations
-// with the generated content.
-func ProcessHTMLDocument(inputFilepath string, outputFilepath string, codeRoot string) {
-	file, err := os.Open(inputFilepath)
+// with the generated content. The document is read from r and the result is
+// written to w, the document name is only used for diagnostics. Problems with
+// DSL commands are returned as diagnostics, the returned error is only set if
+// reading or writing failed, the context was canceled, or the error policy
+// stopped the processing.
+//
+// DSL commands are resolved concurrently, but the output is always the same
+// as if the document was processed line by line.
+func ProcessHTMLDocument(ctx context.Context, document string, r io.Reader, w io.Writer, env *code_dsl.Env, settings Settings) (code_dsl.Diagnostics, error) {
+	rendered, diags, renderErr := RenderHTMLDocument(ctx, document, r, env, settings)
+	if rendered == nil {
+		return diags, renderErr
+	}
+
+	writer := bufio.NewWriter(w)
+	sep := ""
+	for _, line := range rendered {
+		if _, err := writer.WriteString(sep + line.Output); err != nil {
+			return diags, err
+		}
+		sep = "\n"
+	}
+	if err := writer.Flush(); err != nil {
+		return diags, err
+	}
+	return diags, renderErr
+}
+
+// RenderedLine is a line of a document together with the output it was
+// transformed into. The output of a DSL command spans several lines, all
+// other lines are copied as they are.
+type RenderedLine struct {
+	// 1-based line in the document
+	Line    int
+	Source  string
+	Output  string
+	Command bool
+}
+
+// Transforms a document like ProcessHTMLDocument, but returns the output of
+// every line separately, so callers can tell which line produced which part
+// of the output. Joining the outputs with newlines gives the output of
+// ProcessHTMLDocument. If the error policy stopped the processing, the lines
+// before the failing DSL command are returned together with the error.
+func RenderHTMLDocument(ctx context.Context, document string, r io.Reader, env *code_dsl.Env, settings Settings) ([]RenderedLine, code_dsl.Diagnostics, error) {
+	lines, err := readLines(r)
 	if err != nil {
-		log.Fatal("Could not open HTML document: ", err)
+		return nil, nil, err
 	}
-	defer file.Close()
-	outputFile, err := os.Create(outputFilepath)
 
+	results, err := transformLines(ctx, lines, env, settings.workers())
 	if err != nil {
-		log.Fatal("Could not create output file: ", err)
+		return nil, nil, err
 	}
-	defer outputFile.Close()
 
-	scanner := bufio.NewScanner(file)
-	sep := ""
+	diags := code_dsl.Diagnostics{}
+	rendered := make([]RenderedLine, 0, len(results))
+	for idx, result := range results {
+		lineDiags := applyErrorPolicy(result.diags.InDocument(document, idx+1), settings.ErrorPolicy)
+		diags = append(diags, lineDiags...)
+		if settings.ErrorPolicy == StopOnError && lineDiags.HasErrors() {
+			return rendered, diags, lineDiags.Errors()[0]
+		}
+		rendered = append(rendered, RenderedLine{
+			Line:    idx + 1,
+			Source:  lines[idx],
+			Output:  result.line,
+			Command: code_dsl.ContainsDSLCommand(lines[idx]),
+		})
+	}
+	return rendered, diags, nil
+}
+
+// Computes the list of all files used in DSL commands of a document. Marker
+// comments of in-place documents count as DSL commands.
+func FindDependencies(document string, r io.Reader, env *code_dsl.Env) ([]string, code_dsl.Diagnostics, error) {
+	diags := code_dsl.Diagnostics{}
+	dependencies := []string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 1
 	for scanner.Scan() {
-		outputFile.WriteString(sep + handleHTMLLine(scanner.Text(), codeRoot))
-		sep = "\n"
+		if text, offset, ok := commandOfLine(scanner.Text()); ok {
+			dep, lineDiags := code_dsl.GetFileDependency(text, env)
+			diags = append(diags, shiftColumns(lineDiags.InDocument(document, lineNumber), offset)...)
+			if dep != "" {
+				dependencies = append(dependencies, dep)
+			}
+		}
+		lineNumber++
+	}
+
+	return dependencies, diags, scanner.Err()
+}
+
+// SnippetLine is the snippet a DSL command of a document quotes.
+type SnippetLine struct {
+	// 1-based line in the document
+	Line int
+	// The DSL command, without the comment of a marker
+	Command string
+	Snippet code_dsl.Snippet
+}
+
+// Determines the snippets all DSL commands of a document quote. Commands whose
+// snippet could not be determined are only reported as diagnostics.
+func FindSnippets(document string, r io.Reader, env *code_dsl.Env) ([]SnippetLine, code_dsl.Diagnostics, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, nil, err
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Could not scan HTML docuemnt: ", err)
+
+	diags := code_dsl.Diagnostics{}
+	snippets := []SnippetLine{}
+	for idx, line := range lines {
+		text, offset, ok := commandOfLine(line)
+		if !ok {
+			continue
+		}
+		snippet, lineDiags := code_dsl.GetSnippet(text, env)
+		diags = append(diags, shiftColumns(lineDiags.InDocument(document, idx+1), offset)...)
+		if !lineDiags.HasErrors() {
+			snippets = append(snippets, SnippetLine{idx + 1, text, snippet})
+		}
 	}
+	return snippets, diags, nil
 }
 
-// Computes a ';' separated string of all files used in DSL commands.
-func FindDependencies(filepath string, codeRoot string) string {
-	file, err := os.Open(filepath)
+// CommandLine is a DSL command together with the line of the document it was
+// found in.
+type CommandLine struct {
+	// 1-based line in the document
+	Line    int
+	Command *code_dsl.Command
+}
+
+// Finds all DSL commands of a document, including the marker comments of
+// in-place documents. Lines that look like a DSL command but could not be
+// parsed are reported as diagnostics.
+func FindCommands(document string, r io.Reader) ([]CommandLine, code_dsl.Diagnostics, error) {
+	diags := code_dsl.Diagnostics{}
+	commands := []CommandLine{}
+	lines, err := readLines(r)
 	if err != nil {
-		log.Fatal("Could not open HTML document: ", err)
+		return nil, nil, err
 	}
-	defer file.Close()
+	for idx, line := range lines {
+		text, offset, ok := commandOfLine(line)
+		if !ok {
+			continue
+		}
+		cmd, err := code_dsl.ParseCommand(text)
+		if err != nil {
+			diags = append(diags, shiftColumns(code_dsl.AsDiagnostics(err).InDocument(document, idx+1), offset)...)
+			continue
+		}
+		commands = append(commands, CommandLine{idx + 1, cmd})
+	}
+	return commands, diags, nil
+}
 
-	dependencies := ""
-	scanner := bufio.NewScanner(file)
-	sep := ""
+// Rewrites all DSL commands of a document into their canonical form. All other
+// lines, including line endings, are copied unchanged. Commands that could not
+// be parsed are kept as they are and reported as diagnostics.
+func FormatHTMLDocument(document string, r io.Reader, w io.Writer) (code_dsl.Diagnostics, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	diags := code_dsl.Diagnostics{}
+	lines := strings.SplitAfter(string(content), "\n")
+	for idx, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		if command, offset, ok := commandOfLine(text); ok {
+			formatted, err := code_dsl.FormatCommand(command)
+			if err != nil {
+				diags = append(diags, shiftColumns(code_dsl.AsDiagnostics(err).InDocument(document, idx+1), offset)...)
+			}
+			if offset > 0 {
+				formatted = makeMarker(formatted)
+			}
+			line = formatted + line[len(text):]
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return diags, err
+		}
+	}
+	return diags, nil
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		dep := code_dsl.GetFileDependency(scanner.Text(), codeRoot)
-		if dep != "" {
-			dependencies += sep + dep
-			sep = ";"
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+type lineResult struct {
+	line  string
+	diags code_dsl.Diagnostics
+}
+
+// Transforms all lines of a document, resolving the DSL commands on a pool of
+// workers. The results are in the same order as the lines.
+func transformLines(ctx context.Context, lines []string, env *code_dsl.Env, workers int) ([]lineResult, error) {
+	results := make([]lineResult, len(lines))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				line, diags := code_dsl.TransformLine(lines[idx], env)
+				results[idx] = lineResult{line, diags}
+			}
+		}()
+	}
+
+	for idx, line := range lines {
+		if !code_dsl.ContainsDSLCommand(line) {
+			results[idx] = lineResult{line, nil}
+			continue
+		}
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
+	close(jobs)
+	wg.Wait()
 
-	return dependencies
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
-func handleHTMLLine(line string, codeRoot string) string {
-	if code_dsl.ContainsDSLCommand(line) {
-		return code_dsl.TransformLine(line, codeRoot)
+func applyErrorPolicy(diags code_dsl.Diagnostics, policy ErrorPolicy) code_dsl.Diagnostics {
+	if policy == WarnOnError {
+		for _, d := range diags {
+			d.Severity = code_dsl.SeverityWarning
+		}
+	}
+	return diags
+}
+
+// Relocation is an insert_code command whose line range was moved to where
+// its quoted code is now.
+type Relocation struct {
+	// 1-based line in the document
+	Line int
+	// The command before and after the move
+	Old string
+	New string
+	// Number of lines the range was moved by
+	Delta int
+}
+
+// Rewrites every insert_code command whose quoted code moved to other lines,
+// as found with the anchors of the environment, and copies all other lines
+// unchanged. Each moved command is reported as a warning.
+func FixRanges(document string, r io.Reader, w io.Writer, env *code_dsl.Env) ([]Relocation, code_dsl.Diagnostics, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diags := code_dsl.Diagnostics{}
+	relocations := []Relocation{}
+	lines := strings.SplitAfter(string(content), "\n")
+	for idx, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		if command, offset, ok := commandOfLine(text); ok {
+			relocated, delta, lineDiags := code_dsl.RelocateCommand(command, env)
+			diags = append(diags, shiftColumns(lineDiags.InDocument(document, idx+1), offset)...)
+			if delta != 0 {
+				relocations = append(relocations, Relocation{idx + 1, command, relocated, delta})
+				if offset > 0 {
+					relocated = makeMarker(relocated)
+				}
+				line = relocated + line[len(text):]
+			}
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return relocations, diags, err
+		}
+	}
+	return relocations, diags, nil
+}
+
+// Migrates all insert_code commands of a document into rev_insert_code
+// commands, see code_dsl.Migration. The markers for the code files are
+// collected in the migration, all other lines are copied unchanged.
+func MigrateHTMLDocument(document string, r io.Reader, w io.Writer, migration *code_dsl.Migration) (code_dsl.Diagnostics, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	diags := code_dsl.Diagnostics{}
+	lines := strings.SplitAfter(string(content), "\n")
+	for idx, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		if command, offset, ok := commandOfLine(text); ok {
+			migrated, lineDiags := migration.MigrateCommand(command)
+			diags = append(diags, shiftColumns(lineDiags.InDocument(document, idx+1), offset)...)
+			if offset > 0 {
+				migrated = makeMarker(migrated)
+			}
+			line = migrated + line[len(text):]
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return diags, err
+		}
+	}
+	return diags, nil
+}
+
+// Checks the rev_insert_code commands of a document with the linter and
+// records the blocks they use.
+func LintHTMLDocument(document string, r io.Reader, linter *code_dsl.Linter) (code_dsl.Diagnostics, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	diags := code_dsl.Diagnostics{}
+	for idx, line := range lines {
+		if text, offset, ok := commandOfLine(line); ok {
+			diags = append(diags, shiftColumns(linter.CheckCommand(text).InDocument(document, idx+1), offset)...)
+		}
 	}
-	return line
+	return diags, nil
 }
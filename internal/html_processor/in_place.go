@@ -0,0 +1,194 @@
+package html_processor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/vulder/remark_code_injector/internal/code_dsl"
+)
+
+//===----------------------------------------------------------------------===//
+// In-place documents
+//
+// An in-place document keeps every DSL command as a hidden marker comment and
+// the generated code right below it, enclosed by a pair of fences:
+//
+//   <!-- insert_code(foo.cpp:1-3) -->
+//   <!-- begin generated code -->
+//   ```cpp
+//   ...
+//   ```
+//   <!-- end generated code -->
+//
+// Processing the document again only replaces the lines between the fences,
+// everything else can be edited by hand. Plain DSL lines are turned into
+// markers, so the first run converts a raw document into an in-place one.
+// HTML comments are hidden by browsers and by remark, so the markers never
+// show up on the slides.
+//===----------------------------------------------------------------------===//
+
+const (
+	markerPrefix = "<!-- "
+	markerSuffix = " -->"
+	// First line of the generated code of a marker
+	BeginFence = "<!-- begin generated code -->"
+	// Last line of the generated code of a marker
+	EndFence = "<!-- end generated code -->"
+)
+
+// Returns the DSL command of a line, which is either a plain DSL line or a
+// marker comment, and the offset of the command in the line.
+func commandOfLine(line string) (string, int, bool) {
+	if code_dsl.ContainsDSLCommand(line) {
+		return line, 0, true
+	}
+	if len(line) > len(markerPrefix)+len(markerSuffix) &&
+		strings.HasPrefix(line, markerPrefix) && strings.HasSuffix(line, markerSuffix) {
+		text := line[len(markerPrefix) : len(line)-len(markerSuffix)]
+		if code_dsl.ContainsDSLCommand(text) {
+			return text, len(markerPrefix), true
+		}
+	}
+	return "", 0, false
+}
+
+// Wraps a DSL command into a marker comment.
+func makeMarker(command string) string {
+	return markerPrefix + command + markerSuffix
+}
+
+func isFence(line string, fence string) bool {
+	return strings.TrimSpace(line) == fence
+}
+
+// Moves diagnostics of a command by the offset of the command in its line.
+func shiftColumns(diags code_dsl.Diagnostics, offset int) code_dsl.Diagnostics {
+	for _, d := range diags {
+		if d.Column > 0 {
+			d.Column += offset
+		}
+	}
+	return diags
+}
+
+type inPlaceCommand struct {
+	// 0-based index of the line with the command
+	line   int
+	text   string
+	offset int
+	// 0-based index of the end fence of the generated code, -1 if the
+	// command has no generated code yet
+	end int
+}
+
+// Finds the commands of an in-place document and the generated code that
+// belongs to them. Lines inside of generated code are never treated as
+// commands.
+func findInPlaceCommands(document string, lines []string) ([]inPlaceCommand, code_dsl.Diagnostics) {
+	commands := []inPlaceCommand{}
+	diags := code_dsl.Diagnostics{}
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		if isFence(line, BeginFence) || isFence(line, EndFence) {
+			diags = append(diags, &code_dsl.Diagnostic{
+				Severity: code_dsl.SeverityWarning, Document: document, Line: idx + 1, Column: 1,
+				Fragment: line, Msg: "fence does not belong to a DSL marker and is kept as it is",
+			})
+			continue
+		}
+		text, offset, ok := commandOfLine(line)
+		if !ok {
+			continue
+		}
+
+		cmd := inPlaceCommand{line: idx, text: text, offset: offset, end: -1}
+		if idx+1 < len(lines) && isFence(lines[idx+1], BeginFence) {
+			for end := idx + 2; end < len(lines); end++ {
+				if isFence(lines[end], EndFence) {
+					cmd.end = end
+					break
+				}
+			}
+			if cmd.end < 0 {
+				diags = append(diags, &code_dsl.Diagnostic{
+					Severity: code_dsl.SeverityError, Document: document, Line: idx + 2, Column: 1,
+					Fragment: lines[idx+1], Msg: "generated code is not closed by \"" + EndFence + "\"",
+				})
+				// Without the end fence, the rest of the document is kept.
+				break
+			}
+		}
+		commands = append(commands, cmd)
+		if cmd.end >= 0 {
+			idx = cmd.end
+		}
+	}
+	return commands, diags
+}
+
+// Regenerates an in-place document. Every DSL command is kept as a marker
+// comment and only the generated code between its fences is replaced, so the
+// result of processing a document twice is the same as processing it once.
+// If a command could not be processed, its marker and the previously
+// generated code are kept unchanged. Errors are returned like for
+// ProcessHTMLDocument, but if the error policy stopped the processing nothing
+// is written to w.
+func ProcessInPlace(ctx context.Context, document string, r io.Reader, w io.Writer, env *code_dsl.Env, settings Settings) (code_dsl.Diagnostics, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(strings.NewReader(string(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	commands, diags := findInPlaceCommands(document, lines)
+	diags = applyErrorPolicy(diags, settings.ErrorPolicy)
+	if settings.ErrorPolicy == StopOnError && diags.HasErrors() {
+		return diags, diags.Errors()[0]
+	}
+
+	texts := make([]string, len(commands))
+	for idx, cmd := range commands {
+		texts[idx] = cmd.text
+	}
+	results, err := transformLines(ctx, texts, env, settings.workers())
+	if err != nil {
+		return nil, err
+	}
+
+	output := []string{}
+	next := 0
+	for idx, cmd := range commands {
+		output = append(output, lines[next:cmd.line]...)
+		next = cmd.line + 1
+		if cmd.end >= 0 {
+			next = cmd.end + 1
+		}
+
+		result := results[idx]
+		failed := result.diags.HasErrors()
+		cmdDiags := applyErrorPolicy(shiftColumns(result.diags.InDocument(document, cmd.line+1), cmd.offset), settings.ErrorPolicy)
+		diags = append(diags, cmdDiags...)
+		if settings.ErrorPolicy == StopOnError && failed {
+			return diags, cmdDiags.Errors()[0]
+		}
+
+		if failed {
+			output = append(output, lines[cmd.line:next]...)
+		} else {
+			output = append(output, makeMarker(cmd.text), BeginFence, result.line, EndFence)
+		}
+	}
+	output = append(output, lines[next:]...)
+
+	writer := bufio.NewWriter(w)
+	writer.WriteString(strings.Join(output, "\n"))
+	if strings.HasSuffix(string(content), "\n") {
+		writer.WriteString("\n")
+	}
+	return diags, writer.Flush()
+}
@@ -0,0 +1,25 @@
+package remark_code_injector
+
+import (
+	"io"
+
+	"github.com/vulder/remark_code_injector/internal/code_dsl"
+	"github.com/vulder/remark_code_injector/internal/html_processor"
+)
+
+// Linter checks the code_block markers of the code roots and the
+// rev_insert_code commands that reference them.
+type Linter = code_dsl.Linter
+
+// Starts a lint of the code roots of this processor.
+func (p *Processor) NewLinter() *Linter {
+	return code_dsl.NewLinter(p.env)
+}
+
+// Reports rev_insert_code commands of the document read from r that
+// reference blocks which do not exist. The blocks the document uses are
+// recorded in the linter, so Linter.CheckMarkers can report unused blocks
+// once all documents were checked.
+func (p *Processor) Lint(document string, r io.Reader, linter *Linter) (Diagnostics, error) {
+	return html_processor.LintHTMLDocument(document, r, linter)
+}
@@ -0,0 +1,273 @@
+package remark_code_injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vulder/remark_code_injector/internal/code_dsl"
+	"github.com/vulder/remark_code_injector/internal/html_processor"
+)
+
+//===----------------------------------------------------------------------===//
+// Lock files
+//
+// A lock file records, for every DSL command of a document, which lines of
+// which code file it quotes and a hash of these lines, e.g.,
+//
+//	{
+//	  "version": 1,
+//	  "snippets": [
+//	    {
+//	      "line": 12,
+//	      "command": "insert_code(foo.cpp:4-17)",
+//	      "file": "code/foo.cpp",
+//	      "start": 4,
+//	      "end": 17,
+//	      "hash": "sha256:...",
+//	      "lines": ["5e1b3c...", ...]
+//	    }
+//	  ]
+//	}
+//
+// Comparing the lock with the current snippets tells which slides quote code
+// that changed since the lock was written, even if the output was already
+// regenerated. Files are relative to the folder of the document. The
+// fingerprints of the single lines let a processor relocate insert_code
+// commands whose code moved to other lines, see Processor.WithLock.
+//===----------------------------------------------------------------------===//
+
+// Version of the lock file format that is written.
+const LockVersion = 1
+
+// Suffix that is appended to the document path to get its lock file.
+const LockFileSuffix = ".snippets.lock"
+
+// Returns the path of the lock file of a document.
+func LockFile(document string) string {
+	return document + LockFileSuffix
+}
+
+// Lock holds the snippets of a document at the time the lock was written.
+type Lock struct {
+	Version  int             `json:"version"`
+	Snippets []LockedSnippet `json:"snippets"`
+}
+
+// LockedSnippet is the snippet of a single DSL command.
+type LockedSnippet struct {
+	// 1-based line of the command in the document
+	Line int `json:"line"`
+	// The DSL command, commands are matched by this text
+	Command string `json:"command"`
+	// Path of the code file, relative to the folder of the document
+	File  string `json:"file"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Hash  string `json:"hash"`
+	// Fingerprints of the quoted lines
+	Fingerprints []string `json:"lines,omitempty"`
+}
+
+// Determines the current snippets of all DSL commands in the document read
+// from r. Commands whose snippet could not be determined, e.g., because the
+// code file is missing, are reported as diagnostics and left out.
+func (p *Processor) Lock(document string, r io.Reader) (*Lock, Diagnostics, error) {
+	snippets, diags, err := html_processor.FindSnippets(document, r, p.env)
+	if err != nil {
+		return nil, diags, err
+	}
+
+	lock := &Lock{Version: LockVersion, Snippets: []LockedSnippet{}}
+	for _, s := range snippets {
+		lock.Snippets = append(lock.Snippets, LockedSnippet{
+			Line:         s.Line,
+			Command:      s.Command,
+			File:         relativeToDocument(document, s.Snippet.File),
+			Start:        s.Snippet.Start,
+			End:          s.Snippet.End,
+			Hash:         s.Snippet.Hash,
+			Fingerprints: s.Snippet.Fingerprints,
+		})
+	}
+	return lock, diags, nil
+}
+
+// Makes a path relative to the folder of the document, so lock files do not
+// depend on where the project is checked out.
+func relativeToDocument(document string, path string) string {
+	absDir, err := filepath.Abs(filepath.Dir(document))
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Reads a lock file.
+func ReadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("%s: invalid lock file: %w", path, err)
+	}
+	if lock.Version != LockVersion {
+		return nil, fmt.Errorf("%s: unsupported lock file version %d", path, lock.Version)
+	}
+	return lock, nil
+}
+
+// Writes the lock as indented JSON. The file is replaced atomically, so
+// concurrent runs and crashes never leave a partially written lock.
+func (l *Lock) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing fails after a successful rename, which is fine.
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type SnippetChangeKind int
+
+const (
+	// The quoted code differs from the locked one.
+	SnippetChanged SnippetChangeKind = iota
+	// The command is not in the lock.
+	SnippetAdded
+	// The locked command is not in the document anymore.
+	SnippetRemoved
+	// The quoted code is unchanged but was found at other lines.
+	SnippetMoved
+)
+
+// SnippetChange describes how a snippet differs from the lock. Locked is nil
+// for added snippets and Current is nil for removed ones.
+type SnippetChange struct {
+	Kind    SnippetChangeKind
+	Locked  *LockedSnippet
+	Current *LockedSnippet
+}
+
+// Compares the current snippets of a document with the lock. Snippets are
+// matched by their command, so moving a command within the document is not a
+// change. If a command is used several times, the occurrences are matched in
+// order. Snippets with the same hash but other lines have moved, which is
+// only reported if the command was relocated with the fingerprints of the
+// lock.
+func (l *Lock) Compare(current *Lock) []SnippetChange {
+	locked := map[string][]*LockedSnippet{}
+	for idx := range l.Snippets {
+		s := &l.Snippets[idx]
+		locked[s.Command] = append(locked[s.Command], s)
+	}
+
+	changes := []SnippetChange{}
+	for idx := range current.Snippets {
+		s := &current.Snippets[idx]
+		candidates := locked[s.Command]
+		if len(candidates) == 0 {
+			changes = append(changes, SnippetChange{SnippetAdded, nil, s})
+			continue
+		}
+		old := candidates[0]
+		locked[s.Command] = candidates[1:]
+		if old.Hash != s.Hash {
+			changes = append(changes, SnippetChange{SnippetChanged, old, s})
+		} else if old.Start != s.Start {
+			changes = append(changes, SnippetChange{SnippetMoved, old, s})
+		}
+	}
+
+	for idx := range l.Snippets {
+		s := &l.Snippets[idx]
+		for _, remaining := range locked[s.Command] {
+			if remaining == s {
+				changes = append(changes, SnippetChange{SnippetRemoved, s, nil})
+			}
+		}
+	}
+	return changes
+}
+
+// Returns the anchors of all insert_code commands of the lock.
+func (l *Lock) anchors() map[string]code_dsl.Anchor {
+	anchors := map[string]code_dsl.Anchor{}
+	for _, s := range l.Snippets {
+		if _, found := anchors[s.Command]; !found && len(s.Fingerprints) > 0 {
+			anchors[s.Command] = code_dsl.Anchor{Start: s.Start, End: s.End, Fingerprints: s.Fingerprints}
+		}
+	}
+	return anchors
+}
+
+// Returns a processor that relocates insert_code commands with the lock of a
+// document. If the code a command quoted when the lock was written moved to
+// other lines, the command uses the new lines and a warning with the new
+// range is reported. The returned processor shares the cache with p.
+func (p *Processor) WithLock(lock *Lock) *Processor {
+	if lock == nil {
+		return p
+	}
+	return &Processor{options: p.options, env: p.env.WithAnchors(lock.anchors())}
+}
+
+// Rewrites the line ranges of insert_code commands in the document read from
+// r whose code moved since the lock was written, see WithLock. The result is
+// written to w. Every moved command is reported as a warning and listed in
+// the returned relocations.
+func (p *Processor) FixRanges(document string, r io.Reader, w io.Writer) ([]Relocation, Diagnostics, error) {
+	return html_processor.FixRanges(document, r, w, p.env)
+}
+
+// Relocation is an insert_code command that was moved to other lines.
+type Relocation = html_processor.Relocation
+
+// Updates the commands of the locked snippets after the document was fixed,
+// so the lock matches the rewritten commands. The hashes are kept.
+func (l *Lock) Relocate(relocations []Relocation) {
+	for _, relocation := range relocations {
+		for idx := range l.Snippets {
+			s := &l.Snippets[idx]
+			if s.Command != relocation.Old {
+				continue
+			}
+			s.Command = relocation.New
+			s.Start += relocation.Delta
+			s.End += relocation.Delta
+			break
+		}
+	}
+}
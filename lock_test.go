@@ -0,0 +1,76 @@
+package remark_code_injector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProcessorLock(t *testing.T) {
+	document := "# Slide\ninsert_code(foo.cpp:2-5){1}\n<!-- insert_code(foo.cpp:1) -->"
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code"})
+
+	lock, diags, err := processor.Lock("slides.md", strings.NewReader(document))
+	if err != nil || len(diags) != 0 || len(lock.Snippets) != 2 {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Fatal("Snippets were not found.")
+	}
+
+	first := lock.Snippets[0]
+	if first.Line != 2 || first.Command != "insert_code(foo.cpp:2-5){1}" || first.File != "code/foo.cpp" || first.Start != 2 || first.End != 3 {
+		t.Logf("snippet: %+v", first)
+		t.Error("Snippet was wrongly recorded, the range must be clamped to the file.")
+	}
+	if second := lock.Snippets[1]; second.Line != 3 || second.Hash == first.Hash {
+		t.Logf("snippet: %+v", second)
+		t.Error("Snippet of a marker comment was wrongly recorded.")
+	}
+}
+
+func TestLockCompare(t *testing.T) {
+	codeFS := fstest.MapFS{"foo.cpp": &fstest.MapFile{Data: []byte("a\nb\nc\n")}}
+	document := "insert_code(foo.cpp:1)\ninsert_code(foo.cpp:2)\ninsert_code(foo.cpp:3)"
+	locked, _, _ := makeTestProcessor(t, Options{FS: codeFS}).Lock("slides.md", strings.NewReader(document))
+
+	codeFS["foo.cpp"] = &fstest.MapFile{Data: []byte("a\nB\nc\n")}
+	edited := "# New slide\ninsert_code(foo.cpp:1)\ninsert_code(foo.cpp:2)\ninsert_code(foo.cpp:1-2)"
+	current, _, _ := makeTestProcessor(t, Options{FS: codeFS}).Lock("slides.md", strings.NewReader(edited))
+
+	changes := locked.Compare(current)
+	if len(changes) != 3 {
+		t.Fatal("Expected 3 changes but got ", len(changes))
+	}
+	if changes[0].Kind != SnippetChanged || changes[0].Current.Line != 3 || changes[0].Locked.Line != 2 {
+		t.Error("Changed code was not reported.")
+	}
+	if changes[1].Kind != SnippetAdded || changes[1].Current.Command != "insert_code(foo.cpp:1-2)" {
+		t.Error("Added command was not reported.")
+	}
+	if changes[2].Kind != SnippetRemoved || changes[2].Locked.Command != "insert_code(foo.cpp:3)" {
+		t.Error("Removed command was not reported.")
+	}
+}
+
+func TestLockWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slides.md"+LockFileSuffix)
+	if err := os.WriteFile(path, []byte("{\"version\": 1, \"snippets\": [{\"command\": \"old\"}]}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := &Lock{Version: LockVersion, Snippets: []LockedSnippet{{Line: 2, Command: "insert_code(foo.cpp:1)", File: "code/foo.cpp", Start: 1, End: 1}}}
+	if err := lock.Write(path); err != nil {
+		t.Fatal("Lock was not written: ", err)
+	}
+	read, err := ReadLock(path)
+	if err != nil || len(read.Snippets) != 1 || read.Snippets[0].Command != "insert_code(foo.cpp:1)" {
+		t.Log("lock: ", read, " err: ", err)
+		t.Error("Written lock was not read back.")
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Error("Temporary files were left next to the lock: ", entries)
+	}
+}
@@ -0,0 +1,33 @@
+package remark_code_injector
+
+import (
+	"io"
+
+	"github.com/vulder/remark_code_injector/internal/code_dsl"
+	"github.com/vulder/remark_code_injector/internal/html_processor"
+)
+
+type (
+	// Migration collects the code_block markers that are needed to turn the
+	// insert_code commands of documents into rev_insert_code commands.
+	Migration = code_dsl.Migration
+	// MigratedFile is a code file together with the markers it gets.
+	MigratedFile = code_dsl.MigratedFile
+	// MarkerInsertion is a single marker line of a MigratedFile.
+	MarkerInsertion = code_dsl.MarkerInsertion
+)
+
+// Starts a migration for documents of this processor.
+func (p *Processor) NewMigration() *Migration {
+	return code_dsl.NewMigration(p.env)
+}
+
+// Rewrites the insert_code commands of the document read from r into
+// rev_insert_code commands and writes the result to w. Absolute highlights
+// and visuals become relative ones. The markers the new commands refer to are
+// collected in the migration, which can be shared by several documents, and
+// have to be written into the code files with MigratedFile.Apply. Commands
+// that cannot be migrated are kept and reported as warnings.
+func (p *Processor) Migrate(document string, r io.Reader, w io.Writer, migration *Migration) (Diagnostics, error) {
+	return html_processor.MigrateHTMLDocument(document, r, w, migration)
+}
@@ -0,0 +1,290 @@
+package remark_code_injector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+
+	"github.com/vulder/remark_code_injector/internal/code_dsl"
+	"github.com/vulder/remark_code_injector/internal/html_processor"
+)
+
+type (
+	// Diagnostic describes a problem found while processing a document.
+	Diagnostic = code_dsl.Diagnostic
+	// Diagnostics is a list of diagnostics that can be used as an error.
+	Diagnostics = code_dsl.Diagnostics
+	Severity    = code_dsl.Severity
+	// CodeGenOptions controls how code is generated. DSL commands can
+	// override them with their own options, e.g., "[indent=2]".
+	CodeGenOptions = code_dsl.CodeGenOptionsImpl
+	// ErrorPolicy decides what happens when a DSL command could not be
+	// processed.
+	ErrorPolicy = html_processor.ErrorPolicy
+	// Command is the parsed form of a DSL command.
+	Command = code_dsl.Command
+	// CommandLine is a DSL command together with its line in the document.
+	CommandLine = html_processor.CommandLine
+	// RenderedLine is a line of a document together with its output.
+	RenderedLine = html_processor.RenderedLine
+	// SymlinkPolicy decides which symlinks are followed when reading code
+	// files.
+	SymlinkPolicy = code_dsl.SymlinkPolicy
+	// CommentSyntax describes how comments are written in a language.
+	CommentSyntax = code_dsl.CommentSyntax
+	// ErrCheckMode decides how error checks in Go code are rendered.
+	ErrCheckMode = code_dsl.ErrCheckMode
+)
+
+const (
+	SeverityError   = code_dsl.SeverityError
+	SeverityWarning = code_dsl.SeverityWarning
+)
+
+const (
+	// Follow symlinks that point into a code root, alias or allowed folder.
+	SymlinksWithinRoots = code_dsl.SymlinksWithinRoots
+	// Follow all symlinks.
+	SymlinksFollow = code_dsl.SymlinksFollow
+	// Never read code files through symlinks.
+	SymlinksDeny = code_dsl.SymlinksDeny
+)
+
+const (
+	// Render "if err != nil" checks of Go code as they are.
+	KeepErrChecks = code_dsl.KeepErrChecks
+	// Replace every error check with a single comment line.
+	FoldErrChecks = code_dsl.FoldErrChecks
+	// Leave out error checks.
+	RemoveErrChecks = code_dsl.RemoveErrChecks
+)
+
+// Parses the name of an error check mode, i.e., keep, fold or remove.
+func ParseErrCheckMode(name string) (ErrCheckMode, error) {
+	return code_dsl.ParseErrCheckMode(name)
+}
+
+// Reported for code files outside of the code roots and allowed folders.
+var ErrOutsideRoot = code_dsl.ErrOutsideRoot
+
+const (
+	// Keep the DSL line, report the error and continue with the document.
+	ContinueOnError = html_processor.ContinueOnError
+	// Stop processing the document at the first error.
+	StopOnError = html_processor.StopOnError
+	// Keep the DSL line and only report errors as warnings.
+	WarnOnError = html_processor.WarnOnError
+)
+
+// Options configures a Processor.
+type Options struct {
+	// Folder that contains the code files referenced in DSL commands. If FS
+	// is set, the folder is relative to the root of FS.
+	CodeRoot string
+	// Further folders that are searched, in order, for code files that are
+	// not in CodeRoot. A file that exists in several roots is taken from the
+	// first one and reported with a warning.
+	CodeRoots []string
+	// Maps alias names to folders, so DSL commands can reference a file
+	// with "@alias/filename" regardless of the code roots.
+	Aliases map[string]string
+	// Folders outside of the code roots that DSL commands may read from,
+	// e.g., with "../shared/foo.cpp". Files outside of the code roots, the
+	// alias folders and the allowed folders are never read.
+	AllowedDirs []string
+	// Decides which symlinks are followed, by default only those that stay
+	// inside of the permitted folders.
+	SymlinkPolicy SymlinkPolicy
+	// File system the code files are read from, e.g., an embed.FS. If nil,
+	// the OS file system is used.
+	FS fs.FS
+	// Options used for all DSL commands unless a command overrides them
+	CodeGenOptions CodeGenOptions
+	ErrorPolicy    ErrorPolicy
+	// Maximum number of DSL commands of a document that are resolved
+	// concurrently. If not set, the number of CPUs is used.
+	Workers int
+	// Maps file extensions without the dot to the language of the generated
+	// code blocks, e.g., "tpp" to "cpp".
+	Languages map[string]string
+	// Maps languages of the generated code blocks to their comment syntax,
+	// which is used for the placeholders of hidden code and for the markers
+	// that migrations write. Languages that are not listed use the built-in
+	// table.
+	Comments map[string]CommentSyntax
+	// Folder the index of the code_block markers in the code roots is
+	// cached in between runs, so rev_insert_code commands without a
+	// filename do not have to scan the roots every time. Empty disables the
+	// cache. It is not used if FS is set.
+	IndexCacheDir string
+}
+
+// Processor replaces DSL commands in documents with the code they reference.
+// Code files are cached, so each file is read only once per Processor, even
+// when it is used by several documents. Call ClearCache to pick up changes.
+type Processor struct {
+	options Options
+	env     *code_dsl.Env
+}
+
+func NewProcessor(options Options) (*Processor, error) {
+	sources, err := makeSourceResolver(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Processor{
+		options: options,
+		env: &code_dsl.Env{
+			Sources:   sources,
+			Defaults:  options.CodeGenOptions,
+			Languages: options.Languages,
+			Comments:  options.Comments,
+		},
+	}, nil
+}
+
+// Creates the resolver that searches the code roots, aliases and allowed
+// folders of the options.
+func makeSourceResolver(options Options) (*code_dsl.SourceResolver, error) {
+	dirs := options.CodeRoots
+	if options.CodeRoot != "" || len(dirs) == 0 {
+		dirs = append([]string{options.CodeRoot}, dirs...)
+	}
+
+	// Symlinks may point into every folder the options name explicitly.
+	permitted := append([]string{}, dirs...)
+	for _, dir := range options.Aliases {
+		permitted = append(permitted, dir)
+	}
+	permitted = append(permitted, options.AllowedDirs...)
+	makeRoot := func(dir string) (code_dsl.SourceRoot, error) {
+		return makeSourceRoot(options.FS, dir, options.SymlinkPolicy, permitted)
+	}
+
+	roots := []code_dsl.SourceRoot{}
+	for _, dir := range dirs {
+		root, err := makeRoot(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid code root %q: %w", dir, err)
+		}
+		roots = append(roots, root)
+	}
+
+	aliases := map[string]code_dsl.SourceRoot{}
+	for alias, dir := range options.Aliases {
+		root, err := makeRoot(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid folder %q for alias @%s: %w", dir, alias, err)
+		}
+		aliases[alias] = root
+	}
+
+	allowed := []code_dsl.SourceRoot{}
+	for _, dir := range options.AllowedDirs {
+		root, err := makeRoot(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed folder %q: %w", dir, err)
+		}
+		allowed = append(allowed, root)
+	}
+	sources := code_dsl.NewMultiRootSourceResolver(roots, aliases, allowed)
+	if options.FS == nil && options.IndexCacheDir != "" {
+		sources.SetIndexCache(options.IndexCacheDir)
+	}
+	return sources, nil
+}
+
+// Creates the code root for a folder, which is either a folder of the OS or,
+// if fsys is set, a folder in fsys. Only OS folders can contain symlinks, so
+// the symlink policy does not apply to fsys.
+func makeSourceRoot(fsys fs.FS, dir string, policy SymlinkPolicy, permitted []string) (code_dsl.SourceRoot, error) {
+	if fsys == nil {
+		if dir == "" {
+			dir = "."
+		}
+		return code_dsl.SourceRoot{Dir: dir, FS: code_dsl.NewOSRootFS(dir, policy, permitted)}, nil
+	}
+	if dir == "" {
+		return code_dsl.SourceRoot{FS: fsys}, nil
+	}
+
+	sub, err := fs.Sub(fsys, path.Clean(filepath.ToSlash(dir)))
+	if err != nil {
+		return code_dsl.SourceRoot{}, err
+	}
+	return code_dsl.SourceRoot{Dir: dir, FS: sub}, nil
+}
+
+// Reads a document from r and writes it to w, replacing all DSL commands
+// with the generated code. The document name is only used for diagnostics.
+// Problems with DSL commands are returned as diagnostics, the error is only
+// set if reading or writing failed, ctx was canceled, or the error policy
+// stopped processing.
+//
+// A Processor can process several documents concurrently.
+func (p *Processor) Process(ctx context.Context, document string, r io.Reader, w io.Writer) (Diagnostics, error) {
+	return html_processor.ProcessHTMLDocument(ctx, document, r, w, p.env, p.settings())
+}
+
+// Regenerates an in-place document read from r and writes it to w. DSL
+// commands are kept as hidden marker comments and only the generated code
+// between the fences below each marker is replaced, so the result can be
+// written back to the document and processed again.
+func (p *Processor) ProcessInPlace(ctx context.Context, document string, r io.Reader, w io.Writer) (Diagnostics, error) {
+	return html_processor.ProcessInPlace(ctx, document, r, w, p.env, p.settings())
+}
+
+// Processes a document like Process, but returns the output of every line of
+// the document separately. Joining the outputs with newlines gives the output
+// of Process.
+func (p *Processor) Render(ctx context.Context, document string, r io.Reader) ([]RenderedLine, Diagnostics, error) {
+	return html_processor.RenderHTMLDocument(ctx, document, r, p.env, p.settings())
+}
+
+func (p *Processor) settings() html_processor.Settings {
+	return html_processor.Settings{
+		ErrorPolicy: p.options.ErrorPolicy,
+		Workers:     p.options.Workers,
+	}
+}
+
+// Replaces the DSL command in a single line with the generated code. Lines
+// without a DSL command are returned unchanged.
+func (p *Processor) TransformLine(line string) (string, Diagnostics) {
+	if !code_dsl.ContainsDSLCommand(line) {
+		return line, nil
+	}
+	transformed, diags := code_dsl.TransformLine(line, p.env)
+	if p.options.ErrorPolicy == WarnOnError {
+		for _, d := range diags {
+			d.Severity = SeverityWarning
+		}
+	}
+	return transformed, diags
+}
+
+// Lists all code files that DSL commands in the document read from r depend
+// on. The paths include the folder of the code root the file was found in.
+func (p *Processor) Dependencies(document string, r io.Reader) ([]string, Diagnostics, error) {
+	return html_processor.FindDependencies(document, r, p.env)
+}
+
+// Drops all cached code files, so changed files are read again.
+func (p *Processor) ClearCache() {
+	p.env.Sources.ClearCache()
+}
+
+// Lists all DSL commands of the document read from r.
+func Commands(document string, r io.Reader) ([]CommandLine, Diagnostics, error) {
+	return html_processor.FindCommands(document, r)
+}
+
+// Copies the document read from r to w, rewriting all DSL commands into their
+// canonical form.
+func Format(document string, r io.Reader, w io.Writer) (Diagnostics, error) {
+	return html_processor.FormatHTMLDocument(document, r, w)
+}
@@ -0,0 +1,243 @@
+package remark_code_injector
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var testCodeFS = fstest.MapFS{
+	"code/foo.cpp": &fstest.MapFile{Data: []byte(`int main() {
+  return 0;
+}
+`)},
+}
+
+func makeTestProcessor(t *testing.T, options Options) *Processor {
+	processor, err := NewProcessor(options)
+	if err != nil {
+		t.Fatal("Could not create processor:", err)
+	}
+	return processor
+}
+
+func TestProcessorProcess(t *testing.T) {
+	document := "# Slide\ninsert_code(foo.cpp:1-3){2}[indent=2]\n---"
+
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code"})
+	var out bytes.Buffer
+	diags, err := processor.Process(context.Background(), "slides.md", strings.NewReader(document), &out)
+
+	expected := "# Slide\n```cpp\n  int main() {\n*   return 0;\n  }\n```\n---"
+	if err != nil || len(diags) != 0 {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Error("Processing reported problems.")
+	}
+	if out.String() != expected {
+		t.Logf("output:\n%s\nbut expected\n%s", out.String(), expected)
+		t.Error("Document was wrongly processed.")
+	}
+}
+
+func TestProcessorDefaultOptions(t *testing.T) {
+
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code/", CodeGenOptions: CodeGenOptions{IndentLevel: -2}})
+	line, diags := processor.TransformLine("insert_code(foo.cpp:1-3)")
+
+	expected := "```cpp\nint main() {\nreturn 0;\n}\n```"
+	if len(diags) != 0 || line != expected {
+		t.Logf("line:\n%s\nbut expected\n%s", line, expected)
+		t.Error("Default options were not applied.")
+	}
+}
+
+func TestProcessorErrorPolicies(t *testing.T) {
+	document := "insert_code(missing.cpp:1-3)\ninsert_code(missing.cpp:4-5)"
+
+	var out bytes.Buffer
+	diags, err := makeTestProcessor(t, Options{FS: testCodeFS}).Process(context.Background(), "slides.md", strings.NewReader(document), &out)
+	if err != nil || len(diags) != 2 || !diags.HasErrors() || out.String() != document {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Error("ContinueOnError did not report all errors.")
+	}
+
+	out.Reset()
+	diags, err = makeTestProcessor(t, Options{FS: testCodeFS, ErrorPolicy: StopOnError}).Process(context.Background(), "slides.md", strings.NewReader(document), &out)
+	if err == nil || len(diags) != 1 {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Error("StopOnError did not stop at the first error.")
+	}
+
+	out.Reset()
+	diags, err = makeTestProcessor(t, Options{FS: testCodeFS, ErrorPolicy: WarnOnError}).Process(context.Background(), "slides.md", strings.NewReader(document), &out)
+	if err != nil || len(diags) != 2 || diags.HasErrors() {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Error("WarnOnError did not turn errors into warnings.")
+	}
+}
+
+func TestProcessorConcurrentOutputIsDeterministic(t *testing.T) {
+	document := ""
+	for i := 0; i < 50; i++ {
+		document += "text\ninsert_code(foo.cpp:1-3){" + string(rune('1'+i%3)) + "}\ninsert_code(missing.cpp:1)\n"
+	}
+
+	var sequential bytes.Buffer
+	seqDiags, err := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code", Workers: 1}).Process(
+		context.Background(), "slides.md", strings.NewReader(document), &sequential)
+	if err != nil {
+		t.Fatal("Sequential processing failed:", err)
+	}
+
+	var concurrent bytes.Buffer
+	conDiags, err := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code", Workers: 8}).Process(
+		context.Background(), "slides.md", strings.NewReader(document), &concurrent)
+	if err != nil {
+		t.Fatal("Concurrent processing failed:", err)
+	}
+
+	if sequential.String() != concurrent.String() {
+		t.Error("Concurrent output differs from sequential output.")
+	}
+	if seqDiags.Error() != conDiags.Error() {
+		t.Error("Concurrent diagnostics differ from sequential diagnostics.")
+	}
+}
+
+func TestProcessorCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	_, err := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code"}).Process(
+		ctx, "slides.md", strings.NewReader("insert_code(foo.cpp:1-3)"), &out)
+	if err != context.Canceled {
+		t.Log("err: ", err)
+		t.Error("Canceled processing did not report the cancellation.")
+	}
+}
+
+func TestFormatKeepsOtherLines(t *testing.T) {
+	document := "# Slide\r\ninsert_code(foo.cpp:1-3) [indent=2] {2}\r\ninsert_code(broken\n"
+
+	var out bytes.Buffer
+	diags, err := Format("slides.md", strings.NewReader(document), &out)
+
+	expected := "# Slide\r\ninsert_code(foo.cpp:1-3){2}[indent=2]\r\ninsert_code(broken\n"
+	if err != nil || len(diags) != 1 || diags[0].Line != 3 {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Error("Broken command was not reported.")
+	}
+	if out.String() != expected {
+		t.Logf("output:\n%q\nbut expected\n%q", out.String(), expected)
+		t.Error("Document was wrongly formatted.")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	document := "# Slide\ninsert_code(foo.cpp:1-3)\ntext\nrev_insert_code(foo.cpp:ID)"
+
+	commands, diags, err := Commands("slides.md", strings.NewReader(document))
+	if err != nil || len(diags) != 0 || len(commands) != 2 {
+		t.Fatal("Commands were not found: ", err, diags)
+	}
+	if commands[0].Line != 2 || commands[1].Line != 4 || commands[1].Command.Block.ID != "ID" {
+		t.Log("commands: ", commands)
+		t.Error("Commands were wrongly reported.")
+	}
+}
+
+func TestProcessorLanguages(t *testing.T) {
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code", Languages: map[string]string{"cpp": "c++"}})
+	line, diags := processor.TransformLine("insert_code(foo.cpp:1)")
+	if len(diags) != 0 || !strings.HasPrefix(line, "```c++\n") {
+		t.Log("line: ", line)
+		t.Error("Language mapping was not applied.")
+	}
+}
+
+func TestProcessorCodeRootsAndAliases(t *testing.T) {
+	fsys := fstest.MapFS{
+		"service/main.cpp": &fstest.MapFile{Data: []byte("int service;\n")},
+		"sdk/client.cpp":   &fstest.MapFile{Data: []byte("int client;\n")},
+		"sdk/main.cpp":     &fstest.MapFile{Data: []byte("int sdk;\n")},
+	}
+	processor := makeTestProcessor(t, Options{
+		FS:        fsys,
+		CodeRoots: []string{"service", "sdk"},
+		Aliases:   map[string]string{"sdk": "sdk"},
+	})
+
+	document := "insert_code(client.cpp:1)\ninsert_code(@sdk/main.cpp:1)"
+	var out bytes.Buffer
+	diags, err := processor.Process(context.Background(), "slides.md", strings.NewReader(document), &out)
+	expected := "```cpp\nint client;\n```\n```cpp\nint sdk;\n```"
+	if err != nil || len(diags) != 0 || out.String() != expected {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Logf("output:\n%s\nbut expected\n%s", out.String(), expected)
+		t.Error("Code roots or aliases were not used.")
+	}
+
+	deps, diags, err := processor.Dependencies("slides.md", strings.NewReader(document))
+	if err != nil || len(diags) != 0 || len(deps) != 2 || deps[0] != "sdk/client.cpp" || deps[1] != "sdk/main.cpp" {
+		t.Log("dependencies: ", deps, " diagnostics: ", diags)
+		t.Error("Dependencies were not resolved.")
+	}
+}
+
+func TestProcessorProcessInPlace(t *testing.T) {
+	document := "# Slide\ninsert_code(foo.cpp:1){1}\nSome text\n"
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code"})
+
+	var out bytes.Buffer
+	diags, err := processor.ProcessInPlace(context.Background(), "slides.md", strings.NewReader(document), &out)
+	expected := "# Slide\n<!-- insert_code(foo.cpp:1){1} -->\n<!-- begin generated code -->\n```cpp\n*int main() {\n```\n<!-- end generated code -->\nSome text\n"
+	if err != nil || len(diags) != 0 || out.String() != expected {
+		t.Log("err: ", err, " diagnostics: ", diags)
+		t.Logf("output:\n%s\nbut expected\n%s", out.String(), expected)
+		t.Fatal("Raw document was wrongly converted.")
+	}
+
+	// Edits outside of the fences survive, the generated code is replaced.
+	edited := strings.Replace(expected, "Some text", "Edited text", 1)
+	edited = strings.Replace(edited, "*int main() {", "stale", 1)
+	out.Reset()
+	processor.ProcessInPlace(context.Background(), "slides.md", strings.NewReader(edited), &out)
+	if out.String() != strings.Replace(expected, "Some text", "Edited text", 1) {
+		t.Logf("output:\n%s", out.String())
+		t.Error("Document was not regenerated in place.")
+	}
+
+	again := out.String()
+	out.Reset()
+	processor.ProcessInPlace(context.Background(), "slides.md", strings.NewReader(again), &out)
+	if out.String() != again {
+		t.Error("Processing in place is not idempotent.")
+	}
+}
+
+func TestProcessInPlaceKeepsGeneratedCodeOnError(t *testing.T) {
+	document := "<!-- insert_code(missing.cpp:1) -->\n<!-- begin generated code -->\nold code\n<!-- end generated code -->"
+	processor := makeTestProcessor(t, Options{FS: testCodeFS, CodeRoot: "code"})
+
+	var out bytes.Buffer
+	diags, _ := processor.ProcessInPlace(context.Background(), "slides.md", strings.NewReader(document), &out)
+	if out.String() != document {
+		t.Logf("output:\n%s", out.String())
+		t.Error("Generated code of a failing command was changed.")
+	}
+	if len(diags) != 1 || diags[0].Line != 1 || diags[0].Column != 18 {
+		t.Log("diagnostics: ", diags)
+		t.Error("Error was not reported at the filename in the marker.")
+	}
+
+	out.Reset()
+	unclosed := "<!-- insert_code(foo.cpp:1) -->\n<!-- begin generated code -->\nold code"
+	diags, _ = processor.ProcessInPlace(context.Background(), "slides.md", strings.NewReader(unclosed), &out)
+	if out.String() != unclosed || !diags.HasErrors() {
+		t.Log("diagnostics: ", diags)
+		t.Error("Unclosed generated code was not reported.")
+	}
+}